@@ -3,8 +3,11 @@
 package client
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"strings"
@@ -52,14 +55,23 @@ func (c HTTPClient) Reload() error {
 }
 
 // SetMaintenance makes a request to either the enable or disable maintenance
-// endpoint of a ContainerPilot process.
-func (c HTTPClient) SetMaintenance(isEnabled bool) error {
+// endpoint of a ContainerPilot process. The reason is sent along when
+// enabling maintenance mode.
+func (c HTTPClient) SetMaintenance(isEnabled bool, reason string) error {
 	flag := "disable"
+	var body io.Reader
 	if isEnabled {
 		flag = "enable"
+		if reason != "" {
+			blob, err := json.Marshal(map[string]string{"reason": reason})
+			if err != nil {
+				return err
+			}
+			body = bytes.NewReader(blob)
+		}
 	}
 
-	resp, err := c.Post("http://control/v3/maintenance/"+flag, "application/json", nil)
+	resp, err := c.Post("http://control/v3/maintenance/"+flag, "application/json", body)
 	if err != nil {
 		return err
 	}
@@ -6,6 +6,7 @@ package commands
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -30,6 +31,12 @@ type Command struct {
 	logger  log.Entry
 	lock    *sync.Mutex
 	fields  log.Fields
+
+	// OutputLimit is the number of bytes at the end of the output of
+	// each run to keep for Output; the output isn't kept if it's 0
+	OutputLimit int
+	output      string
+	outputLock  sync.RWMutex
 }
 
 // NewCommand parses JSON config into a Command
@@ -84,6 +91,12 @@ func (c *Command) EnvName() string {
 // If the parent context is closed/canceled this will terminate the
 // child process and do any cleanup we need.
 func (c *Command) Run(pctx context.Context, bus *events.EventBus) {
+	c.RunWithEnv(pctx, bus, nil)
+}
+
+// RunWithEnv is Run with environment variables ("KEY=value") that are
+// added to ContainerPilot's own environment for this run of the process
+func (c *Command) RunWithEnv(pctx context.Context, bus *events.EventBus, env []string) {
 	if c == nil {
 		log.Debugf("nothing to run for %s", c.Name)
 		return
@@ -94,6 +107,9 @@ func (c *Command) Run(pctx context.Context, bus *events.EventBus) {
 	log.Debugf("%s.Run start", c.Name)
 
 	cmd := exec.Command(c.Exec, c.Args...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
 	if c.logger.Logger != nil {
 		cmd.Stdout = c.logger.Writer()
 		cmd.Stderr = c.logger.Writer()
@@ -101,6 +117,12 @@ func (c *Command) Run(pctx context.Context, bus *events.EventBus) {
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
 	}
+	var tail *tailBuffer
+	if c.OutputLimit > 0 {
+		tail = newTailBuffer(c.OutputLimit)
+		cmd.Stdout = io.MultiWriter(cmd.Stdout, tail)
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, tail)
+	}
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 	c.Cmd = cmd
 	ctx, cancel := getContext(pctx, c.Timeout)
@@ -126,6 +148,7 @@ func (c *Command) Run(pctx context.Context, bus *events.EventBus) {
 		defer log.Debugf("%s.Run end", c.Name)
 		if err := c.Cmd.Start(); err != nil {
 			log.Errorf("unable to start %s: %v", c.Name, err)
+			c.setOutput(err.Error())
 			bus.Publish(events.Event{events.ExitFailed, c.Name})
 			bus.Publish(events.Event{events.Error, err.Error()})
 			return
@@ -148,7 +171,9 @@ func (c *Command) Run(pctx context.Context, bus *events.EventBus) {
 
 		// blocks this goroutine here; if the context gets cancelled
 		// we'll return from Wait() and publish events
-		if err := c.Cmd.Wait(); err != nil {
+		err := c.Cmd.Wait()
+		c.setOutput(runOutput(tail, err, ctx.Err(), c.Timeout))
+		if err != nil {
 			log.Errorf("%s exited with error: %v", c.Name, err)
 			bus.Publish(events.Event{events.ExitFailed, c.Name})
 			bus.Publish(events.Event{events.Error,
@@ -160,6 +185,38 @@ func (c *Command) Run(pctx context.Context, bus *events.EventBus) {
 	}()
 }
 
+// runOutput returns the output kept from a run of a Command, noting
+// whether it timed out
+func runOutput(tail *tailBuffer, err, ctxErr error, timeout time.Duration) string {
+	output := ""
+	if tail != nil {
+		output = tail.String()
+	}
+	if err != nil && ctxErr == context.DeadlineExceeded {
+		msg := fmt.Sprintf("timeout after %s", timeout)
+		if output != "" {
+			msg = output + "\n" + msg
+		}
+		output = msg
+	}
+	return output
+}
+
+func (c *Command) setOutput(output string) {
+	c.outputLock.Lock()
+	defer c.outputLock.Unlock()
+	c.output = output
+}
+
+// Output returns the end of the output of the last run of the Command,
+// up to OutputLimit bytes, or the reason it couldn't be started. It's
+// updated before the Command's exit event is published.
+func (c *Command) Output() string {
+	c.outputLock.RLock()
+	defer c.outputLock.RUnlock()
+	return c.output
+}
+
 func getContext(pctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
 	if timeout > 0 {
 		return context.WithTimeout(pctx, timeout)
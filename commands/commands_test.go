@@ -89,6 +89,30 @@ func TestCommandPassthru(t *testing.T) {
 	assert.NotEqual(t, cmd.Cmd.Stdout, os.Stdout)
 }
 
+func TestCommandOutput(t *testing.T) {
+	cmd, _ := NewCommand("./testdata/test.sh failStuff --debug", time.Duration(0), nil)
+	cmd.OutputLimit = 20
+	runtestCommandRun(cmd)
+	assert.Equal(t, "with args: --debug", cmd.Output())
+
+	cmd, _ = NewCommand("./testdata/test.sh doStuff", time.Duration(0), nil)
+	cmd.OutputLimit = 4096
+	runtestCommandRun(cmd)
+	assert.Equal(t, "Running doStuff with args:", cmd.Output())
+
+	cmd, _ = NewCommand("./testdata/test.sh sleepStuff",
+		time.Duration(100*time.Millisecond), nil)
+	cmd.OutputLimit = 4096
+	runtestCommandRun(cmd)
+	assert.Equal(t, "Sleeping 10 seconds...\ntimeout after 100ms", cmd.Output())
+
+	cmd, _ = NewCommand("./testdata/invalidCommand", time.Duration(0), nil)
+	cmd.OutputLimit = 4096
+	runtestCommandRun(cmd)
+	assert.Equal(t, "fork/exec ./testdata/invalidCommand: no such file or directory",
+		cmd.Output())
+}
+
 func TestEnvName(t *testing.T) {
 	tests := []struct {
 		name, input, output string
@@ -0,0 +1,41 @@
+package commands
+
+import (
+	"strings"
+	"sync"
+)
+
+// tailBuffer is an io.Writer that keeps only the last limit bytes
+// written to it, so that we can capture the end of a Command's output
+// without holding onto all of it.
+type tailBuffer struct {
+	limit int
+	buf   []byte
+	lock  sync.Mutex
+}
+
+func newTailBuffer(limit int) *tailBuffer {
+	return &tailBuffer{limit: limit}
+}
+
+// Write implements io.Writer; it never fails.
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	n := len(p)
+	if n >= t.limit {
+		t.buf = append(t.buf[:0], p[n-t.limit:]...)
+		return n, nil
+	}
+	t.buf = append(t.buf, p...)
+	if over := len(t.buf) - t.limit; over > 0 {
+		t.buf = append(t.buf[:0], t.buf[over:]...)
+	}
+	return n, nil
+}
+
+func (t *tailBuffer) String() string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return strings.TrimSpace(string(t.buf))
+}
@@ -7,6 +7,8 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"sort"
 	"strings"
 
 	"github.com/flynn/json5"
@@ -18,15 +20,18 @@ import (
 	"github.com/joyent/containerpilot/discovery"
 	"github.com/joyent/containerpilot/jobs"
 	"github.com/joyent/containerpilot/telemetry"
+	"github.com/joyent/containerpilot/templates"
 	"github.com/joyent/containerpilot/watches"
 )
 
 type rawConfig struct {
 	consul      interface{}
+	discovery   interface{}
 	logConfig   *logger.Config
 	stopTimeout int
 	jobs        []interface{}
 	watches     []interface{}
+	templates   []interface{}
 	telemetry   interface{}
 	control     interface{}
 }
@@ -38,6 +43,7 @@ type Config struct {
 	StopTimeout int
 	Jobs        []*jobs.Config
 	Watches     []*watches.Config
+	Templates   []*templates.Config
 	Telemetry   *telemetry.Config
 	Control     *control.Config
 }
@@ -63,16 +69,22 @@ func (cfg *rawConfig) parseStopTimeout() (int, error) {
 	return cfg.stopTimeout, nil
 }
 
-// RenderConfig renders the templated config in configFlag to renderFlag.
-func RenderConfig(configFlag, renderFlag string) error {
+// RenderConfig renders the templated config in configFlag to renderFlag,
+// followed by a comment listing any overrides from the environment.
+func RenderConfig(configFlag, formatFlag, renderFlag string) error {
 	configData, err := loadConfigFile(configFlag)
 	if err != nil {
 		return err
 	}
+	format, err := detectFormat(configFlag, formatFlag)
+	if err != nil {
+		return err
+	}
 	renderedConfig, err := renderConfigTemplate(configData)
 	if err != nil {
 		return err
 	}
+	renderedConfig = append(renderedConfig, overridesComment(renderedConfig, format)...)
 
 	// Save the rendered template, either to stdout or to file
 	if renderFlag == "-" || renderFlag == "" {
@@ -87,17 +99,23 @@ func RenderConfig(configFlag, renderFlag string) error {
 	return nil
 }
 
-// LoadConfig loads, parses, and validates the configuration
-func LoadConfig(configFlag string) (*Config, error) {
+// LoadConfig loads, parses, and validates the configuration. The file
+// format is taken from formatFlag if set, or otherwise from the extension
+// of the file in configFlag.
+func LoadConfig(configFlag, formatFlag string) (*Config, error) {
 	configData, err := loadConfigFile(configFlag)
 	if err != nil {
 		return nil, err
 	}
+	format, err := detectFormat(configFlag, formatFlag)
+	if err != nil {
+		return nil, err
+	}
 	renderedConfig, err := renderConfigTemplate(configData)
 	if err != nil {
 		return nil, err
 	}
-	config, err := newConfig(renderedConfig)
+	config, err := newConfig(renderedConfig, format)
 	if err != nil {
 		return nil, err
 	}
@@ -124,22 +142,45 @@ func renderConfigTemplate(configData []byte) ([]byte, error) {
 }
 
 // newConfig unmarshals the textual configuration data into the
-// validated Config struct that we'll use the run the application
-func newConfig(configData []byte) (*Config, error) {
-	configMap, err := unmarshalConfig(configData)
+// validated Config struct that we'll use the run the application.
+// Every section is validated even if an earlier one is invalid, so
+// that all problems are returned together in a decode.Errors.
+func newConfig(configData []byte, format string) (*Config, error) {
+	configMap, err := unmarshalConfig(configData, format)
 	if err != nil {
 		return nil, err
 	}
 
-	raw := &rawConfig{}
-	if err = decodeConfig(configMap, raw); err != nil {
-		return nil, err
+	if configMap == nil {
+		configMap = map[string]interface{}{}
 	}
+
+	var errs decode.Errors
+	applyOverrides(configMap, getOverrides(os.Environ()), &errs)
+	raw := &rawConfig{}
+	decodeConfig(configMap, raw, &errs)
 	cfg := &Config{}
 
-	disc, err := discovery.NewConsul(raw.consul)
-	if err != nil {
-		return nil, err
+	// we don't want a typed nil in the Backend interface if the discovery
+	// config is invalid, but we still want to validate everything else
+	var disc discovery.Backend
+	switch {
+	case raw.discovery != nil && raw.consul != nil:
+		errs.Add("discovery", errors.New("can't be used along with 'consul'"))
+	case raw.discovery != nil:
+		backend, err := discovery.NewBackend(raw.discovery)
+		if err != nil {
+			errs.Add("discovery", err)
+		} else {
+			disc = backend
+		}
+	default:
+		consul, err := discovery.NewConsul(raw.consul)
+		if err != nil {
+			errs.Add("consul", err)
+		} else {
+			disc = consul
+		}
 	}
 	cfg.Discovery = disc
 
@@ -147,52 +188,67 @@ func newConfig(configData []byte) (*Config, error) {
 
 	stopTimeout, err := raw.parseStopTimeout()
 	if err != nil {
-		return nil, err
+		errs.Add("stopTimeout", err)
 	}
 	cfg.StopTimeout = stopTimeout
 
 	controlConfig, err := control.NewConfig(raw.control)
 	if err != nil {
-		return nil, fmt.Errorf("unable to parse control: %v", err)
+		errs.Add("control", err)
 	}
 	cfg.Control = controlConfig
 
 	jobConfigs, err := jobs.NewConfigs(raw.jobs, disc)
 	if err != nil {
-		return nil, fmt.Errorf("unable to parse jobs: %v", err)
+		errs.Add("", err) // paths already include "jobs[n]"
 	}
 	cfg.Jobs = jobConfigs
 
-	watches, err := watches.NewConfigs(raw.watches, disc)
-	if err != nil {
-		return nil, fmt.Errorf("unable to parse watches: %v", err)
+	watches, watchesErr := watches.NewConfigs(raw.watches, disc)
+	if watchesErr != nil {
+		errs.Add("", watchesErr) // paths already include "watches[n]"
 	}
 	cfg.Watches = watches
 
+	templates, err := templates.NewConfigs(raw.templates, disc)
+	if err != nil {
+		errs.Add("", err) // paths already include "templates[n]"
+	} else if watchesErr == nil {
+		// with any invalid watches or templates we can't match them up
+		checkTemplateWatches(templates, watches, &errs)
+	}
+	cfg.Templates = templates
+
 	telemetry, err := telemetry.NewConfig(raw.telemetry, disc)
 	if err != nil {
-		return nil, err
+		errs.Add("telemetry", err)
 	}
 	if telemetry != nil {
 		cfg.Telemetry = telemetry
 		cfg.Jobs = append(cfg.Jobs, telemetry.JobConfig)
 	}
 
+	if err := errs.ErrorOrNil(); err != nil {
+		return nil, err
+	}
 	return cfg, nil
 }
 
-func unmarshalConfig(data []byte) (map[string]interface{}, error) {
-	var config map[string]interface{}
-	if err := json5.Unmarshal(data, &config); err != nil {
-		syntax, ok := err.(*json5.SyntaxError)
-		if !ok {
-			return nil, fmt.Errorf(
-				"could not parse configuration: %s",
-				err)
+// checkTemplateWatches reports each watch that a template depends on but
+// that isn't configured, because the template would never render again
+func checkTemplateWatches(tmpls []*templates.Config, watchCfgs []*watches.Config, errs *decode.Errors) {
+	configured := map[string]bool{}
+	for _, watch := range watchCfgs {
+		configured[watch.Name] = true
+	}
+	for i, tmpl := range tmpls {
+		for j, watch := range tmpl.Watches {
+			if !configured["watch."+watch] {
+				errs.Add(fmt.Sprintf("templates[%d].watches[%d]", i, j),
+					fmt.Errorf("no watch named '%s' is configured", watch))
+			}
 		}
-		return nil, newJSONparseError(data, syntax)
 	}
-	return config, nil
 }
 
 func newJSONparseError(js []byte, syntax *json5.SyntaxError) error {
@@ -234,36 +290,40 @@ func highlightError(data []byte, pos int64) (int, int, string) {
 // We can't use mapstructure to decode our config map since we want the values
 // to also be raw interface{} types. mapstructure can only decode
 // into concrete structs and primitives
-func decodeConfig(configMap map[string]interface{}, result *rawConfig) error {
+func decodeConfig(configMap map[string]interface{}, result *rawConfig, errs *decode.Errors) {
 	var logConfig logger.Config
 	var stopTimeout int
 	if err := decode.ToStruct(configMap["logging"], &logConfig); err != nil {
-		return err
+		errs.Add("logging", err)
 	}
 	if err := decode.ToStruct(configMap["stopTimeout"], &stopTimeout); err != nil {
-		return err
+		errs.Add("stopTimeout", err)
 	}
 	result.consul = configMap["consul"]
+	result.discovery = configMap["discovery"]
 	result.stopTimeout = stopTimeout
 	result.logConfig = &logConfig
 	result.control = configMap["control"]
 	result.jobs = decode.ToSlice(configMap["jobs"])
 	result.watches = decode.ToSlice(configMap["watches"])
+	result.templates = decode.ToSlice(configMap["templates"])
 	result.telemetry = configMap["telemetry"]
 
 	delete(configMap, "consul")
+	delete(configMap, "discovery")
 	delete(configMap, "logging")
 	delete(configMap, "control")
 	delete(configMap, "stopTimeout")
 	delete(configMap, "jobs")
 	delete(configMap, "watches")
+	delete(configMap, "templates")
 	delete(configMap, "telemetry")
 	var unused []string
 	for key := range configMap {
 		unused = append(unused, key)
 	}
-	if len(unused) > 0 {
-		return fmt.Errorf("unknown config keys: %v", unused)
+	sort.Strings(unused)
+	for _, key := range unused {
+		errs.Add(key, errors.New("unknown configuration key"))
 	}
-	return nil
 }
@@ -4,9 +4,15 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/joyent/containerpilot/config/decode"
+	"github.com/joyent/containerpilot/config/schema"
+	"github.com/joyent/containerpilot/discovery"
+	"github.com/joyent/containerpilot/jobs"
 )
 
 /*
@@ -20,7 +26,7 @@ func TestValidConfigJobs(t *testing.T) {
 
 	assert := assert.New(t)
 	os.Setenv("TEST", "HELLO")
-	cfg, err := LoadConfig("./testdata/test.json5")
+	cfg, err := LoadConfig("./testdata/test.json5", "")
 	if err != nil {
 		t.Fatalf("unexpected error in LoadConfig: %v", err)
 	}
@@ -92,7 +98,7 @@ func TestValidConfigJobs(t *testing.T) {
 // telemetry.Config
 func TestValidConfigTelemetry(t *testing.T) {
 	os.Setenv("TEST", "HELLO")
-	cfg, err := LoadConfig("./testdata/test.json5")
+	cfg, err := LoadConfig("./testdata/test.json5", "")
 	if err != nil {
 		t.Fatalf("unexpected error in LoadConfig: %v", err)
 	}
@@ -107,7 +113,7 @@ func TestValidConfigTelemetry(t *testing.T) {
 // watches.Config
 func TestValidConfigWatches(t *testing.T) {
 	os.Setenv("TEST", "HELLO")
-	cfg, err := LoadConfig("./testdata/test.json5")
+	cfg, err := LoadConfig("./testdata/test.json5", "")
 	if err != nil {
 		t.Fatalf("unexpected error in LoadConfig: %v", err)
 	}
@@ -125,9 +131,35 @@ func TestValidConfigWatches(t *testing.T) {
 	assert.Equal(t, watch1.Tag, "", "config for Tag")
 }
 
+// templates.Config
+func TestConfigTemplateWatches(t *testing.T) {
+	source, _ := ioutil.TempFile("", "template")
+	defer os.Remove(source.Name())
+	source.WriteString("upstream")
+	source.Close()
+
+	testJSON := `{
+	"consul": "consul:8500",
+	"watches": [{"name": "app", "interval": 1}],
+	"templates": [{"name": "upstream", "source": "` + source.Name() + `",
+	               "destination": "/tmp/upstream.conf",
+	               "watches": ["app", "db"]}]
+	}`
+	_, err := newConfig([]byte(testJSON), formatJSON5)
+	assert.EqualError(t, err,
+		"templates[0].watches[1]: no watch named 'db' is configured")
+
+	testJSON = strings.Replace(testJSON, `"db"`, `"app"`, 1)
+	cfg, err := newConfig([]byte(testJSON), formatJSON5)
+	if err != nil {
+		t.Fatalf("unexpected error in newConfig: %v", err)
+	}
+	assert.Equal(t, "template.upstream", cfg.Templates[0].Name)
+}
+
 // control.Config
 func TestValidConfigControl(t *testing.T) {
-	cfg, err := LoadConfig("./testdata/test.json5")
+	cfg, err := LoadConfig("./testdata/test.json5", "")
 	if err != nil {
 		t.Fatalf("unexpected error in LoadConfig: %v", err)
 	}
@@ -141,7 +173,7 @@ func TestCustomConfigControl(t *testing.T) {
 	"control": {"socket": "/var/run/cp3-test.sock"},
 	"consul": "consul:8500"}`
 
-	cfg, err := newConfig([]byte(testJSONWithSocket))
+	cfg, err := newConfig([]byte(testJSONWithSocket), formatJSON5)
 	if err != nil {
 		t.Fatalf("unexpected error in LoadConfig: %v", err)
 	}
@@ -150,14 +182,401 @@ func TestCustomConfigControl(t *testing.T) {
 		"config for control.socket")
 }
 
+func TestConfigDiscovery(t *testing.T) {
+	cfg, err := newConfig([]byte(
+		`{discovery: {etcd: {endpoints: ["etcd:2379"]}}}`), formatJSON5)
+	if err != nil {
+		t.Fatalf("unexpected error in newConfig: %v", err)
+	}
+	assert.IsType(t, &discovery.Etcd{}, cfg.Discovery)
+
+	cfg, err = newConfig([]byte(
+		`{discovery: {consul: "consul:8500"}}`), formatJSON5)
+	if err != nil {
+		t.Fatalf("unexpected error in newConfig: %v", err)
+	}
+	assert.IsType(t, &discovery.Consul{}, cfg.Discovery)
+
+	cfg, err = newConfig([]byte(
+		`{discovery: {file: "/tmp/services.json"}}`), formatJSON5)
+	if err != nil {
+		t.Fatalf("unexpected error in newConfig: %v", err)
+	}
+	assert.IsType(t, &discovery.File{}, cfg.Discovery)
+
+	_, err = newConfig([]byte(`{consul: "consul:8500",
+	discovery: {etcd: "etcd:2379"}}`), formatJSON5)
+	assert.EqualError(t, err,
+		"discovery: can't be used along with 'consul'")
+
+	_, err = newConfig([]byte(`{discovery: {consul: "consul:8500",
+	etcd: "etcd:2379"}}`), formatJSON5)
+	assert.EqualError(t, err,
+		"discovery: only one discovery backend can be configured")
+
+	_, err = newConfig([]byte(`{discovery: {}}`), formatJSON5)
+	assert.EqualError(t, err, "discovery: no discovery backend defined")
+}
+
+// YAML and TOML configurations should produce the same Config as JSON5
+func TestValidConfigAlternateFormats(t *testing.T) {
+	os.Setenv("TEST", "HELLO")
+	for _, path := range []string{"./testdata/test.yaml", "./testdata/test.toml"} {
+		cfg, err := LoadConfig(path, "")
+		if err != nil {
+			t.Fatalf("unexpected error in LoadConfig(%s): %v", path, err)
+		}
+		if len(cfg.Jobs) != 5 {
+			t.Fatalf("%s: expected 5 jobs but got %v", path, cfg.Jobs)
+		}
+		job0 := cfg.Jobs[0]
+		assert.Equal(t, job0.Name, "serviceA", path+": config for job0.Name")
+		assert.Equal(t, job0.Port, 8080, path+": config for job0.Port")
+		assert.Equal(t, job0.Exec, "/bin/serviceA", path+": config for job0.Exec")
+		assert.Equal(t, job0.Tags, []string{"tag1", "tag2"}, path+": config for job0.Tags")
+
+		job1 := cfg.Jobs[1]
+		assert.Equal(t, job1.Exec, []interface{}{"/bin/serviceB", "B"},
+			path+": config for job1.Exec")
+		assert.Equal(t, job1.Restarts, nil, path+": config for job1.Restarts")
+
+		job2 := cfg.Jobs[2]
+		assert.Equal(t, job2.Restarts, "unlimited", path+": config for job2.Restarts")
+
+		job3 := cfg.Jobs[3]
+		assert.Equal(t, job3.Exec, "/bin/to/preStart.sh HELLO",
+			path+": config for job3.Exec")
+
+		assert.Equal(t, cfg.Watches[0].Name, "watch.upstreamA", path+": config for Name")
+		assert.Equal(t, cfg.Watches[0].Poll, 11, path+": config for Poll")
+		assert.Equal(t, cfg.Telemetry.Port, 9000, path+": config for telem.Port")
+		assert.Equal(t, cfg.Telemetry.MetricConfigs[0].Name, "zed",
+			path+": config for metric0.Name")
+		assert.Equal(t, cfg.StopTimeout, 5, path+": config for StopTimeout")
+	}
+}
+
+func TestConfigFormatFlag(t *testing.T) {
+	// an explicit format overrides the file extension
+	_, err := LoadConfig("./testdata/test.yaml", "toml")
+	if err == nil {
+		t.Fatalf("expected error parsing YAML as TOML but got nil")
+	}
+	_, err = LoadConfig("./testdata/test.yaml", "xml")
+	assert.EqualError(t, err,
+		"unsupported config format 'xml': must be one of json5, yaml, toml")
+}
+
+func TestDetectFormat(t *testing.T) {
+	detect := func(path, flag string) string {
+		format, _ := detectFormat(path, flag)
+		return format
+	}
+	assert.Equal(t, formatJSON5, detect("/etc/containerpilot.json5", ""))
+	assert.Equal(t, formatJSON5, detect("/etc/containerpilot", ""))
+	assert.Equal(t, formatYAML, detect("/etc/containerpilot.yaml", ""))
+	assert.Equal(t, formatYAML, detect("/etc/containerpilot.YML", ""))
+	assert.Equal(t, formatTOML, detect("/etc/containerpilot.toml", ""))
+	assert.Equal(t, formatYAML, detect("/etc/containerpilot.json5", "yaml"))
+	assert.Equal(t, formatJSON5, detect("/etc/containerpilot.toml", "json5"))
+}
+
+func TestAlternateFormatParseErrors(t *testing.T) {
+	_, err := newConfig([]byte("consul: consul:8500\njobs:\n  - name: [\n"), formatYAML)
+	if err == nil || !strings.HasPrefix(err.Error(), "parse error at line") {
+		t.Fatalf("expected YAML parse error with line number but got: %v", err)
+	}
+	_, err = newConfig([]byte("consul = \"consul:8500\"\n[[jobs]\nname = \"x\"\n"), formatTOML)
+	if err == nil || !strings.HasPrefix(err.Error(), "parse error at line:col [2:8]") {
+		t.Fatalf("expected TOML parse error at line 2 but got: %v", err)
+	}
+	_, err = newConfig([]byte("consul = \"consul:8500\"\nlogging = = 1\n"), formatTOML)
+	assert.EqualError(t, err, `parse error at line:col [2:11]: Near line 2 (last key parsed 'logging'): expected value but found '=' instead
+    1: consul = "consul:8500"
+    2: logging = = 1
+-----------------^`)
+	_, err = newConfig([]byte("consul:\n  1: consul:8500\n"), formatYAML)
+	assert.EqualError(t, err,
+		"could not parse configuration: map key 1 must be a string")
+}
+
+func TestConfigAllErrors(t *testing.T) {
+	var testJSON = `{
+	"consul": "consul:8500",
+	"logging": {"levle": "DEBUG"},
+	"control": {"sockett": "/tmp/cp.sock"},
+	"jobs": [{"name": "a", "exec": "/bin/a", "health": {"exec": "/bin/true", "interval": 1}}],
+	"watches": [{"name": "b", "interval": 1, "tags": "dev"}],
+	"typo": 1
+	}`
+	_, err := newConfig([]byte(testJSON), formatJSON5)
+	errs, ok := err.(decode.Errors)
+	if !ok {
+		t.Fatalf("expected decode.Errors but got %T: %v", err, err)
+	}
+	paths := []string{}
+	for _, e := range errs {
+		paths = append(paths, e.Path)
+	}
+	assert.Equal(t, []string{
+		"logging.levle",
+		"typo",
+		"control.sockett",
+		"jobs[0]",
+		"watches[0].tags",
+	}, paths)
+}
+
+func TestSchema(t *testing.T) {
+	s := Schema()
+	props := s["properties"].(schema.Schema)
+
+	// every top-level key handled by decodeConfig should be described
+	configMap := map[string]interface{}{}
+	for key := range props {
+		configMap[key] = nil
+	}
+	var errs decode.Errors
+	decodeConfig(configMap, &rawConfig{}, &errs)
+	assert.Equal(t, 0, len(configMap), "top-level keys in schema but not config")
+	assert.Equal(t, 9, len(props), "top-level keys in config but not schema")
+
+	jobProps := props["jobs"].(schema.Schema)["items"].(schema.Schema)["properties"].(schema.Schema)
+	for _, key := range []string{"name", "exec", "port", "health", "when", "restarts"} {
+		assert.Contains(t, jobProps, key)
+	}
+	telemProps := props["telemetry"].(schema.Schema)["properties"].(schema.Schema)
+	metricProps := telemProps["metrics"].(schema.Schema)["items"].(schema.Schema)["properties"].(schema.Schema)
+	assert.Equal(t, schema.Schema{"type": "string",
+		"enum": []string{"counter", "gauge", "histogram", "summary"}}, metricProps["type"])
+}
+
+func TestConvertV2(t *testing.T) {
+	data, _ := ioutil.ReadFile("./testdata/TestConvertV2.json")
+	converted, warnings, err := convertV2(data, []string{"/bin/app", "-v"})
+	if err != nil {
+		t.Fatalf("unexpected error in convertV2: %v", err)
+	}
+	assert.Equal(t, []string{
+		"'frobnicate' is not a v2 configuration field and was dropped",
+		"sensor 'zed' was converted to a job that passes the output of " +
+			"its check to 'containerpilot -putmetric'; consider updating " +
+			"the check to call -putmetric itself",
+	}, warnings)
+
+	// the template directive is kept as-is in the output
+	assert.Contains(t, string(converted), "{{.ENV_VAR_NAME}}")
+
+	os.Setenv("ENV_VAR_NAME", "x")
+	rendered, _ := renderConfigTemplate(converted)
+	cfg, err := newConfig(rendered, formatJSON5)
+	if err != nil {
+		t.Fatalf("converted config is not valid: %v\n%s", err, converted)
+	}
+	names := []string{}
+	for _, job := range cfg.Jobs {
+		names = append(names, job.Name)
+	}
+	assert.Equal(t, []string{"preStart", "app", "preStop", "postStop",
+		"onChange-nginx", "consul-agent", "task1", "sensor-zed",
+		"containerpilot"}, names)
+
+	app := cfg.Jobs[1]
+	assert.Equal(t, []interface{}{"/bin/app", "-v"}, app.Exec)
+	assert.Equal(t, 80, app.Port)
+	assert.Equal(t, &jobs.WhenConfig{Source: "preStart", Once: "exitSuccess"}, app.When)
+	assert.Equal(t, 10, app.Health.Heartbeat)
+	assert.Equal(t, 25, app.Health.TTL)
+	assert.Equal(t, "500ms", app.Health.CheckTimeout)
+	assert.Equal(t, true, app.ConsulExtras.EnableTagOverride)
+
+	assert.Equal(t, &jobs.WhenConfig{Source: "app", Once: "stopping"}, cfg.Jobs[2].When)
+	assert.Equal(t, &jobs.WhenConfig{Source: "app", Once: "stopped"}, cfg.Jobs[3].When)
+	assert.Equal(t, &jobs.WhenConfig{Source: "watch.nginx", Each: "changed"}, cfg.Jobs[4].When)
+	assert.Equal(t, "unlimited", cfg.Jobs[5].Restarts)
+	assert.Equal(t, &jobs.WhenConfig{Frequency: "1500ms"}, cfg.Jobs[6].When)
+	assert.Equal(t, []interface{}{"/bin/sh", "-c",
+		"containerpilot -putmetric org_app_zed=$('/usr/local/bin/sensor.sh' 'zed')"},
+		cfg.Jobs[7].Exec)
+
+	assert.Equal(t, "watch.nginx", cfg.Watches[0].Name)
+	assert.Equal(t, 30, cfg.Watches[0].Poll)
+	assert.Equal(t, "prod", cfg.Watches[0].Tag)
+	assert.Equal(t, "watch.app", cfg.Watches[1].Name)
+	assert.Equal(t, "zed", cfg.Telemetry.MetricConfigs[0].Name)
+}
+
+func TestConvertV2NoCommand(t *testing.T) {
+	data := []byte(`{"consul": "consul:8500", "etcd": {}, "preStop": "/bin/stop"}`)
+	converted, warnings, err := convertV2(data, nil)
+	if err != nil {
+		t.Fatalf("unexpected error in convertV2: %v", err)
+	}
+	assert.Equal(t, 3, len(warnings), "warnings for etcd, no command, and preStop")
+	assert.Equal(t, "{\n  \"consul\": \"consul:8500\"\n}", string(converted))
+}
+
+func TestConvertV2Etcd(t *testing.T) {
+	data := []byte(`{
+	"etcd": {"endpoints": "http://etcd:4001", "prefix": "/containerpilot"},
+	"services": [{"name": "app", "port": 80, "health": "/bin/check",
+	              "poll": 5, "ttl": 10}]
+}`)
+	converted, warnings, err := convertV2(data, []string{"/bin/app"})
+	if err != nil {
+		t.Fatalf("unexpected error in convertV2: %v", err)
+	}
+	assert.Equal(t, []string{
+		"'etcd' was converted to 'discovery.etcd', which uses the etcd v3 " +
+			"API; services registered by ContainerPilot v2 won't be seen " +
+			"by v3 and the other way around",
+	}, warnings)
+	assert.Contains(t, string(converted), `"discovery": {
+    "etcd": {
+      "endpoints": [
+        "http://etcd:4001"
+      ],
+      "prefix": "/containerpilot"
+    }
+  }`)
+
+	cfg, err := newConfig(converted, formatJSON5)
+	if err != nil {
+		t.Fatalf("converted config is not valid: %v\n%s", err, converted)
+	}
+	assert.IsType(t, &discovery.Etcd{}, cfg.Discovery)
+}
+
+func TestConvertV2Defaults(t *testing.T) {
+	data := []byte(`{
+	"consul": "consul:8500",
+	"services": [{"name": "app", "port": 80, "health": "/bin/check"}],
+	"backends": [{"name": "db", "onChange": "/bin/reload && kill -HUP 1"}],
+	"tasks": [{"name": "backup", "command": "/bin/backup > /tmp/log"}]
+}`)
+	converted, warnings, err := convertV2(data, []string{"/bin/app"})
+	if err != nil {
+		t.Fatalf("unexpected error in convertV2: %v", err)
+	}
+	assert.Equal(t, []string{
+		"service 'app' has no 'poll', so its health check interval was set to 10 seconds",
+		"service 'app' has no 'ttl', so its health check TTL was set to 20 seconds",
+		"backend 'db' has no 'poll', so its watch interval was set to 10 seconds",
+		"task 'backup' has no 'frequency', so it was converted to a job that runs once at startup",
+	}, warnings)
+	assert.Contains(t, string(converted), `"/bin/reload && kill -HUP 1"`)
+	assert.Contains(t, string(converted), `"/bin/backup > /tmp/log"`)
+
+	cfg, err := newConfig(converted, formatJSON5)
+	if err != nil {
+		t.Fatalf("converted config is not valid: %v\n%s", err, converted)
+	}
+	assert.Equal(t, 10, cfg.Jobs[0].Health.Heartbeat)
+	assert.Equal(t, 20, cfg.Jobs[0].Health.TTL)
+	assert.Equal(t, "backup", cfg.Jobs[2].Name)
+	assert.Equal(t, "", cfg.Jobs[2].When.Frequency)
+	assert.Equal(t, 10, cfg.Watches[0].Poll)
+}
+
+func TestConfigOverrides(t *testing.T) {
+	env := map[string]string{
+		"CONTAINERPILOT__LOGGING__LEVEL":           "DEBUG",
+		"CONTAINERPILOT__STOPTIMEOUT":              "9",
+		"CONTAINERPILOT__JOBS__serviceb__RESTARTS": "3",
+		"CONTAINERPILOT__JOBS__0__TAGS":            `["a", "b"]`,
+		"CONTAINERPILOT__WATCHES__upstreamA__TAG":  "canary",
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+	cfg, err := LoadConfig("./testdata/test.json5", "")
+	if err != nil {
+		t.Fatalf("unexpected error in LoadConfig: %v", err)
+	}
+	assert.Equal(t, "DEBUG", cfg.LogConfig.Level)
+	assert.Equal(t, 9, cfg.StopTimeout)
+	assert.Equal(t, "serviceB", cfg.Jobs[1].Name)
+	assert.Equal(t, float64(3), cfg.Jobs[1].Restarts)
+	assert.Equal(t, []string{"a", "b"}, cfg.Jobs[0].Tags)
+	assert.Equal(t, "canary", cfg.Watches[0].Tag)
+}
+
+func TestConfigOverridesErrors(t *testing.T) {
+	configMap := map[string]interface{}{
+		"consul": "consul:8500",
+		"jobs":   []interface{}{map[string]interface{}{"name": "a"}},
+	}
+	overrides := getOverrides([]string{
+		"CONTAINERPILOT__JOBS__b__EXEC=/bin/b",
+		"CONTAINERPILOT__CONSUL__TOKEN=x",
+		"CONTAINERPILOT__JOBS____EXEC=/bin/b",
+		"CONTAINERPILOT__CONTROL__SOCKET=/tmp/cp.sock",
+		"CONTAINERPILOT_PID=1",
+	})
+	var errs decode.Errors
+	applyOverrides(configMap, overrides, &errs)
+	assert.Equal(t, decode.Errors{
+		{Path: "CONTAINERPILOT__CONSUL__TOKEN", Message: "consul is not an object or list"},
+		{Path: "CONTAINERPILOT__JOBS____EXEC", Message: "keys must be separated by a single '__'"},
+		{Path: "CONTAINERPILOT__JOBS__b__EXEC", Message: "jobs: no element with name or index 'b'"},
+	}, errs)
+	assert.Equal(t, map[string]interface{}{"socket": "/tmp/cp.sock"}, configMap["control"])
+}
+
+func TestConfigOverridesStringValues(t *testing.T) {
+	configMap := map[string]interface{}{
+		"consul": "consul:8500",
+		"jobs": []interface{}{map[string]interface{}{
+			"name": "a", "meta": map[string]interface{}{"version": "0.9"}}},
+		"watches": []interface{}{map[string]interface{}{"name": "b"}},
+	}
+	overrides := getOverrides([]string{
+		"CONTAINERPILOT__JOBS__a__META__VERSION=1.0",
+		"CONTAINERPILOT__JOBS__a__META__BUILD=0123",
+		"CONTAINERPILOT__JOBS__a__INITIAL_STATUS=null",
+		"CONTAINERPILOT__JOBS__a__EXEC=1.5",
+		"CONTAINERPILOT__JOBS__a__PORT=8080",
+		"CONTAINERPILOT__JOBS__a__RESTARTS=3",
+		"CONTAINERPILOT__WATCHES__b__TAG=2",
+		"CONTAINERPILOT__WATCHES__b__INTERVAL=5",
+	})
+	var errs decode.Errors
+	applyOverrides(configMap, overrides, &errs)
+	assert.Nil(t, errs)
+	job := configMap["jobs"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"version": "1.0", "BUILD": "0123"}, job["meta"])
+	assert.Equal(t, "1.5", job["exec"])
+	assert.Equal(t, "null", job["initial_status"])
+	assert.Equal(t, float64(8080), job["port"])
+	assert.Equal(t, float64(3), job["restarts"])
+	watch := configMap["watches"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "2", watch["tag"])
+	assert.Equal(t, float64(5), watch["interval"])
+}
+
+func TestRenderConfigOverrides(t *testing.T) {
+	os.Setenv("CONTAINERPILOT__JOBS__serviceA__PORT", "9090")
+	defer os.Unsetenv("CONTAINERPILOT__JOBS__serviceA__PORT")
+	rendered, _ := renderConfigTemplate([]byte(`{consul: "consul:8500", jobs: [{name: "serviceA"}]}`))
+	comment := overridesComment(rendered, formatJSON5)
+	assert.Equal(t, "\n// overrides from the environment:\n//   jobs[0].port = 9090\n",
+		string(comment))
+	comment = overridesComment([]byte("consul: consul:8500\n"), formatYAML)
+	assert.Equal(t, "\n# overrides from the environment:\n"+
+		"#   CONTAINERPILOT__JOBS__serviceA__PORT (not applied)\n"+
+		"#   error: CONTAINERPILOT__JOBS__serviceA__PORT: jobs: no element with name or index 'serviceA'\n",
+		string(comment))
+}
+
 func TestInvalidRenderConfigFileMissing(t *testing.T) {
-	err := RenderConfig("/xxxx", "-")
+	err := RenderConfig("/xxxx", "", "-")
 	assert.Error(t, err,
 		"could not read config file: open /xxxx: no such file or directory")
 }
 
 func TestInvalidRenderConfigOutputMissing(t *testing.T) {
-	err := RenderConfig("./testdata/test.json5", "./xxxx/xxxx")
+	err := RenderConfig("./testdata/test.json5", "", "./xxxx/xxxx")
 	assert.Error(t, err,
 		"could not write config file: open ./xxxx/xxxx: no such file or directory")
 }
@@ -166,7 +585,7 @@ func TestRenderConfigFileStdout(t *testing.T) {
 
 	// Render to file
 	defer os.Remove("testJSON.json")
-	if err := RenderConfig("./testdata/test.json5", "testJSON.json"); err != nil {
+	if err := RenderConfig("./testdata/test.json5", "", "testJSON.json"); err != nil {
 		t.Fatalf("expected no error from renderConfigTemplate but got: %v", err)
 	}
 	if exists, err := fileExists("testJSON.json"); !exists || err != nil {
@@ -178,7 +597,7 @@ func TestRenderConfigFileStdout(t *testing.T) {
 	temp, _ := os.Create(fname)
 	old := os.Stdout
 	os.Stdout = temp
-	if err := RenderConfig("./testdata/test.json5", "-"); err != nil {
+	if err := RenderConfig("./testdata/test.json5", "", "-"); err != nil {
 		t.Fatalf("expected no error from renderConfigTemplate but got: %v", err)
 	}
 	temp.Close()
@@ -199,7 +618,7 @@ func TestRenderedConfigIsParseable(t *testing.T) {
 
 	os.Setenv("TESTRENDERCONFIGISPARSEABLE", "-ok")
 	template, _ := renderConfigTemplate([]byte(testJSON))
-	config, err := newConfig(template)
+	config, err := newConfig(template, formatJSON5)
 	if err != nil {
 		t.Fatalf("unexpected error in LoadConfig: %v", err)
 	}
@@ -0,0 +1,484 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// ContainerPilot v2 configuration layout. In v2 the main application was
+// passed as the trailing arguments on the command line and each of the
+// "services" advertised it to Consul.
+type v2Config struct {
+	Consul      interface{}   `mapstructure:"consul"`
+	Etcd        *v2Etcd       `mapstructure:"etcd"`
+	Logging     interface{}   `mapstructure:"logging"`
+	StopTimeout int           `mapstructure:"stopTimeout"`
+	PreStart    interface{}   `mapstructure:"preStart"`
+	PreStop     interface{}   `mapstructure:"preStop"`
+	PostStop    interface{}   `mapstructure:"postStop"`
+	Services    []v2Service   `mapstructure:"services"`
+	Backends    []v2Backend   `mapstructure:"backends"`
+	Coprocesses []v2Coprocess `mapstructure:"coprocesses"`
+	Tasks       []v2Task      `mapstructure:"tasks"`
+	Telemetry   *v2Telemetry  `mapstructure:"telemetry"`
+	Control     interface{}   `mapstructure:"control"`
+}
+
+type v2Etcd struct {
+	Endpoints interface{} `mapstructure:"endpoints"`
+	Prefix    string      `mapstructure:"prefix"`
+}
+
+type v2Service struct {
+	Name          string      `mapstructure:"name"`
+	Port          int         `mapstructure:"port"`
+	Health        interface{} `mapstructure:"health"`
+	Poll          int         `mapstructure:"poll"`
+	TTL           int         `mapstructure:"ttl"`
+	Interfaces    interface{} `mapstructure:"interfaces"`
+	Tags          []string    `mapstructure:"tags"`
+	Timeout       string      `mapstructure:"timeout"`
+	InitialStatus string      `mapstructure:"initial_status"`
+	Consul        interface{} `mapstructure:"consul"`
+}
+
+type v2Backend struct {
+	Name     string      `mapstructure:"name"`
+	Poll     int         `mapstructure:"poll"`
+	OnChange interface{} `mapstructure:"onChange"`
+	Tag      string      `mapstructure:"tag"`
+	Timeout  string      `mapstructure:"timeout"`
+}
+
+type v2Coprocess struct {
+	Name     string      `mapstructure:"name"`
+	Command  interface{} `mapstructure:"command"`
+	Restarts interface{} `mapstructure:"restarts"`
+}
+
+type v2Task struct {
+	Name      string      `mapstructure:"name"`
+	Command   interface{} `mapstructure:"command"`
+	Frequency string      `mapstructure:"frequency"`
+	Timeout   string      `mapstructure:"timeout"`
+}
+
+type v2Telemetry struct {
+	Port       int         `mapstructure:"port"`
+	Interfaces interface{} `mapstructure:"interfaces"`
+	Tags       []string    `mapstructure:"tags"`
+	Sensors    []v2Sensor  `mapstructure:"sensors"`
+}
+
+type v2Sensor struct {
+	Namespace string      `mapstructure:"namespace"`
+	Subsystem string      `mapstructure:"subsystem"`
+	Name      string      `mapstructure:"name"`
+	Help      string      `mapstructure:"help"`
+	Type      string      `mapstructure:"type"`
+	Poll      int         `mapstructure:"poll"`
+	Check     interface{} `mapstructure:"check"`
+	Timeout   string      `mapstructure:"timeout"`
+}
+
+// The v3 configuration we write out. We use our own structs rather than
+// the jobs/watches Configs so that the fields are written in a sensible
+// order and empty fields are left out.
+type v3Config struct {
+	Consul      interface{}  `json:"consul,omitempty"`
+	Discovery   *v3Discovery `json:"discovery,omitempty"`
+	Logging     interface{}  `json:"logging,omitempty"`
+	StopTimeout int          `json:"stopTimeout,omitempty"`
+	Jobs        []*v3Job     `json:"jobs,omitempty"`
+	Watches     []*v3Watch   `json:"watches,omitempty"`
+	Telemetry   *v3Telemetry `json:"telemetry,omitempty"`
+	Control     interface{}  `json:"control,omitempty"`
+}
+
+type v3Discovery struct {
+	Etcd *v3Etcd `json:"etcd"`
+}
+
+type v3Etcd struct {
+	Endpoints interface{} `json:"endpoints,omitempty"`
+	Prefix    string      `json:"prefix,omitempty"`
+}
+
+type v3Job struct {
+	Name          string      `json:"name"`
+	Exec          interface{} `json:"exec,omitempty"`
+	Port          int         `json:"port,omitempty"`
+	InitialStatus string      `json:"initial_status,omitempty"`
+	Interfaces    interface{} `json:"interfaces,omitempty"`
+	Tags          []string    `json:"tags,omitempty"`
+	Consul        interface{} `json:"consul,omitempty"`
+	Health        *v3Health   `json:"health,omitempty"`
+	Timeout       string      `json:"timeout,omitempty"`
+	Restarts      interface{} `json:"restarts,omitempty"`
+	When          *v3When     `json:"when,omitempty"`
+}
+
+type v3Health struct {
+	Exec     interface{} `json:"exec,omitempty"`
+	Interval int         `json:"interval,omitempty"`
+	TTL      int         `json:"ttl,omitempty"`
+	Timeout  string      `json:"timeout,omitempty"`
+}
+
+type v3When struct {
+	Interval string `json:"interval,omitempty"`
+	Source   string `json:"source,omitempty"`
+	Once     string `json:"once,omitempty"`
+	Each     string `json:"each,omitempty"`
+}
+
+type v3Watch struct {
+	Name     string `json:"name"`
+	Interval int    `json:"interval"`
+	Tag      string `json:"tag,omitempty"`
+}
+
+type v3Telemetry struct {
+	Port       int         `json:"port,omitempty"`
+	Interfaces interface{} `json:"interfaces,omitempty"`
+	Tags       []string    `json:"tags,omitempty"`
+	Metrics    []*v3Metric `json:"metrics,omitempty"`
+}
+
+type v3Metric struct {
+	Namespace string `json:"namespace,omitempty"`
+	Subsystem string `json:"subsystem,omitempty"`
+	Name      string `json:"name"`
+	Help      string `json:"help"`
+	Type      string `json:"type"`
+}
+
+// the polling interval in seconds we use for v2 services and backends that
+// are missing their required poll, so that the converted jobs and watches
+// are still valid
+const defaultV2Poll = 10
+
+// ConvertConfig reads the ContainerPilot v2 configuration in configFlag
+// and writes an equivalent v3 configuration to renderFlag (or stdout).
+// The v2 application command that was passed as trailing arguments should
+// be passed as command so that it can be turned into a job. Returns
+// warnings for anything that couldn't be translated.
+func ConvertConfig(configFlag string, command []string, renderFlag string) ([]string, error) {
+	configData, err := loadConfigFile(configFlag)
+	if err != nil {
+		return nil, err
+	}
+	converted, warnings, err := convertV2(configData, command)
+	if err != nil {
+		return warnings, err
+	}
+	if renderFlag == "-" || renderFlag == "" {
+		fmt.Printf("%s\n", converted)
+	} else if err := ioutil.WriteFile(renderFlag, converted, 0644); err != nil {
+		return warnings, fmt.Errorf("could not write config file: %s", err)
+	}
+	return warnings, nil
+}
+
+func convertV2(configData []byte, command []string) ([]byte, []string, error) {
+	var warnings []string
+	warn := func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+
+	// we want to keep any template directives in the output, but if the
+	// template makes the file unparseable we have to render it first
+	configMap, err := unmarshalConfig(configData, formatJSON5)
+	if err != nil {
+		rendered, renderErr := renderConfigTemplate(configData)
+		if renderErr != nil {
+			return nil, warnings, err
+		}
+		configMap, err = unmarshalConfig(rendered, formatJSON5)
+		if err != nil {
+			return nil, warnings, err
+		}
+		warn("the configuration template had to be rendered before it " +
+			"could be parsed, so environment variables were replaced " +
+			"with their current values")
+	}
+
+	v2 := &v2Config{}
+	var md mapstructure.Metadata
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		WeaklyTypedInput: true,
+		Metadata:         &md,
+		Result:           v2,
+	})
+	if err != nil {
+		return nil, warnings, err
+	}
+	if err := decoder.Decode(configMap); err != nil {
+		return nil, warnings, fmt.Errorf("could not parse v2 configuration: %v", err)
+	}
+	sort.Strings(md.Unused)
+	for _, key := range md.Unused {
+		warn("'%s' is not a v2 configuration field and was dropped", key)
+	}
+
+	v3 := &v3Config{
+		Consul:      v2.Consul,
+		Logging:     v2.Logging,
+		StopTimeout: v2.StopTimeout,
+		Control:     v2.Control,
+	}
+
+	// v3 selects etcd in the 'discovery' field, which can't be used along
+	// with 'consul'
+	if v2.Etcd != nil {
+		if v2.Consul != nil {
+			warn("'etcd' was dropped because 'consul' is also set; only " +
+				"one service discovery backend can be used")
+		} else {
+			endpoints := v2.Etcd.Endpoints
+			if endpoint, ok := endpoints.(string); ok {
+				endpoints = []string{endpoint}
+			}
+			v3.Discovery = &v3Discovery{Etcd: &v3Etcd{
+				Endpoints: endpoints,
+				Prefix:    v2.Etcd.Prefix,
+			}}
+			warn("'etcd' was converted to 'discovery.etcd', which uses " +
+				"the etcd v3 API; services registered by ContainerPilot v2 " +
+				"won't be seen by v3 and the other way around")
+		}
+	}
+
+	if v2.PreStart != nil {
+		v3.Jobs = append(v3.Jobs, &v3Job{Name: "preStart", Exec: v2.PreStart})
+	}
+
+	// the v2 application is advertised by the first service, or gets its
+	// own job if there are no services
+	var app *v3Job
+	for i, svc := range v2.Services {
+		if svc.Poll < 1 {
+			warn("service '%s' has no 'poll', so its health check interval "+
+				"was set to %d seconds", svc.Name, defaultV2Poll)
+			svc.Poll = defaultV2Poll
+		}
+		if svc.TTL < 1 {
+			warn("service '%s' has no 'ttl', so its health check TTL was "+
+				"set to %d seconds", svc.Name, svc.Poll*2)
+			svc.TTL = svc.Poll * 2
+		}
+		job := &v3Job{
+			Name:          svc.Name,
+			Port:          svc.Port,
+			InitialStatus: svc.InitialStatus,
+			Interfaces:    svc.Interfaces,
+			Tags:          svc.Tags,
+			Consul:        svc.Consul,
+			Health: &v3Health{
+				Exec:     svc.Health,
+				Interval: svc.Poll,
+				TTL:      svc.TTL,
+				Timeout:  svc.Timeout,
+			},
+		}
+		if i == 0 {
+			app = job
+		} else if len(command) > 0 {
+			warn("service '%s' was converted to a job that only registers "+
+				"with Consul; in v3 each job can run its own process", svc.Name)
+		}
+		v3.Jobs = append(v3.Jobs, job)
+	}
+	if len(command) > 0 {
+		if app == nil {
+			app = &v3Job{Name: filepath.Base(command[0])}
+			v3.Jobs = append(v3.Jobs, app)
+		}
+		app.Exec = command
+	} else {
+		warn("no application command was given after the flags, so no job " +
+			"runs the main application; add an 'exec' to the job for it")
+	}
+	if app != nil && v2.PreStart != nil {
+		app.When = &v3When{Source: "preStart", Once: "exitSuccess"}
+	}
+
+	if v2.PreStop != nil || v2.PostStop != nil {
+		if app == nil {
+			warn("'preStop' and 'postStop' were dropped because there is " +
+				"no application job for them to depend on")
+		} else {
+			if v2.PreStop != nil {
+				v3.Jobs = append(v3.Jobs, &v3Job{
+					Name: "preStop",
+					Exec: v2.PreStop,
+					When: &v3When{Source: app.Name, Once: "stopping"},
+				})
+			}
+			if v2.PostStop != nil {
+				v3.Jobs = append(v3.Jobs, &v3Job{
+					Name: "postStop",
+					Exec: v2.PostStop,
+					When: &v3When{Source: app.Name, Once: "stopped"},
+				})
+			}
+		}
+	}
+
+	for _, backend := range v2.Backends {
+		if backend.Poll < 1 {
+			warn("backend '%s' has no 'poll', so its watch interval was set "+
+				"to %d seconds", backend.Name, defaultV2Poll)
+			backend.Poll = defaultV2Poll
+		}
+		v3.Watches = append(v3.Watches, &v3Watch{
+			Name:     backend.Name,
+			Interval: backend.Poll,
+			Tag:      backend.Tag,
+		})
+		if backend.OnChange != nil {
+			v3.Jobs = append(v3.Jobs, &v3Job{
+				Name:    "onChange-" + backend.Name,
+				Exec:    backend.OnChange,
+				Timeout: backend.Timeout,
+				When:    &v3When{Source: "watch." + backend.Name, Each: "changed"},
+			})
+		}
+	}
+
+	for _, coprocess := range v2.Coprocesses {
+		v3.Jobs = append(v3.Jobs, &v3Job{
+			Name:     nameOrExecutable(coprocess.Name, coprocess.Command),
+			Exec:     coprocess.Command,
+			Restarts: coprocess.Restarts,
+		})
+	}
+
+	for _, task := range v2.Tasks {
+		job := &v3Job{
+			Name:    nameOrExecutable(task.Name, task.Command),
+			Exec:    task.Command,
+			Timeout: task.Timeout,
+		}
+		if task.Frequency != "" {
+			job.When = &v3When{Interval: task.Frequency}
+		} else {
+			warn("task '%s' has no 'frequency', so it was converted to a "+
+				"job that runs once at startup", job.Name)
+		}
+		v3.Jobs = append(v3.Jobs, job)
+	}
+
+	if v2.Telemetry != nil {
+		v3.Telemetry = &v3Telemetry{
+			Port:       v2.Telemetry.Port,
+			Interfaces: v2.Telemetry.Interfaces,
+			Tags:       v2.Telemetry.Tags,
+		}
+		for _, sensor := range v2.Telemetry.Sensors {
+			v3.Telemetry.Metrics = append(v3.Telemetry.Metrics, &v3Metric{
+				Namespace: sensor.Namespace,
+				Subsystem: sensor.Subsystem,
+				Name:      sensor.Name,
+				Help:      sensor.Help,
+				Type:      sensor.Type,
+			})
+			if sensor.Check == nil {
+				continue
+			}
+			check, ok := commandLine(sensor.Check)
+			if !ok {
+				warn("sensor '%s' check could not be converted", sensor.Name)
+				continue
+			}
+			metric := metricName(sensor.Namespace, sensor.Subsystem, sensor.Name)
+			job := &v3Job{
+				Name: "sensor-" + sensor.Name,
+				Exec: []string{"/bin/sh", "-c",
+					fmt.Sprintf("containerpilot -putmetric %s=$(%s)", metric, check)},
+				Timeout: sensor.Timeout,
+			}
+			if sensor.Poll > 0 {
+				job.When = &v3When{Interval: fmt.Sprintf("%ds", sensor.Poll)}
+			}
+			v3.Jobs = append(v3.Jobs, job)
+			warn("sensor '%s' was converted to a job that passes the output "+
+				"of its check to 'containerpilot -putmetric'; consider "+
+				"updating the check to call -putmetric itself", sensor.Name)
+		}
+	}
+
+	// exec strings are shell commands, so we don't want &, <, and >
+	// escaped the way json.Marshal does for HTML
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v3); err != nil {
+		return nil, warnings, err
+	}
+	return bytes.TrimSuffix(out.Bytes(), []byte("\n")), warnings, nil
+}
+
+// v2 coprocesses and tasks could leave out their name and use the
+// executable name instead
+func nameOrExecutable(name string, command interface{}) string {
+	if name != "" {
+		return name
+	}
+	switch t := command.(type) {
+	case string:
+		if fields := strings.Fields(t); len(fields) > 0 {
+			return filepath.Base(fields[0])
+		}
+	case []interface{}:
+		if len(t) > 0 {
+			return filepath.Base(fmt.Sprintf("%v", t[0]))
+		}
+	}
+	return ""
+}
+
+// commandLine joins a command given as either a string or an array of
+// arguments into a single shell command line
+func commandLine(command interface{}) (string, bool) {
+	switch t := command.(type) {
+	case string:
+		if strings.TrimSpace(t) == "" {
+			return "", false
+		}
+		return t, true
+	case []interface{}:
+		if len(t) == 0 {
+			return "", false
+		}
+		args := make([]string, len(t))
+		for i, arg := range t {
+			args[i] = shellQuote(fmt.Sprintf("%v", arg))
+		}
+		return strings.Join(args, " "), true
+	}
+	return "", false
+}
+
+func shellQuote(arg string) string {
+	return "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+}
+
+// matches the metric name built by telemetry.MetricConfig
+func metricName(namespace, subsystem, name string) string {
+	parts := []string{}
+	for _, part := range []string{namespace, subsystem, name} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "_")
+}
@@ -0,0 +1,152 @@
+package decode
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// Error is a single configuration error along with the JSON path of the
+// configuration field where it was found (ex. "jobs[0].health.interval")
+type Error struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// Errors collects all the errors found while decoding and validating a
+// configuration so that they can be reported together rather than one at
+// a time.
+type Errors []*Error
+
+// Error implements the error interface
+func (errs Errors) Error() string {
+	if len(errs) == 1 {
+		return errs[0].Error()
+	}
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = "  " + err.Error()
+	}
+	return fmt.Sprintf("%d configuration errors:\n%s",
+		len(errs), strings.Join(msgs, "\n"))
+}
+
+// ErrorOrNil returns nil if no errors have been collected, so that callers
+// don't return a non-nil error interface wrapping an empty Errors.
+func (errs Errors) ErrorOrNil() error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// Add appends err to the collection, relative to the given path. Errors
+// returned by mapstructure are split apart so that each unknown key or
+// invalid value gets its own full path, and nested Errors are flattened.
+func (errs *Errors) Add(path string, err error) {
+	if err == nil {
+		return
+	}
+	switch e := err.(type) {
+	case Errors:
+		for _, child := range e {
+			*errs = append(*errs, &Error{
+				Path:    JoinPath(path, child.Path),
+				Message: child.Message,
+			})
+		}
+	case *Error:
+		*errs = append(*errs, &Error{
+			Path:    JoinPath(path, e.Path),
+			Message: e.Message,
+		})
+	case *mapstructure.Error:
+		for _, msg := range e.Errors {
+			errs.addDecodeError(path, msg)
+		}
+	default:
+		errs.addDecodeError(path, err.Error())
+	}
+}
+
+var (
+	invalidKeysRe = regexp.MustCompile(`^'([^']*)' has invalid keys: (.*)$`)
+	fieldErrRe    = regexp.MustCompile(`^'([^']*)':? (.*)$`)
+	parseErrRe    = regexp.MustCompile(`^cannot parse '([^']*)'[ ,]*(.*)$`)
+)
+
+// addDecodeError translates a mapstructure error string, which includes
+// the name of the field relative to the struct being decoded, into an
+// Error with the full JSON path of that field.
+func (errs *Errors) addDecodeError(path, msg string) {
+	if match := invalidKeysRe.FindStringSubmatch(msg); match != nil {
+		for _, key := range strings.Split(match[2], ", ") {
+			*errs = append(*errs, &Error{
+				Path:    JoinPath(path, JoinPath(match[1], key)),
+				Message: "unknown configuration key",
+			})
+		}
+		return
+	}
+	if match := parseErrRe.FindStringSubmatch(msg); match != nil {
+		*errs = append(*errs, &Error{
+			Path:    JoinPath(path, match[1]),
+			Message: "cannot parse " + match[2],
+		})
+		return
+	}
+	if match := fieldErrRe.FindStringSubmatch(msg); match != nil {
+		*errs = append(*errs, &Error{
+			Path:    JoinPath(path, match[1]),
+			Message: match[2],
+		})
+		return
+	}
+	*errs = append(*errs, &Error{Path: path, Message: msg})
+}
+
+// JoinPath joins two segments of a JSON path, ex. "jobs" and "[0].health"
+// become "jobs[0].health"
+func JoinPath(parent, child string) string {
+	switch {
+	case child == "":
+		return parent
+	case parent == "":
+		return strings.TrimPrefix(child, ".")
+	case strings.HasPrefix(child, "["):
+		return parent + child
+	default:
+		return parent + "." + strings.TrimPrefix(child, ".")
+	}
+}
+
+// HighlightLine returns the given line of source and the line before it,
+// with line numbers, and marks the column of the error on that line. If
+// we don't know the column, the first non-blank character of the line is
+// marked.
+func HighlightLine(source []byte, line, col int) string {
+	lines := strings.Split(string(source), "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	prevLine := ""
+	if line > 1 {
+		prevLine = fmt.Sprintf("%5d: %s\n", line-1, lines[line-2])
+	}
+	text := lines[line-1]
+	if col < 1 || col > len(text)+1 {
+		col = len(text) - len(strings.TrimLeft(text, " \t")) + 1
+	}
+	return fmt.Sprintf("%s%5d: %s\n%s^", prevLine, line, text,
+		strings.Repeat("-", 7+col-1))
+}
@@ -0,0 +1,107 @@
+package decode
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type testNested struct {
+	Interval int `mapstructure:"interval"`
+}
+
+type testStruct struct {
+	Name   string      `mapstructure:"name"`
+	Health *testNested `mapstructure:"health"`
+}
+
+func TestErrorsFromDecode(t *testing.T) {
+	raw := []interface{}{
+		map[string]interface{}{
+			"name":   "a",
+			"helath": map[string]interface{}{},
+			"health": map[string]interface{}{"intervall": 1, "interval": "x"},
+		},
+	}
+	var result []testStruct
+	var errs Errors
+	errs.Add("jobs", ToStruct(raw, &result))
+
+	expected := Errors{
+		{Path: "jobs[0].health.interval", Message: "cannot parse as int: strconv.ParseInt: parsing \"x\": invalid syntax"},
+		{Path: "jobs[0].health.intervall", Message: "unknown configuration key"},
+		{Path: "jobs[0].helath", Message: "unknown configuration key"},
+	}
+	got := map[string]string{}
+	for _, err := range errs {
+		got[err.Path] = err.Message
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors but got %d: %v", len(expected), len(errs), errs)
+	}
+	for _, err := range expected {
+		if got[err.Path] != err.Message {
+			t.Errorf("expected %q at %s but got %q", err.Message, err.Path, got[err.Path])
+		}
+	}
+}
+
+func TestErrorsFlatten(t *testing.T) {
+	var inner Errors
+	inner.Add("[0]", errors.New("first"))
+	inner.Add("[1].name", errors.New("second"))
+
+	var errs Errors
+	errs.Add("jobs", inner)
+	errs.Add("", errors.New("third"))
+	errs.Add("consul", nil)
+
+	expected := Errors{
+		{Path: "jobs[0]", Message: "first"},
+		{Path: "jobs[1].name", Message: "second"},
+		{Path: "", Message: "third"},
+	}
+	if !reflect.DeepEqual(errs, expected) {
+		t.Fatalf("expected %v but got %v", expected, errs)
+	}
+	if errs.Error() != "3 configuration errors:\n  jobs[0]: first\n  jobs[1].name: second\n  third" {
+		t.Fatalf("unexpected error string: %q", errs.Error())
+	}
+	if (Errors{}).ErrorOrNil() != nil {
+		t.Fatalf("expected nil error for empty Errors")
+	}
+}
+
+func TestJoinPath(t *testing.T) {
+	cases := [][3]string{
+		{"jobs", "[0].health", "jobs[0].health"},
+		{"jobs[0]", "health", "jobs[0].health"},
+		{"jobs[0]", "", "jobs[0]"},
+		{"", "health", "health"},
+		{"", ".health", "health"},
+	}
+	for _, c := range cases {
+		if got := JoinPath(c[0], c[1]); got != c[2] {
+			t.Errorf("JoinPath(%q, %q): expected %q but got %q", c[0], c[1], c[2], got)
+		}
+	}
+}
+
+func TestHighlightLine(t *testing.T) {
+	source := []byte("a = 1\n  b c = 2\n")
+	cases := []struct {
+		line, col int
+		expected  string
+	}{
+		{2, 5, "    1: a = 1\n    2:   b c = 2\n-----------^"},
+		{2, 0, "    1: a = 1\n    2:   b c = 2\n---------^"}, // first character
+		{1, 1, "    1: a = 1\n-------^"},
+		{4, 1, ""},
+	}
+	for _, c := range cases {
+		if got := HighlightLine(source, c.line, c.col); got != c.expected {
+			t.Errorf("HighlightLine(%d, %d): expected %q but got %q",
+				c.line, c.col, c.expected, got)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+// Package files provides functions for writing the files that
+// ContainerPilot generates for other processes to read
+package files
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// WriteAtomic replaces the file at path with data, so that readers never
+// see a partially-written file, by writing to a temporary file in the
+// same directory and then renaming it. The file is given the mode and
+// the owner uid and gid; pass -1 to leave either unchanged.
+func WriteAtomic(path string, data []byte, mode os.FileMode, uid, gid int) error {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := ioutil.TempFile(dir, "."+base+".")
+	if err != nil {
+		return err
+	}
+	tmpName := f.Name()
+	defer os.Remove(tmpName) // no-op once it's been renamed
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpName, mode); err != nil {
+		return err
+	}
+	if uid != -1 || gid != -1 {
+		if err := os.Chown(tmpName, uid, gid); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmpName, path)
+}
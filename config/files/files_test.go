@@ -0,0 +1,29 @@
+package files
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteAtomic(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "files-test")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.conf")
+
+	assert.Nil(t, WriteAtomic(path, []byte("first"), 0600, -1, -1))
+	assert.Nil(t, WriteAtomic(path, []byte("second"), 0640, -1, -1))
+	data, _ := ioutil.ReadFile(path)
+	assert.Equal(t, "second", string(data))
+	info, _ := os.Stat(path)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+	files, _ := ioutil.ReadDir(dir)
+	assert.Equal(t, 1, len(files), "expected temporary files to be cleaned up")
+
+	err := WriteAtomic(filepath.Join(dir, "missing", "test.conf"), []byte{}, 0600, -1, -1)
+	assert.NotNil(t, err, "expected error writing to missing directory")
+}
@@ -0,0 +1,224 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/flynn/json5"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/joyent/containerpilot/config/decode"
+)
+
+// supported configuration file formats
+const (
+	formatJSON5 = "json5"
+	formatYAML  = "yaml"
+	formatTOML  = "toml"
+)
+
+// detectFormat returns the configuration format to use for the file at
+// configPath. An explicit formatFlag always wins; otherwise we look at the
+// file extension and fall back to JSON5 for backwards compatibility.
+func detectFormat(configPath, formatFlag string) (string, error) {
+	if formatFlag != "" {
+		switch strings.ToLower(formatFlag) {
+		case "json5", "json":
+			return formatJSON5, nil
+		case "yaml", "yml":
+			return formatYAML, nil
+		case "toml":
+			return formatTOML, nil
+		default:
+			return "", fmt.Errorf(
+				"unsupported config format '%s': must be one of json5, yaml, toml",
+				formatFlag)
+		}
+	}
+	switch strings.ToLower(filepath.Ext(configPath)) {
+	case ".yaml", ".yml":
+		return formatYAML, nil
+	case ".toml":
+		return formatTOML, nil
+	default:
+		return formatJSON5, nil
+	}
+}
+
+// unmarshalConfig parses the rendered configuration data in the given
+// format into the same generic map that the JSON5 parser would produce,
+// so that the rest of the configuration pipeline doesn't need to care
+// which format the operator wrote the file in.
+func unmarshalConfig(data []byte, format string) (map[string]interface{}, error) {
+	switch format {
+	case formatYAML:
+		return unmarshalYAML(data)
+	case formatTOML:
+		return unmarshalTOML(data)
+	default:
+		return unmarshalJSON5(data)
+	}
+}
+
+func unmarshalJSON5(data []byte) (map[string]interface{}, error) {
+	var config map[string]interface{}
+	if err := json5.Unmarshal(data, &config); err != nil {
+		syntax, ok := err.(*json5.SyntaxError)
+		if !ok {
+			return nil, fmt.Errorf(
+				"could not parse configuration: %s",
+				err)
+		}
+		return nil, newJSONparseError(data, syntax)
+	}
+	return config, nil
+}
+
+// the YAML and TOML parsers only report line numbers in their error
+// strings, but the TOML parser quotes the token it didn't expect
+var (
+	yamlErrLine  = regexp.MustCompile(`line (\d+)`)
+	tomlErrLine  = regexp.MustCompile(`(?i)near line (\d+)`)
+	tomlErrToken = regexp.MustCompile(`(?:found|got) ['"](.+?)['"] instead`)
+)
+
+func unmarshalYAML(data []byte) (map[string]interface{}, error) {
+	var config map[string]interface{}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, newLineParseError(data, err, yamlErrLine, nil)
+	}
+	normalized, err := normalizeValue(config)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse configuration: %v", err)
+	}
+	if normalized == nil {
+		return nil, nil
+	}
+	return normalized.(map[string]interface{}), nil
+}
+
+func unmarshalTOML(data []byte) (map[string]interface{}, error) {
+	var config map[string]interface{}
+	if _, err := toml.Decode(string(data), &config); err != nil {
+		return nil, newLineParseError(data, err, tomlErrLine, tomlErrToken)
+	}
+	normalized, err := normalizeValue(config)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse configuration: %v", err)
+	}
+	if normalized == nil {
+		return nil, nil
+	}
+	return normalized.(map[string]interface{}), nil
+}
+
+// normalizeValue converts the values produced by the YAML and TOML
+// decoders into the types produced by the JSON5 decoder: maps are keyed by
+// strings, lists are []interface{}, and all numbers are float64.
+func normalizeValue(in interface{}) (interface{}, error) {
+	switch v := in.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			nv, err := normalizeValue(val)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = nv
+		}
+		return out, nil
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			k, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("map key %v must be a string", key)
+			}
+			nv, err := normalizeValue(val)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = nv
+		}
+		return out, nil
+	case []map[string]interface{}:
+		out := make([]interface{}, len(v))
+		for i, val := range v {
+			nv, err := normalizeValue(val)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = nv
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, val := range v {
+			nv, err := normalizeValue(val)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = nv
+		}
+		return out, nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case float32:
+		return float64(v), nil
+	case time.Time:
+		return v.Format(time.RFC3339), nil
+	default:
+		return v, nil
+	}
+}
+
+// newLineParseError wraps a parser error that tells us the line number of
+// the problem, highlighting the offending line in the original data. If
+// tokenRe matches the token the parser choked on, we find it on the line
+// to report the column as well.
+func newLineParseError(data []byte, err error, lineRe, tokenRe *regexp.Regexp) error {
+	match := lineRe.FindStringSubmatch(err.Error())
+	if match == nil {
+		return fmt.Errorf("could not parse configuration: %s", err)
+	}
+	line, convErr := strconv.Atoi(match[1])
+	if convErr != nil {
+		return fmt.Errorf("could not parse configuration: %s", err)
+	}
+	col := 0
+	if tokenRe != nil {
+		if token := tokenRe.FindStringSubmatch(err.Error()); token != nil {
+			col = tokenColumn(data, line, token[1])
+		}
+	}
+	if col > 0 {
+		return fmt.Errorf("parse error at line:col [%d:%d]: %s\n%s",
+			line, col, err, decode.HighlightLine(data, line, col))
+	}
+	return fmt.Errorf("parse error at line %d: %s\n%s",
+		line, err, decode.HighlightLine(data, line, 0))
+}
+
+// tokenColumn returns the column of the last occurrence of token on the
+// given line of data, or 0 if it isn't there. The parsers report a newline
+// as the escaped "\n", which is found at the end of the line.
+func tokenColumn(data []byte, line int, token string) int {
+	lines := strings.Split(string(data), "\n")
+	if line < 1 || line > len(lines) {
+		return 0
+	}
+	text := lines[line-1]
+	if token == `\n` {
+		return len(text) + 1
+	}
+	return strings.LastIndex(text, token) + 1
+}
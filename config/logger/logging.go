@@ -18,9 +18,9 @@ import (
 
 // Config configures the log levels
 type Config struct {
-	Level  string `json:"level"`
-	Format string `json:"format"`
-	Output string `json:"output"`
+	Level  string `json:"level" schema:"enum=DEBUG|INFO|WARN|WARNING|ERROR|FATAL|PANIC|debug|info|warn|warning|error|fatal|panic"`
+	Format string `json:"format" schema:"enum=default|text|json"`
+	Output string `json:"output"` // "stdout", "stderr", or a file path
 }
 
 var defaultLog = &Config{
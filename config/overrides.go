@@ -0,0 +1,286 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/flynn/json5"
+
+	"github.com/joyent/containerpilot/config/decode"
+	"github.com/joyent/containerpilot/config/schema"
+)
+
+// overridePrefix is the prefix for environment variables that override
+// a single configuration field. The rest of the name is the path to the
+// field, with each key separated by a double underscore. Keys match
+// case-insensitively, and elements of the jobs and watches lists are
+// addressed by their name or index. Ex:
+//
+//   CONTAINERPILOT__LOGGING__LEVEL=DEBUG
+//   CONTAINERPILOT__JOBS__app__RESTARTS=3
+const overridePrefix = "CONTAINERPILOT__"
+
+const overrideSeparator = "__"
+
+// override is a configuration field override from the environment
+type override struct {
+	env   string
+	keys  []string
+	value string
+	path  string // JSON path of the field, set once it's been applied
+}
+
+// getOverrides returns the overrides in the environment, sorted by
+// variable name so they're applied in a predictable order
+func getOverrides(environ []string) []*override {
+	overrides := []*override{}
+	for _, kv := range environ {
+		pair := strings.SplitN(kv, "=", 2)
+		if len(pair) != 2 || !strings.HasPrefix(pair[0], overridePrefix) {
+			continue
+		}
+		keys := strings.Split(strings.TrimPrefix(pair[0], overridePrefix), overrideSeparator)
+		overrides = append(overrides, &override{
+			env:   pair[0],
+			keys:  keys,
+			value: pair[1],
+		})
+	}
+	sort.Slice(overrides, func(i, j int) bool {
+		return overrides[i].env < overrides[j].env
+	})
+	return overrides
+}
+
+// applyOverrides sets each override's value in the decoded configuration
+// map, before the configuration is validated.
+func applyOverrides(configMap map[string]interface{}, overrides []*override, errs *decode.Errors) {
+	root := Schema()
+	for _, o := range overrides {
+		if err := o.apply(configMap, root); err != nil {
+			errs.Add(o.env, err)
+		}
+	}
+}
+
+func (o *override) apply(configMap map[string]interface{}, root schema.Schema) error {
+	for _, key := range o.keys {
+		if key == "" {
+			return fmt.Errorf("keys must be separated by a single '%s'", overrideSeparator)
+		}
+	}
+	var node interface{} = configMap
+	s := root
+	path := ""
+	for i, key := range o.keys {
+		last := i == len(o.keys)-1
+		switch t := node.(type) {
+		case map[string]interface{}:
+			name, child := mapKey(t, key, s)
+			path = decode.JoinPath(path, name)
+			if last {
+				t[name] = parseOverrideValue(o.value, child)
+				o.path = path
+				return nil
+			}
+			next, ok := t[name]
+			if !ok || next == nil {
+				if child != nil && child["type"] == "array" {
+					// we can't add elements to a list, so this
+					// will fail to find the element in the list
+					next = []interface{}{}
+				} else {
+					next = map[string]interface{}{}
+					t[name] = next
+				}
+			}
+			node, s = next, child
+		case []interface{}:
+			idx, err := listIndex(t, key)
+			if err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+			path = fmt.Sprintf("%s[%d]", path, idx)
+			if last {
+				t[idx] = parseOverrideValue(o.value, schemaItems(s))
+				o.path = path
+				return nil
+			}
+			node, s = t[idx], schemaItems(s)
+		default:
+			return fmt.Errorf("%s is not an object or list", path)
+		}
+	}
+	return nil
+}
+
+// mapKey returns the key in the map that matches name case-insensitively.
+// If it's not in the map we use the key's name from the schema so that
+// the override decodes the same way as if it had been in the file. Maps
+// like "meta" take any key, with the schema of their values.
+func mapKey(m map[string]interface{}, name string, s schema.Schema) (string, schema.Schema) {
+	props := schemaProperties(s)
+	for key := range m {
+		if strings.EqualFold(key, name) {
+			child, ok := props[key].(schema.Schema)
+			if !ok && s != nil {
+				child, _ = s["additionalProperties"].(schema.Schema)
+			}
+			return key, child
+		}
+	}
+	for key, child := range props {
+		if strings.EqualFold(key, name) {
+			childSchema, _ := child.(schema.Schema)
+			return key, childSchema
+		}
+	}
+	if s != nil {
+		child, _ := s["additionalProperties"].(schema.Schema)
+		return name, child
+	}
+	return name, nil
+}
+
+// listIndex finds the element of a list with the given name, or at the
+// given index if none of the elements have that name
+func listIndex(list []interface{}, name string) (int, error) {
+	for _, match := range []func(a, b string) bool{
+		func(a, b string) bool { return a == b },
+		strings.EqualFold,
+	} {
+		for i, elem := range list {
+			if m, ok := elem.(map[string]interface{}); ok {
+				if n, ok := m["name"].(string); ok && match(n, name) {
+					return i, nil
+				}
+			}
+		}
+	}
+	if idx, err := strconv.Atoi(name); err == nil && idx >= 0 && idx < len(list) {
+		return idx, nil
+	}
+	return 0, fmt.Errorf("no element with name or index '%s'", name)
+}
+
+// schemaProperties returns the properties of an object schema, looking
+// through oneOf for schemas like "consul" that accept a string or object
+func schemaProperties(s schema.Schema) schema.Schema {
+	if s == nil {
+		return nil
+	}
+	if props, ok := s["properties"].(schema.Schema); ok {
+		return props
+	}
+	if oneOf, ok := s["oneOf"].([]schema.Schema); ok {
+		for _, alt := range oneOf {
+			if props := schemaProperties(alt); props != nil {
+				return props
+			}
+		}
+	}
+	return nil
+}
+
+func schemaItems(s schema.Schema) schema.Schema {
+	if s == nil {
+		return nil
+	}
+	items, _ := s["items"].(schema.Schema)
+	return items
+}
+
+// parseOverrideValue parses the value as JSON5 so that numbers, booleans,
+// lists, and objects can be set, falling back to using it as a string. If
+// the field's schema s takes a string, the value is only parsed when it
+// becomes one of the field's other types, so that values like "1.0",
+// "null", or "0123" are kept as they were written.
+func parseOverrideValue(raw string, s schema.Schema) interface{} {
+	types := schemaTypes(s)
+	if len(types) == 1 && types["string"] {
+		return raw
+	}
+	var value interface{}
+	if err := json5.Unmarshal([]byte(raw), &value); err != nil {
+		return raw
+	}
+	if types["string"] && !types[jsonType(value)] {
+		return raw
+	}
+	return value
+}
+
+// schemaTypes returns the set of JSON types the schema accepts, looking
+// through oneOf, or nil if we don't know
+func schemaTypes(s schema.Schema) map[string]bool {
+	if s == nil {
+		return nil
+	}
+	types := map[string]bool{}
+	if t, ok := s["type"].(string); ok {
+		types[t] = true
+		if t == "integer" {
+			types["number"] = true // JSON5 numbers are all float64
+		}
+	}
+	if oneOf, ok := s["oneOf"].([]schema.Schema); ok {
+		for _, alt := range oneOf {
+			for t := range schemaTypes(alt) {
+				types[t] = true
+			}
+		}
+	}
+	return types
+}
+
+// jsonType returns the JSON schema type of a value parsed by JSON5
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// overridesComment returns a comment block listing the overrides that will
+// be applied to the rendered configuration, for the output of -template
+func overridesComment(rendered []byte, format string) []byte {
+	overrides := getOverrides(os.Environ())
+	if len(overrides) == 0 {
+		return nil
+	}
+	comment := "//"
+	if format == formatYAML || format == formatTOML {
+		comment = "#"
+	}
+	var errs decode.Errors
+	if configMap, err := unmarshalConfig(rendered, format); err == nil && configMap != nil {
+		applyOverrides(configMap, overrides, &errs)
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "\n%s overrides from the environment:\n", comment)
+	for _, o := range overrides {
+		if o.path != "" {
+			fmt.Fprintf(&buf, "%s   %s = %s\n", comment, o.path, o.value)
+		} else {
+			fmt.Fprintf(&buf, "%s   %s (not applied)\n", comment, o.env)
+		}
+	}
+	for _, err := range errs {
+		fmt.Fprintf(&buf, "%s   error: %s\n", comment, err)
+	}
+	return buf.Bytes()
+}
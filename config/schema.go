@@ -0,0 +1,96 @@
+package config
+
+import (
+	"github.com/joyent/containerpilot/config/logger"
+	"github.com/joyent/containerpilot/config/schema"
+	"github.com/joyent/containerpilot/control"
+	"github.com/joyent/containerpilot/discovery"
+	"github.com/joyent/containerpilot/jobs"
+	"github.com/joyent/containerpilot/telemetry"
+	"github.com/joyent/containerpilot/templates"
+	"github.com/joyent/containerpilot/watches"
+)
+
+// Schema returns a JSON Schema document describing the configuration file.
+// The top-level keys must match those handled by decodeConfig.
+func Schema() schema.Schema {
+	consul := schema.Schema{
+		"oneOf": []schema.Schema{
+			{
+				"type":        "string",
+				"description": "address of the Consul agent, ex. \"localhost:8500\"",
+			},
+			schema.FromStruct(discovery.ConsulConfig{}),
+		},
+	}
+
+	etcd := schema.Schema{
+		"oneOf": []schema.Schema{
+			{
+				"type":        "string",
+				"description": "URL of the etcd JSON gateway, ex. \"http://localhost:2379\"",
+			},
+			schema.FromStruct(discovery.EtcdConfig{}),
+		},
+	}
+	disc := schema.Schema{
+		"type":                 "object",
+		"additionalProperties": false,
+		"minProperties":        1,
+		"maxProperties":        1,
+		"properties": schema.Schema{
+			"consul": consul,
+			"etcd":   etcd,
+			"file": schema.Schema{
+				"type":        "string",
+				"description": "path of a JSON file of services and keys",
+			},
+			"plugin": schema.Schema{
+				"oneOf": []schema.Schema{
+					{"type": "string", "description": "exec of the plugin"},
+					{"type": "array", "items": schema.Schema{"type": "string"}},
+					schema.FromStruct(discovery.PluginConfig{}),
+				},
+			},
+		},
+	}
+
+	// the metrics are decoded separately from the rest of the telemetry
+	// config so we need to fill in their schema here
+	telem := schema.FromStruct(telemetry.Config{})
+	telem["properties"].(schema.Schema)["metrics"] = schema.Schema{
+		"type":  "array",
+		"items": schema.FromStruct(telemetry.MetricConfig{}),
+	}
+
+	return schema.Schema{
+		"$schema":              schema.Version,
+		"title":                "ContainerPilot configuration",
+		"type":                 "object",
+		"additionalProperties": false,
+		"oneOf": []schema.Schema{
+			{"required": []string{"consul"}},
+			{"required": []string{"discovery"}},
+		},
+		"properties": schema.Schema{
+			"consul":      consul,
+			"discovery":   disc,
+			"logging":     schema.FromStruct(logger.Config{}),
+			"stopTimeout": schema.Schema{"type": "integer", "minimum": 0},
+			"jobs": schema.Schema{
+				"type":  "array",
+				"items": schema.FromStruct(jobs.Config{}),
+			},
+			"watches": schema.Schema{
+				"type":  "array",
+				"items": schema.FromStruct(watches.Config{}),
+			},
+			"templates": schema.Schema{
+				"type":  "array",
+				"items": schema.FromStruct(templates.Config{}),
+			},
+			"telemetry": telem,
+			"control":   schema.FromStruct(control.Config{}),
+		},
+	}
+}
@@ -0,0 +1,173 @@
+// Package schema generates JSON Schema documents from the struct tags of
+// the configuration structs, so that the schema can't drift from what the
+// decoder actually accepts.
+package schema
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/joyent/containerpilot/events"
+)
+
+// Schema is a JSON Schema document or sub-document
+type Schema map[string]interface{}
+
+// Version is the JSON Schema draft that we generate
+const Version = "http://json-schema.org/draft-07/schema#"
+
+// matches the strings accepted by timing.ParseDuration: a bare number of
+// seconds or a sequence of decimal numbers with time.ParseDuration units
+const durationPattern = `^([0-9]+|([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+)$`
+
+// FromStruct generates a Schema for a struct value (or pointer to a
+// struct) from its `mapstructure` tags, falling back to `json` tags.
+// Fields without either tag aren't part of the configuration and are
+// skipped. The optional `schema` tag adds detail that the Go type can't
+// express, as a comma-separated list of:
+//
+//   required  the field must be present
+//   enum=a|b  the field is a string with one of the listed values, or a
+//             list of such strings if the field is a slice
+//   duration  a duration string like "5s" or an integer number of seconds
+//   command   a command line, either as a string or an array of strings
+//   strings   a string or an array of strings
+//   count     a positive integer, either as a number or a string; may be
+//             combined with enum for additional named values
+//   event     the name of an event, ex. "exitSuccess"
+func FromStruct(v interface{}) Schema {
+	return fromType(reflect.TypeOf(v))
+}
+
+func fromType(t reflect.Type) Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		return fromStructType(t)
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return Schema{"type": "array", "items": fromType(t.Elem())}
+	case reflect.Map:
+		return Schema{"type": "object", "additionalProperties": fromType(t.Elem())}
+	default:
+		return Schema{} // interface{} accepts anything
+	}
+}
+
+func fromStructType(t reflect.Type) Schema {
+	properties := Schema{}
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue // unexported
+		}
+		name := fieldName(field)
+		if name == "" {
+			continue
+		}
+		opts := parseOptions(field.Tag.Get("schema"))
+		if opts.required {
+			required = append(required, name)
+		}
+		properties[name] = fieldSchema(field.Type, opts)
+	}
+	s := Schema{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+func fieldName(field reflect.StructField) string {
+	for _, key := range []string{"mapstructure", "json"} {
+		tag := strings.SplitN(field.Tag.Get(key), ",", 2)[0]
+		if tag != "" && tag != "-" {
+			return tag
+		}
+	}
+	return ""
+}
+
+type options struct {
+	required bool
+	kind     string
+	enum     []string
+}
+
+func parseOptions(tag string) options {
+	opts := options{}
+	if tag == "" {
+		return opts
+	}
+	for _, opt := range strings.Split(tag, ",") {
+		switch {
+		case opt == "required":
+			opts.required = true
+		case strings.HasPrefix(opt, "enum="):
+			opts.enum = strings.Split(strings.TrimPrefix(opt, "enum="), "|")
+		default:
+			opts.kind = opt
+		}
+	}
+	return opts
+}
+
+func fieldSchema(t reflect.Type, opts options) Schema {
+	stringOrList := []Schema{
+		{"type": "string"},
+		{"type": "array", "items": Schema{"type": "string"}},
+	}
+	switch opts.kind {
+	case "duration":
+		return Schema{
+			"description": "a duration such as \"300ms\" or \"1m30s\", " +
+				"or an integer number of seconds",
+			"oneOf": []Schema{
+				{"type": "integer", "minimum": 0},
+				{"type": "string", "pattern": durationPattern},
+			},
+		}
+	case "command":
+		return Schema{
+			"description": "a command line, either as a single string " +
+				"or as an array of the executable and its arguments",
+			"oneOf": stringOrList,
+		}
+	case "strings":
+		return Schema{"oneOf": stringOrList}
+	case "count":
+		oneOf := []Schema{
+			{"type": "integer", "minimum": 0},
+			{"type": "string", "pattern": "^[0-9]+$"},
+		}
+		if len(opts.enum) > 0 {
+			oneOf = append(oneOf, Schema{"type": "string", "enum": opts.enum})
+		}
+		return Schema{"oneOf": oneOf}
+	case "event":
+		return Schema{"type": "string", "enum": events.ConfigNames()}
+	}
+	if len(opts.enum) > 0 {
+		enum := Schema{"type": "string", "enum": opts.enum}
+		if t.Kind() == reflect.Slice {
+			return Schema{"type": "array", "items": enum}
+		}
+		return enum
+	}
+	return fromType(t)
+}
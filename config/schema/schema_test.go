@@ -0,0 +1,56 @@
+package schema
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testHealth struct {
+	Interval int    `mapstructure:"interval"`
+	Timeout  string `mapstructure:"timeout" schema:"duration"`
+}
+
+type testJob struct {
+	Name     string      `mapstructure:"name" schema:"required"`
+	Exec     interface{} `mapstructure:"exec" schema:"command"`
+	Status   string      `mapstructure:"status" schema:"enum=a|b"`
+	Restarts interface{} `mapstructure:"restarts" schema:"count,enum=never"`
+	When     string      `mapstructure:"when" schema:"event"`
+	Tags     []string    `mapstructure:"tags"`
+	Compare  []string    `mapstructure:"compare" schema:"enum=x|y"`
+	Health   *testHealth `mapstructure:"health"`
+	Level    string      `json:"level"`
+	Derived  string
+	private  string
+}
+
+func TestFromStruct(t *testing.T) {
+	s := FromStruct(&testJob{})
+	assert := assert.New(t)
+	assert.Equal("object", s["type"])
+	assert.Equal(false, s["additionalProperties"])
+	assert.Equal([]string{"name"}, s["required"])
+
+	props := s["properties"].(Schema)
+	assert.Equal(9, len(props), "untagged and unexported fields are skipped")
+	assert.Equal(Schema{"type": "string"}, props["name"])
+	assert.Equal(Schema{"type": "string"}, props["level"])
+	assert.Equal(Schema{"type": "string", "enum": []string{"a", "b"}}, props["status"])
+	assert.Equal(Schema{"type": "array", "items": Schema{"type": "string"}}, props["tags"])
+	assert.Equal(Schema{"type": "array", "items": Schema{"type": "string",
+		"enum": []string{"x", "y"}}}, props["compare"])
+	assert.Contains(props["exec"].(Schema), "oneOf")
+	assert.Equal(3, len(props["restarts"].(Schema)["oneOf"].([]Schema)))
+	assert.Contains(props["when"].(Schema)["enum"], "exitSuccess")
+	assert.NotContains(props["when"].(Schema)["enum"], "quit")
+
+	health := props["health"].(Schema)["properties"].(Schema)
+	assert.Equal(Schema{"type": "integer"}, health["interval"])
+	assert.Contains(health["timeout"].(Schema), "oneOf")
+
+	if _, err := json.Marshal(s); err != nil {
+		t.Fatalf("could not marshal schema: %v", err)
+	}
+}
@@ -3,9 +3,20 @@ package services
 import (
 	"fmt"
 	"regexp"
+	"strings"
 )
 
-var validName = regexp.MustCompile(`^[a-z][a-zA-Z0-9\-]+$`)
+var (
+	validName    = regexp.MustCompile(`^[a-z][a-zA-Z0-9\-]+$`)
+	validMetaKey = regexp.MustCompile(`^[a-zA-Z0-9_\-]+$`)
+)
+
+// limits on service metadata imposed by Consul
+const (
+	maxMetaPairs    = 64
+	maxMetaKeyLen   = 128
+	maxMetaValueLen = 512
+)
 
 // ValidateName checks if the service name passed as an argument
 // is is alpha-numeric with dashes. This ensures compliance with both DNS
@@ -19,3 +30,29 @@ func ValidateName(name string) error {
 	}
 	return nil
 }
+
+// ValidateMeta checks that the service metadata passed as an argument
+// meets the limits that Consul places on it: keys are alpha-numeric with
+// dashes and underscores and aren't reserved for Consul's own use.
+func ValidateMeta(meta map[string]string) error {
+	if len(meta) > maxMetaPairs {
+		return fmt.Errorf("service meta must have at most %d keys", maxMetaPairs)
+	}
+	for key, value := range meta {
+		if !validMetaKey.MatchString(key) {
+			return fmt.Errorf("service meta key '%s' must be alphanumeric with dashes or underscores", key)
+		}
+		if len(key) > maxMetaKeyLen {
+			return fmt.Errorf("service meta key '%s' must be at most %d characters",
+				key, maxMetaKeyLen)
+		}
+		if strings.HasPrefix(key, "consul-") {
+			return fmt.Errorf("service meta key '%s' is reserved for Consul", key)
+		}
+		if len(value) > maxMetaValueLen {
+			return fmt.Errorf("service meta value for '%s' must be at most %d characters",
+				key, maxMetaValueLen)
+		}
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -28,3 +29,27 @@ func TestValidateName(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateMeta(t *testing.T) {
+	validMeta := map[string]string{
+		"version":      "1.2.3",
+		"git_sha":      "abc123",
+		"metrics-port": "9090",
+	}
+	if err := ValidateMeta(validMeta); err != nil {
+		t.Errorf("expected no error for meta %v but got %v", validMeta, err)
+	}
+
+	var invalidMeta = []map[string]string{
+		{"my.key": "x"},
+		{"": "x"},
+		{"consul-version": "x"},
+		{strings.Repeat("k", 129): "x"},
+		{"key": strings.Repeat("v", 513)},
+	}
+	for _, meta := range invalidMeta {
+		if err := ValidateMeta(meta); err == nil {
+			t.Errorf("expected error for meta '%v' but got nil", meta)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package template
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/joyent/containerpilot/config/services"
+)
+
+// file returns the contents of the file at path
+func file(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// hostname returns the hostname reported by the kernel
+func hostname() (string, error) {
+	return os.Hostname()
+}
+
+// add returns the sum of its arguments, which may be integers or strings
+// such as environment variables that contain integers
+func add(a, b interface{}) (int, error) {
+	x, err := ensureInt(a)
+	if err != nil {
+		return 0, err
+	}
+	y, err := ensureInt(b)
+	if err != nil {
+		return 0, err
+	}
+	return x + y, nil
+}
+
+// mul returns the product of its arguments, which may be integers or
+// strings such as environment variables that contain integers
+func mul(a, b interface{}) (int, error) {
+	x, err := ensureInt(a)
+	if err != nil {
+		return 0, err
+	}
+	y, err := ensureInt(b)
+	if err != nil {
+		return 0, err
+	}
+	return x * y, nil
+}
+
+// toJSON encodes a value as JSON so that it can be safely embedded
+// in the configuration, ex. a string with quotes in it
+func toJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// fromJSON decodes a JSON document so that its fields can be used in
+// the template, ex. `{{ (fromJSON .SERVICES).db.port }}`
+func fromJSON(s string) (interface{}, error) {
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func base64Encode(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func base64Decode(s string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// sha256Sum returns the hex-encoded SHA-256 hash of a string
+func sha256Sum(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// required returns the value if it's set, and otherwise fails rendering
+// with the given message, ex. `{{ .CONSUL | required "CONSUL must be set" }}`
+func required(msg string, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, errors.New(msg)
+	}
+	if s, ok := v.(string); ok && s == "" {
+		return nil, errors.New(msg)
+	}
+	return v, nil
+}
+
+// contains reports whether s contains substr if s is a string, or whether
+// substr is one of the elements if s is a list (such as the result of split)
+func contains(substr string, s interface{}) (bool, error) {
+	if str, ok := s.(string); ok {
+		return strings.Contains(str, substr), nil
+	}
+	val := reflect.ValueOf(s)
+	if val.Kind() != reflect.Slice && val.Kind() != reflect.Array {
+		return false, fmt.Errorf("expected a string or a list, but got %T", s)
+	}
+	for i := 0; i < val.Len(); i++ {
+		if fmt.Sprintf("%v", val.Index(i).Interface()) == substr {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ip returns the IP address of the container for the given interface
+// specs, as used by the `interfaces` field of jobs. If no specs are given
+// we use the same default as jobs do.
+func ip(specs ...string) (string, error) {
+	return services.GetIP(specs)
+}
@@ -10,6 +10,8 @@ import (
 	"strconv"
 	"strings"
 	"text/template"
+
+	"github.com/joyent/containerpilot/config/decode"
 )
 
 // Environment is a map of environment variables to their values
@@ -65,15 +67,24 @@ func envFunc(env string) string {
 }
 
 func ensureInt(intv interface{}) (int, error) {
-	switch intv.(type) {
+	switch v := intv.(type) {
 	case string:
-		ret, err := strconv.Atoi(intv.(string))
+		ret, err := strconv.Atoi(strings.TrimSpace(v))
 		if err != nil {
-			return 0, err
+			return 0, fmt.Errorf("expected an integer but got %q", v)
 		}
 		return ret, nil
+	case int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case float64:
+		if v != float64(int(v)) {
+			return 0, fmt.Errorf("expected an integer but got %v", v)
+		}
+		return int(v), nil
 	default:
-		return intv.(int), nil
+		return 0, fmt.Errorf("expected an integer but got %T", intv)
 	}
 }
 
@@ -124,6 +135,7 @@ func loop(params ...interface{}) ([]int, error) {
 type Template struct {
 	Template *template.Template
 	Env      Environment
+	source   []byte
 }
 
 func defaultValue(defaultValue, templateValue interface{}) string {
@@ -139,37 +151,101 @@ func defaultValue(defaultValue, templateValue interface{}) string {
 	return defaultStr
 }
 
+// funcs are the extra functions available to templates, in addition to
+// the text/template builtins
+var funcs = template.FuncMap{
+	"default":         defaultValue,
+	"env":             envFunc,
+	"split":           split,
+	"join":            join,
+	"replaceAll":      replaceAll,
+	"regexReplaceAll": regexReplaceAll,
+	"loop":            loop,
+	"file":            file,
+	"hostname":        hostname,
+	"add":             add,
+	"mul":             mul,
+	"toJSON":          toJSON,
+	"fromJSON":        fromJSON,
+	"base64Encode":    base64Encode,
+	"base64Decode":    base64Decode,
+	"lower":           strings.ToLower,
+	"upper":           strings.ToUpper,
+	"required":        required,
+	"sha256":          sha256Sum,
+	"contains":        contains,
+	"ip":              ip,
+}
+
+// Funcs returns a copy of the extra functions available to templates, so
+// that other packages can add their own before parsing with NewNamedTemplate
+func Funcs() template.FuncMap {
+	fm := template.FuncMap{}
+	for name, fn := range funcs {
+		fm[name] = fn
+	}
+	return fm
+}
+
 // NewTemplate creates a Template parsed from the configuration
 // and the current environment variables
 func NewTemplate(config []byte) (*Template, error) {
+	return NewNamedTemplate(configTemplateName, config, funcs)
+}
+
+// NewNamedTemplate creates a Template parsed from text with the given
+// functions and the current environment variables. The name is used to
+// report the location of errors in parsing or rendering the template.
+func NewNamedTemplate(name string, text []byte, fm template.FuncMap) (*Template, error) {
 	env := parseEnvironment(os.Environ())
-	tmpl, err := template.New("").Funcs(template.FuncMap{
-		"default":         defaultValue,
-		"env":             envFunc,
-		"split":           split,
-		"join":            join,
-		"replaceAll":      replaceAll,
-		"regexReplaceAll": regexReplaceAll,
-		"loop":            loop,
-	}).Option("missingkey=zero").Parse(string(config))
+	tmpl, err := template.New(name).Funcs(fm).
+		Option("missingkey=zero").Parse(string(text))
 	if err != nil {
-		return nil, err
+		return nil, newTemplateError(name, text, err)
 	}
 	return &Template{
 		Env:      env,
 		Template: tmpl,
+		source:   text,
 	}, nil
 }
 
+// CurrentEnvironment returns the environment variables of this process,
+// for refreshing the Env of a Template that's rendered more than once.
+func CurrentEnvironment() Environment {
+	return parseEnvironment(os.Environ())
+}
+
 // Execute renders the template
 func (c *Template) Execute() ([]byte, error) {
 	var buffer bytes.Buffer
 	if err := c.Template.Execute(&buffer, c.Env); err != nil {
-		return nil, err
+		return nil, newTemplateError(c.Template.Name(), c.source, err)
 	}
 	return buffer.Bytes(), nil
 }
 
+const configTemplateName = "config"
+
+// newTemplateError rewrites the errors from text/template to report the
+// line of the template where the error happened and highlight that line,
+// the same way we report errors parsing the rendered configuration.
+func newTemplateError(name string, source []byte, err error) error {
+	// match the location text/template puts at the start of its parse
+	// and execution errors, ex. "template: config:3:14: executing ..."
+	templateErrRe := regexp.MustCompile(`^template: ` + regexp.QuoteMeta(name) +
+		`:([0-9]+):(?:([0-9]+):)? ?(.*)$`)
+	match := templateErrRe.FindStringSubmatch(err.Error())
+	if match == nil {
+		return err
+	}
+	line, _ := strconv.Atoi(match[1])
+	col, _ := strconv.Atoi(match[2])
+	msg := strings.TrimPrefix(match[3], fmt.Sprintf("executing %q ", name))
+	return fmt.Errorf("template error at line %d: %s\n%s",
+		line, msg, decode.HighlightLine(source, line, col))
+}
+
 // Apply creates and renders a template from the given config template
 func Apply(config []byte) ([]byte, error) {
 	template, err := NewTemplate(config)
@@ -1,7 +1,9 @@
 package template
 
 import (
+	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -68,3 +70,83 @@ func TestTemplate(t *testing.T) {
 	testTemplate("Regex Replace All",
 		`Hello, {{.NAME | regexReplaceAll "[epa]+" "_" }}!`, "Hello, T_m_l_t_!")
 }
+
+func TestTemplateFunctions(t *testing.T) {
+	env := parseEnvironment([]string{
+		"NAME=Template",
+		"COUNT=3",
+		"TAGS=blue,green",
+		"SERVICES={\"db\": {\"port\": 5432}}",
+	})
+	f, _ := ioutil.TempFile("", "template-test")
+	f.WriteString("file contents")
+	f.Close()
+	defer os.Remove(f.Name())
+	host, _ := os.Hostname()
+
+	testTemplate := func(name string, template string, expected string) {
+		tmpl, err := NewTemplate([]byte(template))
+		if err != nil {
+			t.Fatalf("%s - error parsing template: %s", name, err)
+		}
+		tmpl.Env = env
+		res, err := tmpl.Execute()
+		if err != nil {
+			t.Fatalf("%s - error executing template: %s", name, err)
+		}
+		if string(res) != expected {
+			t.Fatalf("%s - expected %s but got: %s", name, expected, res)
+		}
+	}
+
+	testTemplate("file", `{{ file "`+f.Name()+`" }}`, "file contents")
+	testTemplate("hostname", `{{ hostname }}`, host)
+	testTemplate("add", `{{ add .COUNT 2 }}`, "5")
+	testTemplate("mul", `{{ mul .COUNT 2 }}`, "6")
+	testTemplate("add mul", `{{ add 1 (mul .COUNT .COUNT) }}`, "10")
+	testTemplate("toJSON", `{{ .NAME | toJSON }}`, `"Template"`)
+	testTemplate("toJSON list", `{{ .TAGS | split "," | toJSON }}`, `["blue","green"]`)
+	testTemplate("fromJSON", `{{ (fromJSON .SERVICES).db.port }}`, "5432")
+	testTemplate("base64Encode", `{{ .NAME | base64Encode }}`, "VGVtcGxhdGU=")
+	testTemplate("base64Decode", `{{ "VGVtcGxhdGU=" | base64Decode }}`, "Template")
+	testTemplate("lower", `{{ .NAME | lower }}`, "template")
+	testTemplate("upper", `{{ .NAME | upper }}`, "TEMPLATE")
+	testTemplate("required", `{{ .NAME | required "NAME must be set" }}`, "Template")
+	testTemplate("sha256", `{{ "abc" | sha256 }}`,
+		"ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad")
+	testTemplate("contains", `{{ .NAME | contains "mpl" }}`, "true")
+	testTemplate("contains list", `{{ .TAGS | split "," | contains "green" }}`, "true")
+	testTemplate("not contains list", `{{ .TAGS | split "," | contains "gre" }}`, "false")
+	testTemplate("ip", `{{ ip "lo" }}`, "127.0.0.1")
+}
+
+func TestTemplateErrors(t *testing.T) {
+	testError := func(name, template, expected string) {
+		_, err := Apply([]byte(template))
+		if err == nil {
+			t.Fatalf("%s - expected error but got nil", name)
+		}
+		msg := strings.SplitN(err.Error(), "\n", 2)[0]
+		if msg != expected {
+			t.Fatalf("%s - expected error:\n%s\nbut got:\n%s", name, expected, msg)
+		}
+	}
+	testError("required",
+		"{\n  consul: \"{{ .NO_SUCH_KEY | required \"CONSUL must be set\" }}\"\n}",
+		`template error at line 2: at <required "CONSUL must be set">: `+
+			`error calling required: CONSUL must be set`)
+	testError("undefined function", "{\n  {{ nope }}\n}",
+		`template error at line 2: function "nope" not defined`)
+	testError("bad integer", `{{ add 1 "x" }}`,
+		`template error at line 1: at <add 1 "x">: `+
+			`error calling add: expected an integer but got "x"`)
+	testError("missing file", `{{ file "/xxxx" }}`,
+		`template error at line 1: at <file "/xxxx">: `+
+			`error calling file: open /xxxx: no such file or directory`)
+
+	_, err := Apply([]byte("{\n  a: {{ nope }}\n}"))
+	assert.Equal(t, `template error at line 2: function "nope" not defined
+    1: {
+    2:   a: {{ nope }}
+---------^`, err.Error())
+}
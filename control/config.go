@@ -1,8 +1,6 @@
 package control
 
 import (
-	"fmt"
-
 	"github.com/joyent/containerpilot/config/decode"
 )
 
@@ -24,7 +22,7 @@ func NewConfig(raw interface{}) (*Config, error) {
 	}
 
 	if err := decode.ToStruct(raw, cfg); err != nil {
-		return nil, fmt.Errorf("control config parsing error: %v", err)
+		return nil, err
 	}
 
 	return cfg, nil
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/joyent/containerpilot/events"
+	"github.com/joyent/containerpilot/watches"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 )
@@ -36,8 +37,11 @@ func init() {
 // HTTP transport control plane. Currently this is listening via a UNIX socket
 // file.
 type HTTPServer struct {
-	Addr string
-	Bus  *events.EventBus
+	Addr    string
+	Bus     *events.EventBus
+	watches []*watches.Watch
+
+	maintenance *maintenanceState
 
 	http.Server
 	events.Publisher
@@ -47,7 +51,8 @@ type HTTPServer struct {
 // ContainerPilot's runtime configuration.
 func NewHTTPServer(cfg *Config) (*HTTPServer, error) {
 	srv := &HTTPServer{
-		Addr: cfg.SocketPath,
+		Addr:        cfg.SocketPath,
+		maintenance: &maintenanceState{},
 	}
 	if err := srv.Validate(); err != nil {
 		return nil, fmt.Errorf("control: validate failed with %s", err)
@@ -72,6 +77,18 @@ func (srv *HTTPServer) Validate() error {
 	return nil
 }
 
+// MonitorWatches adds a list of Watches for the /v3/watches endpoint
+func (srv *HTTPServer) MonitorWatches(watches []*watches.Watch) {
+	srv.watches = append(srv.watches, watches...)
+}
+
+// MaintenanceReason returns the reason given when maintenance mode was
+// enabled, or an empty string if it wasn't given or we're not in
+// maintenance mode
+func (srv *HTTPServer) MaintenanceReason() string {
+	return srv.maintenance.get()
+}
+
 // Run executes the event loop for the control server
 func (srv *HTTPServer) Run(pctx context.Context, bus *events.EventBus) {
 	ctx, cancel := context.WithCancel(pctx)
@@ -89,8 +106,10 @@ func (srv *HTTPServer) Run(pctx context.Context, bus *events.EventBus) {
 // and serves the HTTP server.
 func (srv *HTTPServer) Start(cancel context.CancelFunc) {
 	endpoints := &Endpoints{
-		bus:    srv.Publisher.Bus,
-		cancel: cancel,
+		bus:         srv.Publisher.Bus,
+		cancel:      cancel,
+		watches:     srv.watches,
+		maintenance: srv.maintenance,
 	}
 
 	router := http.NewServeMux()
@@ -104,6 +123,8 @@ func (srv *HTTPServer) Start(cancel context.CancelFunc) {
 		PostHandler(endpoints.PostEnableMaintenanceMode))
 	router.Handle("/v3/maintenance/disable",
 		PostHandler(endpoints.PostDisableMaintenanceMode))
+	router.Handle("/v3/watches/",
+		GetHandler(endpoints.GetWatch))
 	router.HandleFunc("/v3/ping", GetPing)
 
 	srv.Handler = router
@@ -9,16 +9,22 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
 
+	"github.com/joyent/containerpilot/discovery"
 	"github.com/joyent/containerpilot/events"
+	"github.com/joyent/containerpilot/watches"
 	log "github.com/sirupsen/logrus"
 )
 
 // Endpoints wraps the EventBus so we can bridge data across the App and
 // HTTPServer API boundary
 type Endpoints struct {
-	bus    *events.EventBus
-	cancel context.CancelFunc
+	bus         *events.EventBus
+	cancel      context.CancelFunc
+	watches     []*watches.Watch
+	maintenance *maintenanceState
 }
 
 // PostHandler is an adapter which allows a normal function to serve itself and
@@ -51,6 +57,30 @@ func (pw PostHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	collector.WithLabelValues(strconv.Itoa(status), r.URL.Path).Inc()
 }
 
+// GetHandler is an adapter which allows a normal function to serve itself and
+// handle incoming HTTP GET requests with a JSON response
+type GetHandler func(*http.Request) (interface{}, int)
+
+func (gh GetHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		failedStatus := http.StatusMethodNotAllowed
+		http.Error(w, http.StatusText(failedStatus), failedStatus)
+		collector.WithLabelValues(
+			strconv.Itoa(http.StatusMethodNotAllowed), r.URL.Path).Inc()
+		return
+	}
+	resp, status := gh(r)
+	switch status {
+	case http.StatusOK:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(resp)
+	default:
+		http.Error(w, http.StatusText(status), status)
+	}
+	collector.WithLabelValues(strconv.Itoa(status), r.URL.Path).Inc()
+}
+
 // PutEnviron handles incoming HTTP POST requests containing JSON environment
 // variables and updates the environment of our current ContainerPilot
 // process. Returns empty response or HTTP422.
@@ -85,12 +115,49 @@ func (e Endpoints) PostReload(r *http.Request) (interface{}, int) {
 	return nil, http.StatusOK
 }
 
+// maintenanceState is the reason given when maintenance mode was enabled,
+// which is shared with the jobs so they can pass it on to the discovery
+// backend and to the jobs started by entering maintenance mode
+type maintenanceState struct {
+	lock   sync.RWMutex
+	reason string
+}
+
+func (m *maintenanceState) get() string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.reason
+}
+
+func (m *maintenanceState) set(reason string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.reason = reason
+}
+
 // PostEnableMaintenanceMode handles incoming HTTP POST requests and toggles
-// ContainerPilot maintenance mode on. Returns empty response or HTTP422.
+// ContainerPilot maintenance mode on. The request body can be a JSON object
+// with a "reason" field; a body that isn't one is ignored to support older
+// clients. Returns empty response or HTTP422.
 func (e Endpoints) PostEnableMaintenanceMode(r *http.Request) (interface{}, int) {
+	reason := ""
 	if r.Body != nil {
 		defer r.Body.Close()
+		jsonBlob, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, http.StatusUnprocessableEntity
+		}
+		var body struct {
+			Reason string `json:"reason"`
+		}
+		if err := json.Unmarshal(jsonBlob, &body); err != nil {
+			log.Debugf("control: ignoring maintenance request body: %v", err)
+		}
+		reason = body.Reason
 	}
+	// the reason is set before the event is published so that the jobs
+	// will see it when they handle the event
+	e.maintenance.set(reason)
 	e.bus.Publish(events.GlobalEnterMaintenance)
 	return nil, http.StatusOK
 }
@@ -101,6 +168,7 @@ func (e Endpoints) PostDisableMaintenanceMode(r *http.Request) (interface{}, int
 	if r.Body != nil {
 		defer r.Body.Close()
 	}
+	e.maintenance.set("")
 	e.bus.Publish(events.GlobalExitMaintenance)
 	return nil, http.StatusOK
 }
@@ -128,6 +196,35 @@ func (e Endpoints) PostMetric(r *http.Request) (interface{}, int) {
 	return nil, http.StatusOK
 }
 
+// watchResponse is the body of the response to GetWatch
+type watchResponse struct {
+	Name      string
+	Instances []*discovery.ServiceInstance
+	Changes   *watches.Changes
+}
+
+// GetWatch handles incoming HTTP GET requests for /v3/watches/<name> and
+// returns the healthy instances found by the last poll of the watch, and
+// the changes found by the last poll that saw a change. The
+// name can be given with or without the "watch." prefix. Returns HTTP404
+// if there's no such watch.
+func (e Endpoints) GetWatch(r *http.Request) (interface{}, int) {
+	name := strings.TrimPrefix(r.URL.Path, "/v3/watches/")
+	if !strings.HasPrefix(name, "watch.") {
+		name = "watch." + name
+	}
+	for _, watch := range e.watches {
+		if watch.Name == name {
+			return watchResponse{
+				Name:      watch.Name,
+				Instances: watch.Instances(),
+				Changes:   watch.Changes(),
+			}, http.StatusOK
+		}
+	}
+	return nil, http.StatusNotFound
+}
+
 // GetPing allows us to check if the control socket is up without
 // making a mutation of ContainerPilot's state
 func GetPing(w http.ResponseWriter, r *http.Request) {
@@ -12,7 +12,11 @@ import (
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/joyent/containerpilot/discovery"
 	"github.com/joyent/containerpilot/events"
+	"github.com/joyent/containerpilot/tests"
+	"github.com/joyent/containerpilot/tests/mocks"
+	"github.com/joyent/containerpilot/watches"
 )
 
 func TestPutEnviron(t *testing.T) {
@@ -145,13 +149,15 @@ func TestPostMetric(t *testing.T) {
 }
 
 func TestPostEnableMaintenanceMode(t *testing.T) {
+	maintenance := &maintenanceState{}
 	testFunc := func(t *testing.T, expected map[events.Event]int, req *http.Request) int {
 		_, cancel := context.WithCancel(context.Background())
 		bus := events.NewEventBus()
 		bus.Publish(events.GlobalStartup)
 		endpoints := &Endpoints{
-			bus:    bus,
-			cancel: cancel,
+			bus:         bus,
+			cancel:      cancel,
+			maintenance: maintenance,
 		}
 		_, status := endpoints.PostEnableMaintenanceMode(req)
 		results := bus.DebugEvents()
@@ -178,16 +184,28 @@ func TestPostEnableMaintenanceMode(t *testing.T) {
 		status := testFunc(t, expected, req)
 		assert.Equal(t, http.StatusOK, status, "status was not 200OK")
 	})
+	t.Run("POST reason", func(t *testing.T) {
+		body := "{\"reason\": \"upgrading\"}"
+		req, _ := http.NewRequest("POST", "/v3/maintenance/enable", strings.NewReader(body))
+		expected := map[events.Event]int{events.GlobalEnterMaintenance: 1}
+		status := testFunc(t, expected, req)
+		assert.Equal(t, http.StatusOK, status, "status was not 200OK")
+		assert.Equal(t, "upgrading", maintenance.get())
+		_, ok := os.LookupEnv("CONTAINERPILOT_MAINTENANCE_REASON")
+		assert.False(t, ok, "reason should not be set in our environment")
+	})
 }
 
 func TestPostDisableMaintenanceMode(t *testing.T) {
+	maintenance := &maintenanceState{reason: "upgrading"}
 	testFunc := func(t *testing.T, expected map[events.Event]int, req *http.Request) int {
 		_, cancel := context.WithCancel(context.Background())
 		bus := events.NewEventBus()
 		bus.Publish(events.GlobalStartup)
 		endpoints := &Endpoints{
-			bus:    bus,
-			cancel: cancel,
+			bus:         bus,
+			cancel:      cancel,
+			maintenance: maintenance,
 		}
 		_, status := endpoints.PostDisableMaintenanceMode(req)
 		bus.Wait()
@@ -214,9 +232,51 @@ func TestPostDisableMaintenanceMode(t *testing.T) {
 		expected := map[events.Event]int{events.GlobalExitMaintenance: 1}
 		status := testFunc(t, expected, req)
 		assert.Equal(t, http.StatusOK, status, "status was not 200OK")
+		assert.Equal(t, "", maintenance.get())
 	})
 }
 
+func TestGetWatch(t *testing.T) {
+	disc := &mocks.NoopDiscoveryBackend{
+		Instances: []*discovery.ServiceInstance{
+			{ID: "app-1", Name: "app", Address: "10.0.0.1", Port: 80,
+				Tags: []string{"prod"}, Node: "node1"},
+		},
+	}
+	cfgs, _ := watches.NewConfigs(
+		tests.DecodeRawToSlice(`[{"name": "app", "interval": 1}]`), disc)
+	endpoints := &Endpoints{watches: watches.FromConfigs(cfgs)}
+
+	testFunc := func(method, path string) (int, string) {
+		req := httptest.NewRequest(method, path, nil)
+		w := httptest.NewRecorder()
+		GetHandler(endpoints.GetWatch).ServeHTTP(w, req)
+		resp := w.Result()
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	expected := `{"Name":"watch.app","Instances":[{"ID":"app-1","Name":"app",` +
+		`"Address":"10.0.0.1","Port":80,"Tags":["prod"],"Meta":null,` +
+		`"Node":"node1","NodeMeta":null,"Checks":null}],` +
+		`"Changes":{"Added":[],"Removed":[],"Updated":[]}}` + "\n"
+	status, result := testFunc("GET", "/v3/watches/app")
+	assert.Equal(t, http.StatusOK, status, "expected HTTP 200 OK")
+	assert.Equal(t, expected, result)
+
+	status, result = testFunc("GET", "/v3/watches/watch.app")
+	assert.Equal(t, http.StatusOK, status, "expected HTTP 200 OK")
+	assert.Equal(t, expected, result)
+
+	status, _ = testFunc("GET", "/v3/watches/nope")
+	assert.Equal(t, http.StatusNotFound, status, "expected HTTP404 not found")
+
+	status, _ = testFunc("POST", "/v3/watches/app")
+	assert.Equal(t, http.StatusMethodNotAllowed, status,
+		"expected HTTP405 method not allowed")
+}
+
 func TestGetPing(t *testing.T) {
 	req := httptest.NewRequest("GET", "/v3/ping", nil)
 	w := httptest.NewRecorder()
@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"sync"
@@ -16,6 +17,7 @@ import (
 	"github.com/joyent/containerpilot/events"
 	"github.com/joyent/containerpilot/jobs"
 	"github.com/joyent/containerpilot/telemetry"
+	"github.com/joyent/containerpilot/templates"
 	"github.com/joyent/containerpilot/watches"
 
 	log "github.com/sirupsen/logrus"
@@ -27,10 +29,12 @@ type App struct {
 	Discovery     discovery.Backend
 	Jobs          []*jobs.Job
 	Watches       []*watches.Watch
+	Templates     []*templates.Template
 	Telemetry     *telemetry.Telemetry
 	StopTimeout   int
 	signalLock    *sync.RWMutex
 	ConfigFlag    string
+	ConfigFormat  string
 	Bus           *events.EventBus
 }
 
@@ -42,10 +46,10 @@ func EmptyApp() *App {
 }
 
 // NewApp creates a new App from the config
-func NewApp(configFlag string) (*App, error) {
+func NewApp(configFlag, formatFlag string) (*App, error) {
 	os.Setenv("CONTAINERPILOT_PID", fmt.Sprintf("%v", os.Getpid()))
 	a := EmptyApp()
-	cfg, err := config.LoadConfig(configFlag)
+	cfg, err := config.LoadConfig(configFlag, formatFlag)
 	if err != nil {
 		return nil, err
 	}
@@ -71,10 +75,16 @@ func NewApp(configFlag string) (*App, error) {
 	a.Discovery = cfg.Discovery
 	a.Jobs = jobs.FromConfigs(cfg.Jobs)
 	a.Watches = watches.FromConfigs(cfg.Watches)
+	a.Templates = templates.FromConfigs(cfg.Templates)
 	a.Telemetry = telemetry.NewTelemetry(cfg.Telemetry)
 	a.Telemetry.MonitorJobs(a.Jobs)
 	a.Telemetry.MonitorWatches(a.Watches)
+	a.ControlServer.MonitorWatches(a.Watches)
+	for _, job := range a.Jobs {
+		job.MaintenanceReason = a.ControlServer.MaintenanceReason
+	}
 	a.ConfigFlag = configFlag // stash the old config
+	a.ConfigFormat = formatFlag
 
 	// set an environment variable for each job IP address so that
 	// forked processes have access to this information
@@ -161,6 +171,7 @@ func (a *App) Run() {
 		}
 		close(completedCh)
 	}
+	a.closeDiscovery()
 }
 
 // Terminate kills the application
@@ -181,20 +192,47 @@ func (a *App) SignalEvent(sig string) {
 // updating the App with those changes. The EventBus should be
 // already shut down before we call this.
 func (a *App) reload() error {
-	newApp, err := NewApp(a.ConfigFlag)
+	newApp, err := NewApp(a.ConfigFlag, a.ConfigFormat)
 	if err != nil {
 		log.Errorf("error initializing config: %v", err)
 		return err
 	}
+	a.closeDiscovery()
+	a.clearRemovedWatches(newApp.Watches)
 	a.Discovery = newApp.Discovery
 	a.Jobs = newApp.Jobs
 	a.Watches = newApp.Watches
+	a.Templates = newApp.Templates
 	a.StopTimeout = newApp.StopTimeout
 	a.Telemetry = newApp.Telemetry
 	a.ControlServer = newApp.ControlServer
 	return nil
 }
 
+// closeDiscovery stops the discovery backend when we're done with it,
+// because some backends run processes that need to be stopped
+func (a *App) closeDiscovery() {
+	if closer, ok := a.Discovery.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Errorf("error closing discovery backend: %v", err)
+		}
+	}
+}
+
+// clearRemovedWatches unsets the environment variables of the watches that
+// aren't in the new configuration, so that new processes don't see them
+func (a *App) clearRemovedWatches(newWatches []*watches.Watch) {
+	names := map[string]bool{}
+	for _, watch := range newWatches {
+		names[watch.Name] = true
+	}
+	for _, watch := range a.Watches {
+		if !names[watch.Name] {
+			watch.ClearEnv()
+		}
+	}
+}
+
 // HandlePolling sets up polling functions and write their quit channels
 // back to our config
 func (a *App) runTasks(ctx context.Context, completedCh chan struct{}) {
@@ -208,6 +246,9 @@ func (a *App) runTasks(ctx context.Context, completedCh chan struct{}) {
 	for _, job := range a.Jobs {
 		job.Run(ctx, completedCh)
 	}
+	for _, tmpl := range a.Templates {
+		tmpl.Run(ctx, a.Bus)
+	}
 	for _, watch := range a.Watches {
 		watch.Run(ctx, a.Bus)
 	}
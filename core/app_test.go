@@ -26,7 +26,7 @@ func TestJobConfigRequiredFields(t *testing.T) {
 					{"name": "", "port": 8080, health: {interval: 30, "ttl": 19 }}]}`
 	f1 := testCfgToTempFile(t, testCfg)
 	defer os.Remove(f1.Name())
-	_, err := NewApp(f1.Name())
+	_, err := NewApp(f1.Name(), "")
 	assert.Error(t, err, "unable to parse jobs: 'name' must not be blank")
 
 	// Missing `interval`
@@ -34,7 +34,7 @@ func TestJobConfigRequiredFields(t *testing.T) {
 				{"name": "name", "port": 8080, health: {ttl: 19}}]}`
 	f2 := testCfgToTempFile(t, testCfg)
 	defer os.Remove(f2.Name())
-	_, err = NewApp(f2.Name())
+	_, err = NewApp(f2.Name(), "")
 	assert.Error(t, err, "unable to parse jobs: job[name].health.interval must be > 0")
 
 	// Missing `ttl`
@@ -42,7 +42,7 @@ func TestJobConfigRequiredFields(t *testing.T) {
 				{"name": "name", "port": 8080, health: {interval: 19}}]}`
 	f3 := testCfgToTempFile(t, testCfg)
 	defer os.Remove(f3.Name())
-	_, err = NewApp(f3.Name())
+	_, err = NewApp(f3.Name(), "")
 	assert.Error(t, err, "unable to parse jobs: job[name].health.ttl must be > 0")
 }
 
@@ -51,14 +51,14 @@ func TestWatchConfigRequiredFields(t *testing.T) {
 	var testCfg = `{"consul": "consul:8500", watches: [{"name": "", "interval": 30}]}`
 	f1 := testCfgToTempFile(t, testCfg)
 	defer os.Remove(f1.Name())
-	_, err := NewApp(f1.Name())
+	_, err := NewApp(f1.Name(), "")
 	assert.Error(t, err, "unable to parse watches: 'name' must not be blank")
 
 	// Missing `interval`
 	testCfg = `{"consul": "consul:8500", watches: [{"name": "name"}]}`
 	f2 := testCfgToTempFile(t, testCfg)
 	defer os.Remove(f2.Name())
-	_, err = NewApp(f2.Name())
+	_, err = NewApp(f2.Name(), "")
 	assert.Error(t, err, "unable to parse watches: watch[name].interval must be > 0")
 }
 
@@ -72,7 +72,7 @@ func TestMetricServiceCreation(t *testing.T) {
 	}
   }`)
 	defer os.Remove(f.Name())
-	app, err := NewApp(f.Name())
+	app, err := NewApp(f.Name(), "")
 	if err != nil {
 		t.Fatalf("got error while initializing config: %v", err)
 	}
@@ -146,9 +146,30 @@ func TestReloadConfig(t *testing.T) {
 	}
 }
 
+func TestCloseDiscovery(t *testing.T) {
+	disc := &closingDiscoveryBackend{}
+	app := EmptyApp()
+	app.Discovery = disc
+	app.closeDiscovery()
+	assert.Equal(t, 1, disc.closed, "expected discovery backend to be closed")
+
+	app.Discovery = &mocks.NoopDiscoveryBackend{}
+	app.closeDiscovery() // backends that don't need closing are skipped
+}
+
 // ----------------------------------------------------
 // test helpers
 
+type closingDiscoveryBackend struct {
+	mocks.NoopDiscoveryBackend
+	closed int
+}
+
+func (c *closingDiscoveryBackend) Close() error {
+	c.closed++
+	return nil
+}
+
 // write the configuration to a tempfile. caller is responsible
 // for calling 'defer os.Remove(f.Name())' when done
 func testCfgToTempFile(t *testing.T, text string) *os.File {
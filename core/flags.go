@@ -49,10 +49,16 @@ func GetArgs() (subcommands.Handler, subcommands.Params) {
 	var templateFlag bool
 	var reloadFlag bool
 	var pingFlag bool
+	var validateFlag bool
+	var schemaFlag bool
+	var convertFlag bool
 
 	var configPath string
+	var configFormat string
 	var renderFlag string
 	var maintFlag string
+	var maintReason string
+	var validateFormat string
 
 	var putMetricFlags MultiFlag
 	var putEnvFlags MultiFlag
@@ -64,20 +70,42 @@ func GetArgs() (subcommands.Handler, subcommands.Params) {
 		flag.BoolVar(&templateFlag, "template", false,
 			"Render template and quit.")
 
+		flag.BoolVar(&validateFlag, "validate", false,
+			`Validate the configuration file, print all errors found, and quit.
+	Exits non-zero if the configuration is invalid.`)
+
+		flag.StringVar(&validateFormat, "validate-format", "text",
+			`Output format for '-validate' errors: 'text' or 'json'.`)
+
+		flag.BoolVar(&convertFlag, "convert", false,
+			`Convert a ContainerPilot 2.x configuration file to the current format and quit.
+	Pass the 2.x application command after the flags. Writes to '-out'.`)
+
+		flag.BoolVar(&schemaFlag, "schema", false,
+			"Print the JSON Schema for the configuration file and quit.")
+
 		flag.BoolVar(&reloadFlag, "reload", false,
 			"Reload a ContainerPilot process through its control socket.")
 
 		flag.StringVar(&configPath, "config", "",
-			"File path to JSON5 configuration file. Defaults to CONTAINERPILOT env var.")
+			`File path to configuration file. Defaults to CONTAINERPILOT env var.
+	JSON5, YAML ('.yaml', '.yml'), and TOML ('.toml') files are supported.`)
+
+		flag.StringVar(&configFormat, "config-format", "",
+			`Format of the configuration file: 'json5', 'yaml', or 'toml'.
+	Defaults to the format implied by the file extension, or 'json5'.`)
 
 		flag.StringVar(&renderFlag, "out", "",
-			`File path where to save rendered config file when '-template' is used.
+			`File path where to save rendered config file when '-template' or '-convert' is used.
 	Defaults to stdout ('-').`)
 
 		flag.StringVar(&maintFlag, "maintenance", "",
 			`Toggle maintenance mode for a ContainerPilot process through its control socket.
 	Options: '-maintenance enable' or '-maintenance disable'`)
 
+		flag.StringVar(&maintReason, "maintenance-reason", "",
+			`Reason shown in the discovery backend when '-maintenance enable' is used.`)
+
 		flag.Var(&putMetricFlags, "putmetric",
 			`Update metrics of a ContainerPilot process through its control socket.
 	Pass metrics in the format: 'key=value'`)
@@ -98,43 +126,70 @@ func GetArgs() (subcommands.Handler, subcommands.Params) {
 			GitHash: version.GitHash,
 		}
 	}
+	if schemaFlag {
+		return subcommands.SchemaHandler, subcommands.Params{}
+	}
 	if configPath == "" {
 		configPath = os.Getenv("CONTAINERPILOT")
 	}
 	if templateFlag {
 		return subcommands.RenderHandler, subcommands.Params{
+			ConfigPath:   configPath,
+			ConfigFormat: configFormat,
+			RenderFlag:   renderFlag,
+		}
+	}
+	if convertFlag {
+		return subcommands.ConvertHandler, subcommands.Params{
 			ConfigPath: configPath,
 			RenderFlag: renderFlag,
+			Command:    flag.Args(),
+		}
+	}
+	if validateFlag {
+		return subcommands.ValidateHandler, subcommands.Params{
+			ConfigPath:     configPath,
+			ConfigFormat:   configFormat,
+			ValidateFormat: validateFormat,
 		}
 	}
 	if reloadFlag {
 		return subcommands.ReloadHandler, subcommands.Params{
-			ConfigPath: configPath,
+			ConfigPath:   configPath,
+			ConfigFormat: configFormat,
 		}
 	}
 	if maintFlag != "" {
 		return subcommands.MaintenanceHandler, subcommands.Params{
-			ConfigPath:      configPath,
-			MaintenanceFlag: maintFlag,
+			ConfigPath:        configPath,
+			ConfigFormat:      configFormat,
+			MaintenanceFlag:   maintFlag,
+			MaintenanceReason: maintReason,
 		}
 	}
 	if putEnvFlags.Len() != 0 {
 		return subcommands.PutEnvHandler, subcommands.Params{
-			ConfigPath: configPath,
-			Env:        putEnvFlags.Values,
+			ConfigPath:   configPath,
+			ConfigFormat: configFormat,
+			Env:          putEnvFlags.Values,
 		}
 	}
 	if putMetricFlags.Len() != 0 {
 		return subcommands.PutMetricsHandler, subcommands.Params{
-			ConfigPath: configPath,
-			Metrics:    putMetricFlags.Values,
+			ConfigPath:   configPath,
+			ConfigFormat: configFormat,
+			Metrics:      putMetricFlags.Values,
 		}
 	}
 	if pingFlag {
 		return subcommands.GetPingHandler, subcommands.Params{
-			ConfigPath: configPath,
+			ConfigPath:   configPath,
+			ConfigFormat: configFormat,
 		}
 	}
 
-	return nil, subcommands.Params{ConfigPath: configPath}
+	return nil, subcommands.Params{
+		ConfigPath:   configPath,
+		ConfigFormat: configFormat,
+	}
 }
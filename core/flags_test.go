@@ -13,7 +13,7 @@ func TestInvalidConfigNoConfigFlag(t *testing.T) {
 	defer argTestCleanup(argTestSetup())
 	os.Args = []string{"this", "/testdata/test.sh", "invalid1", "--debug"}
 	_, p := GetArgs()
-	if _, err := NewApp(p.ConfigPath); err != nil && err.Error() != "-config flag is required" {
+	if _, err := NewApp(p.ConfigPath, p.ConfigFormat); err != nil && err.Error() != "-config flag is required" {
 		t.Errorf("expected error but got %s", err)
 	}
 }
@@ -24,7 +24,7 @@ func TestInvalidConfigParseNoDiscovery(t *testing.T) {
 	defer os.Remove(f1.Name())
 	os.Args = []string{"this", "-config", f1.Name()}
 	_, p := GetArgs()
-	_, err := NewApp(p.ConfigPath)
+	_, err := NewApp(p.ConfigPath, p.ConfigFormat)
 	assert.Error(t, err, "no discovery backend defined")
 }
 
@@ -32,7 +32,7 @@ func TestInvalidConfigMissingFile(t *testing.T) {
 	defer argTestCleanup(argTestSetup())
 	os.Args = []string{"this", "-config", "/xxxx"}
 	_, p := GetArgs()
-	_, err := NewApp(p.ConfigPath)
+	_, err := NewApp(p.ConfigPath, p.ConfigFormat)
 	assert.Error(t, err,
 		"could not read config file: open /xxxx: no such file or directory")
 }
@@ -43,7 +43,7 @@ func TestInvalidConfigParseNotJson(t *testing.T) {
 	defer os.Remove(f1.Name())
 	os.Args = []string{"this", "-config", f1.Name()}
 	_, p := GetArgs()
-	_, err := NewApp(p.ConfigPath)
+	_, err := NewApp(p.ConfigPath, p.ConfigFormat)
 	assert.Error(t, fmt.Errorf("%s", err.Error()[:29]),
 		"parse error at line:col [1:1]")
 }
@@ -55,7 +55,7 @@ func TestInvalidConfigParseTemplateError(t *testing.T) {
 	defer os.Remove(f1.Name())
 	os.Args = []string{"this", "-config", f1.Name()}
 	_, p := GetArgs()
-	_, err := NewApp(p.ConfigPath)
+	_, err := NewApp(p.ConfigPath, p.ConfigFormat)
 	assert.Error(t, fmt.Errorf("%s", err.Error()[:30]),
 		"parse error at line:col [1:10]")
 }
@@ -63,7 +63,7 @@ func TestInvalidConfigParseTemplateError(t *testing.T) {
 func TestControlServerCreation(t *testing.T) {
 	f1 := testCfgToTempFile(t, `{"consul": "consul:8500"}`)
 	defer os.Remove(f1.Name())
-	app, err := NewApp(f1.Name())
+	app, err := NewApp(f1.Name(), "")
 	if err != nil {
 		t.Fatalf("got error while initializing config: %v", err)
 	}
@@ -76,12 +76,24 @@ func TestPidEnvVar(t *testing.T) {
 	defer argTestCleanup(argTestSetup())
 	os.Args = []string{"this", "-config", "{}", "/testdata/test.sh"}
 	_, p := GetArgs()
-	NewApp(p.ConfigPath)
+	NewApp(p.ConfigPath, p.ConfigFormat)
 	if pid := os.Getenv("CONTAINERPILOT_PID"); pid == "" {
 		t.Errorf("expected CONTAINERPILOT_PID to be set even on error")
 	}
 }
 
+func TestValidateFlag(t *testing.T) {
+	defer argTestCleanup(argTestSetup())
+	os.Args = []string{"this", "-config", "/etc/cp.yaml", "-validate",
+		"-validate-format", "json"}
+	handler, p := GetArgs()
+	if handler == nil {
+		t.Fatalf("expected validate subcommand")
+	}
+	assert.Equal(t, p.ConfigPath, "/etc/cp.yaml")
+	assert.Equal(t, p.ValidateFormat, "json")
+}
+
 func TestSetEqual(t *testing.T) {
 	defer argTestCleanup(argTestSetup())
 	os.Args = []string{"this", "-config", "{}", "-putenv", "ENV_VALUE=PART1=PART2"}
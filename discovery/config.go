@@ -1,6 +1,7 @@
 package discovery
 
 import (
+	"fmt"
 	"os"
 	"strings"
 
@@ -8,14 +9,17 @@ import (
 	"github.com/joyent/containerpilot/config/decode"
 )
 
-type parsedConfig struct {
+// ConsulConfig is the object form of the 'consul' configuration field;
+// it can also be provided as a single address string
+type ConsulConfig struct {
 	Address string          `mapstructure:"address"`
-	Scheme  string          `mapstructure:"scheme"`
+	Scheme  string          `mapstructure:"scheme" schema:"enum=http|https"`
 	Token   string          `mapstructure:"token"`
-	TLS     parsedTLSConfig `mapstructure:"tls"` // optional TLS settings
+	TLS     ConsulTLSConfig `mapstructure:"tls"` // optional TLS settings
 }
 
-type parsedTLSConfig struct {
+// ConsulTLSConfig is the optional TLS configuration for ConsulConfig
+type ConsulTLSConfig struct {
 	HTTPCAFile        string `mapstructure:"cafile"`
 	HTTPCAPath        string `mapstructure:"capath"`
 	HTTPClientCert    string `mapstructure:"clientcert"`
@@ -24,9 +28,9 @@ type parsedTLSConfig struct {
 	HTTPSSLVerify     bool   `mapstructure:"verify"`
 }
 
-// override an already-parsed parsedConfig with any options that might
+// override an already-parsed ConsulConfig with any options that might
 // be set in the environment and then return the TLSConfig
-func getTLSConfig(parsed *parsedConfig) api.TLSConfig {
+func getTLSConfig(parsed *ConsulConfig) api.TLSConfig {
 	if cafile := os.Getenv("CONSUL_CACERT"); cafile != "" {
 		parsed.TLS.HTTPCAFile = cafile
 	}
@@ -61,7 +65,7 @@ func getTLSConfig(parsed *parsedConfig) api.TLSConfig {
 }
 
 func configFromMap(raw map[string]interface{}) (*api.Config, error) {
-	parsed := &parsedConfig{}
+	parsed := &ConsulConfig{}
 	if err := decode.ToStruct(raw, parsed); err != nil {
 		return nil, err
 	}
@@ -76,7 +80,7 @@ func configFromMap(raw map[string]interface{}) (*api.Config, error) {
 
 func configFromURI(uri string) (*api.Config, error) {
 	address, scheme := parseRawURI(uri)
-	parsed := &parsedConfig{Address: address, Scheme: scheme}
+	parsed := &ConsulConfig{Address: address, Scheme: scheme}
 	config := &api.Config{
 		Address:   parsed.Address,
 		Scheme:    parsed.Scheme,
@@ -101,3 +105,105 @@ func parseRawURI(raw string) (string, string) {
 	}
 	return address, scheme
 }
+
+// EtcdConfig is the object form of the 'etcd' configuration field; it can
+// also be provided as a single endpoint string
+type EtcdConfig struct {
+	Endpoints []string `mapstructure:"endpoints"`
+	Prefix    string   `mapstructure:"prefix"`
+}
+
+const (
+	defaultEtcdEndpoint = "http://127.0.0.1:2379"
+	defaultEtcdPrefix   = "containerpilot/"
+)
+
+func etcdConfigFromMap(raw map[string]interface{}) (*EtcdConfig, error) {
+	parsed := &EtcdConfig{}
+	if err := decode.ToStruct(raw, parsed); err != nil {
+		return nil, err
+	}
+	return parsed, nil
+}
+
+// endpoints returns the URLs of the etcd JSON gateway; we accept bare
+// addresses w/o a scheme
+func (cfg *EtcdConfig) endpoints() ([]string, error) {
+	if len(cfg.Endpoints) == 0 {
+		return []string{defaultEtcdEndpoint}, nil
+	}
+	endpoints := make([]string, len(cfg.Endpoints))
+	for i, endpoint := range cfg.Endpoints {
+		if endpoint == "" {
+			return nil, fmt.Errorf("endpoints[%d] is empty", i)
+		}
+		address, scheme := parseRawURI(endpoint)
+		endpoints[i] = scheme + "://" + strings.TrimSuffix(address, "/")
+	}
+	return endpoints, nil
+}
+
+// prefix returns the prefix of the keys for registered services, which
+// always ends with a "/"
+func (cfg *EtcdConfig) prefix() string {
+	if cfg.Prefix == "" {
+		return defaultEtcdPrefix
+	}
+	return strings.TrimSuffix(cfg.Prefix, "/") + "/"
+}
+
+// Config is the 'discovery' configuration field, which selects the service
+// discovery backend. Exactly one backend must be configured.
+type Config struct {
+	Consul interface{} `mapstructure:"consul"`
+	Etcd   interface{} `mapstructure:"etcd"`
+	File   string      `mapstructure:"file"`
+	Plugin interface{} `mapstructure:"plugin"`
+}
+
+// NewBackend creates the service discovery backend selected by the
+// 'discovery' configuration field
+func NewBackend(raw interface{}) (Backend, error) {
+	cfg := &Config{}
+	if err := decode.ToStruct(raw, cfg); err != nil {
+		return nil, err
+	}
+	count := 0
+	for _, set := range []bool{cfg.Consul != nil, cfg.Etcd != nil, cfg.File != "",
+		cfg.Plugin != nil} {
+		if set {
+			count++
+		}
+	}
+	if count > 1 {
+		return nil, fmt.Errorf("only one discovery backend can be configured")
+	}
+	// we don't want to return a typed nil in the Backend interface
+	switch {
+	case cfg.Consul != nil:
+		consul, err := NewConsul(cfg.Consul)
+		if err != nil {
+			return nil, err
+		}
+		return consul, nil
+	case cfg.Etcd != nil:
+		etcd, err := NewEtcd(cfg.Etcd)
+		if err != nil {
+			return nil, err
+		}
+		return etcd, nil
+	case cfg.File != "":
+		file, err := NewFile(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		return file, nil
+	case cfg.Plugin != nil:
+		plugin, err := NewPlugin(cfg.Plugin)
+		if err != nil {
+			return nil, err
+		}
+		return plugin, nil
+	}
+	return nil, fmt.Errorf("no discovery backend defined")
+}
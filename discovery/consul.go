@@ -1,10 +1,14 @@
 package discovery
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/hashicorp/consul/api"
 	"github.com/prometheus/client_golang/prometheus"
@@ -26,7 +30,8 @@ func init() {
 type Consul struct {
 	api.Client
 	lock            sync.RWMutex
-	watchedServices map[string][]*api.ServiceEntry
+	watchedServices map[string][]*consulServiceEntry
+	watchedKeys     map[string][]*KVPair
 }
 
 // NewConsul creates a new service discovery backend for Consul
@@ -52,8 +57,9 @@ func NewConsul(config interface{}) (*Consul, error) {
 	if err != nil {
 		return nil, err
 	}
-	watchedServices := make(map[string][]*api.ServiceEntry)
-	consul := &Consul{*client, sync.RWMutex{}, watchedServices}
+	watchedServices := make(map[string][]*consulServiceEntry)
+	watchedKeys := make(map[string][]*KVPair)
+	consul := &Consul{*client, sync.RWMutex{}, watchedServices, watchedKeys}
 	return consul, nil
 }
 
@@ -64,15 +70,70 @@ func (c *Consul) UpdateTTL(checkID, output, status string) error {
 }
 
 // CheckRegister wraps the Consul.Agent's CheckRegister method,
-// is used to register a new service with the local agent
-func (c *Consul) CheckRegister(check *api.AgentCheckRegistration) error {
-	return c.Agent().CheckRegister(check)
+// is used to register a new check with the local agent
+func (c *Consul) CheckRegister(check *CheckRegistration) error {
+	return c.Agent().CheckRegister(&api.AgentCheckRegistration{
+		ID:                check.ID,
+		Name:              check.Name,
+		Notes:             check.Notes,
+		ServiceID:         check.ServiceID,
+		AgentServiceCheck: *consulCheck(&check.ServiceCheck),
+	})
 }
 
 // ServiceRegister wraps the Consul.Agent's ServiceRegister method,
 // is used to register a new service with the local agent
-func (c *Consul) ServiceRegister(service *api.AgentServiceRegistration) error {
-	return c.Agent().ServiceRegister(service)
+func (c *Consul) ServiceRegister(service *ServiceRegistration) error {
+	reg := &api.AgentServiceRegistration{
+		ID:                service.ID,
+		Name:              service.Name,
+		Tags:              service.Tags,
+		Port:              service.Port,
+		Address:           service.Address,
+		EnableTagOverride: service.EnableTagOverride,
+	}
+	if service.Check != nil {
+		reg.Check = consulCheck(service.Check)
+	}
+	if len(service.Meta) == 0 && service.Weights == nil {
+		return c.Agent().ServiceRegister(reg)
+	}
+	// the vendored API client doesn't know about the Meta and Weights
+	// fields, so we send them to the agent ourselves
+	body := &consulServiceRegistration{
+		AgentServiceRegistration: reg,
+		Meta:                     service.Meta,
+	}
+	if service.Weights != nil {
+		body.Weights = &consulWeights{
+			Passing: service.Weights.Passing,
+			Warning: service.Weights.Warning,
+		}
+	}
+	_, err := c.Raw().Write("/v1/agent/service/register", body, nil, nil)
+	return err
+}
+
+// consulServiceRegistration is an api.AgentServiceRegistration along with
+// the fields added in newer versions of Consul
+type consulServiceRegistration struct {
+	*api.AgentServiceRegistration
+	Meta    map[string]string `json:",omitempty"`
+	Weights *consulWeights    `json:",omitempty"`
+}
+
+type consulWeights struct {
+	Passing int
+	Warning int
+}
+
+func consulCheck(check *ServiceCheck) *api.AgentServiceCheck {
+	return &api.AgentServiceCheck{
+		TTL:                            check.TTL,
+		Status:                         check.Status,
+		Notes:                          check.Notes,
+		DeregisterCriticalServiceAfter: check.DeregisterCriticalServiceAfter,
+	}
 }
 
 // ServiceDeregister wraps the Consul.Agent's ServiceDeregister method,
@@ -81,12 +142,39 @@ func (c *Consul) ServiceDeregister(serviceID string) error {
 	return c.Agent().ServiceDeregister(serviceID)
 }
 
+// RegisteredServices returns the IDs of the services registered with the
+// local agent, sorted
+func (c *Consul) RegisteredServices() ([]string, error) {
+	services, err := c.Agent().Services()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(services))
+	for id := range services {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// EnableServiceMaintenance wraps the Consul.Agent's EnableServiceMaintenance
+// method, which adds a critical check with the reason to the service
+func (c *Consul) EnableServiceMaintenance(serviceID, reason string) error {
+	return c.Agent().EnableServiceMaintenance(serviceID, reason)
+}
+
+// DisableServiceMaintenance wraps the Consul.Agent's
+// DisableServiceMaintenance method
+func (c *Consul) DisableServiceMaintenance(serviceID string) error {
+	return c.Agent().DisableServiceMaintenance(serviceID)
+}
+
 // CheckForUpstreamChanges requests the set of healthy instances of a
 // service from Consul and checks whether there has been a change since
 // the last check.
 func (c *Consul) CheckForUpstreamChanges(backendName, backendTag, dc string) (didChange, isHealthy bool) {
 	opts := &api.QueryOptions{Datacenter: dc}
-	instances, meta, err := c.Health().Service(backendName, backendTag, true, opts)
+	instances, meta, err := c.healthyServices(backendName, backendTag, opts)
 	if err != nil {
 		log.Warnf("failed to query %v: %s [%v]", backendName, err, meta)
 		return false, false
@@ -97,9 +185,196 @@ func (c *Consul) CheckForUpstreamChanges(backendName, backendTag, dc string) (di
 	return didChange, isHealthy
 }
 
+// WaitForUpstreamChanges makes a blocking query for the set of healthy
+// instances of a service, which returns when the service has changed since
+// waitIndex or after waitTime, and checks whether there has been a change
+// since the last check. Returns the index to wait on for the next query.
+func (c *Consul) WaitForUpstreamChanges(ctx context.Context, backendName, backendTag, dc string,
+	waitIndex uint64, waitTime time.Duration) (didChange, isHealthy bool, lastIndex uint64, err error) {
+	opts := &api.QueryOptions{
+		Datacenter: dc,
+		WaitIndex:  waitIndex,
+		WaitTime:   waitTime,
+	}
+	instances, meta, err := c.healthyServices(backendName, backendTag,
+		opts.WithContext(ctx))
+	if err != nil {
+		return false, false, waitIndex, err
+	}
+	collector.WithLabelValues(backendName).Set(float64(len(instances)))
+	isHealthy = len(instances) > 0
+	didChange = c.compareAndSwap(backendName, instances)
+	return didChange, isHealthy, meta.LastIndex, nil
+}
+
+// healthyServices is Health().Service for the passing instances of the
+// service, but the vendored API client doesn't decode the service's
+// metadata so we make the query ourselves. Consul filters by tag and
+// health status with query parameters that Raw can't send, so we filter
+// the instances here instead.
+func (c *Consul) healthyServices(service, tag string, opts *api.QueryOptions) ([]*consulServiceEntry, *api.QueryMeta, error) {
+	var entries []*consulServiceEntry
+	meta, err := c.Raw().Query("/v1/health/service/"+service, &entries, opts)
+	if err != nil {
+		return nil, meta, err
+	}
+	healthy := []*consulServiceEntry{}
+	for _, entry := range entries {
+		if entry.ServiceEntry == nil || entry.Service == nil {
+			continue
+		}
+		if tag != "" && !consulHasTag(entry.Service.Tags, tag) {
+			continue
+		}
+		if !consulIsPassing(entry.Checks) {
+			continue
+		}
+		healthy = append(healthy, entry)
+	}
+	return healthy, meta, nil
+}
+
+// consulIsPassing returns true if all of the checks are passing
+func consulIsPassing(checks api.HealthChecks) bool {
+	for _, check := range checks {
+		if check.Status != api.HealthPassing {
+			return false
+		}
+	}
+	return true
+}
+
+// consulHasTag matches tags without regard to case, as Consul does
+func consulHasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// consulServiceEntry is an api.ServiceEntry along with the service's
+// metadata, which the vendored API client doesn't know about
+type consulServiceEntry struct {
+	*api.ServiceEntry
+	Meta map[string]string
+}
+
+// UnmarshalJSON decodes the entry and the service's metadata
+func (entry *consulServiceEntry) UnmarshalJSON(data []byte) error {
+	serviceEntry := &api.ServiceEntry{}
+	if err := json.Unmarshal(data, serviceEntry); err != nil {
+		return err
+	}
+	var service struct {
+		Service *struct {
+			Meta map[string]string
+		}
+	}
+	if err := json.Unmarshal(data, &service); err != nil {
+		return err
+	}
+	entry.ServiceEntry = serviceEntry
+	if service.Service != nil {
+		entry.Meta = service.Service.Meta
+	}
+	return nil
+}
+
+// ServiceInstances returns the healthy instances of a service found on
+// the last call to CheckForUpstreamChanges for that service, sorted by ID
+func (c *Consul) ServiceInstances(service string) []*ServiceInstance {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	entries := c.watchedServices[service]
+	instances := make([]*ServiceInstance, 0, len(entries))
+	for _, entry := range entries {
+		if entry.ServiceEntry == nil || entry.Service == nil {
+			continue
+		}
+		instance := &ServiceInstance{
+			ID:      entry.Service.ID,
+			Name:    entry.Service.Service,
+			Address: entry.Service.Address,
+			Port:    entry.Service.Port,
+			Tags:    entry.Service.Tags,
+			Meta:    entry.Meta,
+		}
+		if entry.Node != nil {
+			instance.Node = entry.Node.Node
+			instance.NodeMeta = entry.Node.Meta
+			if instance.Address == "" {
+				// Consul uses the node address if the service
+				// was registered without one
+				instance.Address = entry.Node.Address
+			}
+		}
+		for _, check := range entry.Checks {
+			if instance.Checks == nil {
+				instance.Checks = map[string]string{}
+			}
+			instance.Checks[check.CheckID] = check.Status
+		}
+		instances = append(instances, instance)
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].ID < instances[j].ID
+	})
+	return instances
+}
+
+// CheckForKVChanges requests a key, or all the keys under a prefix if
+// recurse is set, from the Consul KV store and checks whether any keys
+// have been added, removed, or modified since the last check.
+func (c *Consul) CheckForKVChanges(key string, recurse bool, dc string) (bool, error) {
+	opts := &api.QueryOptions{Datacenter: dc}
+	var entries api.KVPairs
+	if recurse {
+		list, _, err := c.KV().List(key, opts)
+		if err != nil {
+			return false, err
+		}
+		entries = list
+	} else {
+		entry, _, err := c.KV().Get(key, opts)
+		if err != nil {
+			return false, err
+		}
+		if entry != nil {
+			entries = api.KVPairs{entry}
+		}
+	}
+	pairs := make([]*KVPair, 0, len(entries))
+	for _, entry := range entries {
+		pairs = append(pairs, &KVPair{
+			Key:         entry.Key,
+			Value:       string(entry.Value),
+			ModifyIndex: entry.ModifyIndex,
+		})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Key < pairs[j].Key
+	})
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	existing := c.watchedKeys[key]
+	c.watchedKeys[key] = pairs
+	return compareKVPairs(existing, pairs), nil
+}
+
+// KVPairs returns the keys found on the last call to CheckForKVChanges
+// for the key or prefix, sorted by key
+func (c *Consul) KVPairs(key string) []*KVPair {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.watchedKeys[key]
+}
+
 // returns true if any addresses for the service changed and updates
 // the internal state
-func (c *Consul) compareAndSwap(service string, new []*api.ServiceEntry) bool {
+func (c *Consul) compareAndSwap(service string, new []*consulServiceEntry) bool {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 	existing := c.watchedServices[service]
@@ -109,7 +384,7 @@ func (c *Consul) compareAndSwap(service string, new []*api.ServiceEntry) bool {
 
 // Compare the two arrays to see if the address or port has changed
 // or if we've added or removed entries.
-func compareForChange(existing, newEntries []*api.ServiceEntry) (changed bool) {
+func compareForChange(existing, newEntries []*consulServiceEntry) (changed bool) {
 	if len(existing) != len(newEntries) {
 		return true
 	}
@@ -125,7 +400,7 @@ func compareForChange(existing, newEntries []*api.ServiceEntry) (changed bool) {
 }
 
 // ByServiceID implements the Sort interface because Go can't sort without it.
-type ByServiceID []*api.ServiceEntry
+type ByServiceID []*consulServiceEntry
 
 func (se ByServiceID) Len() int           { return len(se) }
 func (se ByServiceID) Swap(i, j int)      { se[i], se[j] = se[j], se[i] }
@@ -1,8 +1,13 @@
 package discovery
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	consul "github.com/hashicorp/consul/api"
 	"github.com/stretchr/testify/assert"
@@ -47,13 +52,15 @@ func runParseTest(t *testing.T, uri, expectedAddress, expectedScheme string) {
 func TestCheckForChanges(t *testing.T) {
 	c, _ := NewConsul(`consul: "localhost:8500"`)
 
-	t0 := []*consul.ServiceEntry{}
+	t0 := []*consulServiceEntry{}
 	didChange := c.compareAndSwap("test", t0)
 	assert.False(t, didChange, "value for 'didChange' after t0")
 
-	t1 := []*consul.ServiceEntry{
-		{Service: &consul.AgentService{Address: "1.2.3.4", Port: 80}},
-		{Service: &consul.AgentService{Address: "1.2.3.5", Port: 80}},
+	t1 := []*consulServiceEntry{
+		{ServiceEntry: &consul.ServiceEntry{
+			Service: &consul.AgentService{Address: "1.2.3.4", Port: 80}}},
+		{ServiceEntry: &consul.ServiceEntry{
+			Service: &consul.AgentService{Address: "1.2.3.5", Port: 80}}},
 	}
 	didChange = c.compareAndSwap("test", t1)
 	assert.True(t, didChange, "value for 'didChange' after t1")
@@ -64,12 +71,168 @@ func TestCheckForChanges(t *testing.T) {
 	didChange = c.compareAndSwap("test", t1)
 	assert.True(t, didChange, "value for 'didChange' after t1 (again)")
 
-	t3 := []*consul.ServiceEntry{
-		{Service: &consul.AgentService{Address: "1.2.3.4", Port: 80}}}
+	t3 := []*consulServiceEntry{
+		{ServiceEntry: &consul.ServiceEntry{
+			Service: &consul.AgentService{Address: "1.2.3.4", Port: 80}}}}
 	didChange = c.compareAndSwap("test", t3)
 	assert.True(t, didChange, "value for 'didChange' after t3")
 }
 
+func TestServiceInstances(t *testing.T) {
+	c, _ := NewConsul(`consul: "localhost:8500"`)
+	assert.Equal(t, []*ServiceInstance{}, c.ServiceInstances("test"))
+
+	c.compareAndSwap("test", []*consulServiceEntry{
+		{
+			ServiceEntry: &consul.ServiceEntry{
+				Node: &consul.Node{Node: "node2", Address: "10.0.0.2",
+					Meta: map[string]string{"rack": "a"}},
+				Service: &consul.AgentService{ID: "test-2", Service: "test",
+					Port: 80, Tags: []string{"prod"}},
+				Checks: consul.HealthChecks{
+					{CheckID: "serfHealth", Status: "passing"},
+					{CheckID: "service:test-2", Status: "passing"},
+				},
+			},
+			Meta: map[string]string{"version": "1.2.3"},
+		},
+		{
+			ServiceEntry: &consul.ServiceEntry{
+				Node: &consul.Node{Node: "node1", Address: "10.0.0.1"},
+				Service: &consul.AgentService{ID: "test-1", Service: "test",
+					Address: "1.2.3.4", Port: 80},
+			},
+		},
+	})
+	assert.Equal(t, []*ServiceInstance{
+		{ID: "test-1", Name: "test", Address: "1.2.3.4", Port: 80, Node: "node1"},
+		{ID: "test-2", Name: "test", Address: "10.0.0.2", Port: 80,
+			Tags: []string{"prod"}, Meta: map[string]string{"version": "1.2.3"},
+			Node:     "node2",
+			NodeMeta: map[string]string{"rack": "a"},
+			Checks: map[string]string{
+				"serfHealth":     "passing",
+				"service:test-2": "passing",
+			}},
+	}, c.ServiceInstances("test"))
+}
+
+func TestServiceRegisterMeta(t *testing.T) {
+	var path string
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			path = r.URL.Path
+			json.NewDecoder(r.Body).Decode(&body)
+		}))
+	defer server.Close()
+
+	c, _ := NewConsul(server.URL)
+	err := c.ServiceRegister(&ServiceRegistration{
+		ID: "test-1", Name: "test", Port: 80,
+		Meta:    map[string]string{"version": "1.2.3"},
+		Weights: &ServiceWeights{Passing: 10, Warning: 1},
+		Check:   &ServiceCheck{TTL: "10s"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "/v1/agent/service/register", path)
+	assert.Equal(t, "test-1", body["ID"])
+	assert.Equal(t, map[string]interface{}{"version": "1.2.3"}, body["Meta"])
+	assert.Equal(t, map[string]interface{}{"Passing": 10.0, "Warning": 1.0}, body["Weights"])
+	assert.Equal(t, "10s", body["Check"].(map[string]interface{})["TTL"])
+}
+
+func TestCheckForUpstreamChangesMeta(t *testing.T) {
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			path = r.URL.Path
+			fmt.Fprint(w, `[
+				{"Service": {"ID": "test-1", "Address": "1.2.3.4", "Port": 80,
+					"Tags": ["Prod"], "Meta": {"version": "1.2.3"}},
+				 "Checks": [{"CheckID": "serfHealth", "Status": "passing"}]},
+				{"Service": {"ID": "test-2", "Address": "1.2.3.5", "Port": 80,
+					"Tags": ["prod"]},
+				 "Checks": [{"CheckID": "serfHealth", "Status": "warning"}]},
+				{"Service": {"ID": "test-3", "Address": "1.2.3.6", "Port": 80,
+					"Tags": ["dev"]}}]`)
+		}))
+	defer server.Close()
+
+	c, _ := NewConsul(server.URL)
+	didChange, isHealthy := c.CheckForUpstreamChanges("test", "prod", "")
+	assert.True(t, didChange, "value for 'didChange'")
+	assert.True(t, isHealthy, "value for 'isHealthy'")
+	assert.Equal(t, "/v1/health/service/test", path)
+	assert.Equal(t, []*ServiceInstance{
+		{ID: "test-1", Address: "1.2.3.4", Port: 80, Tags: []string{"Prod"},
+			Meta:   map[string]string{"version": "1.2.3"},
+			Checks: map[string]string{"serfHealth": "passing"}},
+	}, c.ServiceInstances("test"))
+}
+
+func TestWaitForUpstreamChanges(t *testing.T) {
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			query = r.URL.RawQuery
+			w.Header().Set("X-Consul-Index", "42")
+			fmt.Fprint(w, `[{"Service": {"ID": "test-1", "Address": "1.2.3.4", "Port": 80}}]`)
+		}))
+	defer server.Close()
+
+	c, _ := NewConsul(server.URL)
+	didChange, isHealthy, index, err := c.WaitForUpstreamChanges(
+		context.Background(), "test", "", "", 7, time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, didChange, "value for 'didChange'")
+	assert.True(t, isHealthy, "value for 'isHealthy'")
+	assert.Equal(t, uint64(42), index)
+	assert.Contains(t, query, "index=7")
+	assert.Contains(t, query, "wait=60000ms")
+
+	didChange, _, _, _ = c.WaitForUpstreamChanges(
+		context.Background(), "test", "", "", 42, time.Minute)
+	assert.False(t, didChange, "value for 'didChange' after no change")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, index, err = c.WaitForUpstreamChanges(ctx, "test", "", "", 42, time.Minute)
+	assert.NotNil(t, err, "expected error for canceled query")
+	assert.Equal(t, uint64(42), index)
+}
+
+func TestCheckForKVChanges(t *testing.T) {
+	var path, query string
+	modifyIndex := 10
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			path, query = r.URL.Path, r.URL.RawQuery
+			// values are base64 encoded: "on" and "off"
+			fmt.Fprintf(w, `[{"Key": "flags/b", "Value": "b2Zm", "ModifyIndex": 3},
+				{"Key": "flags/a", "Value": "b24=", "ModifyIndex": %d}]`, modifyIndex)
+		}))
+	defer server.Close()
+
+	c, _ := NewConsul(server.URL)
+	didChange, err := c.CheckForKVChanges("flags/", true, "")
+	assert.Nil(t, err)
+	assert.True(t, didChange, "value for 'didChange'")
+	assert.Equal(t, "/v1/kv/flags/", path)
+	assert.Contains(t, query, "recurse")
+	assert.Equal(t, []*KVPair{
+		{Key: "flags/a", Value: "on", ModifyIndex: 10},
+		{Key: "flags/b", Value: "off", ModifyIndex: 3},
+	}, c.KVPairs("flags/"))
+
+	didChange, _ = c.CheckForKVChanges("flags/", true, "")
+	assert.False(t, didChange, "value for 'didChange' after no change")
+
+	modifyIndex = 11
+	didChange, _ = c.CheckForKVChanges("flags/", true, "")
+	assert.True(t, didChange, "value for 'didChange' after modify")
+}
+
 func TestWithConsul(t *testing.T) {
 	testServer, err := NewTestServer(8500)
 	if err != nil {
@@ -182,7 +345,7 @@ func testConsulEnableTagOverride(testServer *TestServer) func(*testing.T) {
 			TTL:               1,
 			Port:              9000,
 			EnableTagOverride: true,
-			Consul:            consul,
+			Discovery:         consul,
 		}
 		id := service.ID
 		if changed, _ := consul.CheckForUpstreamChanges(backend, "", ""); changed {
@@ -210,6 +373,6 @@ func generateServiceDefinition(serviceName string, consul *Consul) *ServiceDefin
 		InitialStatus: "warning",
 		TTL:       5,
 		Port:      9000,
-		Consul:    consul,
+		Discovery: consul,
 	}
 }
@@ -1,14 +1,231 @@
-// Package discovery manages the configuration of the Consul clients and
-// the functions used to update/query Consul with service discovery data.
+// Package discovery manages the configuration of the service discovery
+// backends, Consul and etcd, and the functions used to update/query them
+// with service discovery data.
 package discovery
 
-import "github.com/hashicorp/consul/api"
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
 
 // Backend is an interface which all service discovery backends must implement
 type Backend interface {
 	CheckForUpstreamChanges(service, tag, dc string) (bool, bool)
-	CheckRegister(check *api.AgentCheckRegistration) error
+	WaitForUpstreamChanges(ctx context.Context, service, tag, dc string,
+		waitIndex uint64, waitTime time.Duration) (bool, bool, uint64, error)
+	CheckRegister(check *CheckRegistration) error
 	UpdateTTL(checkID, output, status string) error
 	ServiceDeregister(serviceID string) error
-	ServiceRegister(service *api.AgentServiceRegistration) error
+	ServiceRegister(service *ServiceRegistration) error
+	RegisteredServices() ([]string, error)
+	EnableServiceMaintenance(serviceID, reason string) error
+	DisableServiceMaintenance(serviceID string) error
+	ServiceInstances(service string) []*ServiceInstance
+	CheckForKVChanges(key string, recurse bool, dc string) (bool, error)
+	KVPairs(key string) []*KVPair
+}
+
+// The statuses of health checks. UpdateTTL also accepts the short forms
+// "pass", "warn", and "fail".
+const (
+	HealthPassing  = "passing"
+	HealthWarning  = "warning"
+	HealthCritical = "critical"
+)
+
+// ServiceRegistration is a service for a backend to register, along with
+// the TTL check that the service's heartbeats update
+type ServiceRegistration struct {
+	ID                string
+	Name              string
+	Tags              []string
+	Port              int
+	Address           string
+	EnableTagOverride bool
+	Meta              map[string]string
+	Weights           *ServiceWeights
+	Check             *ServiceCheck
+}
+
+// ServiceWeights are the relative weights of a service instance in DNS SRV
+// responses while its checks are passing or warning
+type ServiceWeights struct {
+	Passing int `json:"passing"`
+	Warning int `json:"warning"`
+}
+
+// ServiceCheck is a TTL check. The TTL and DeregisterCriticalServiceAfter
+// are durations such as "10s".
+type ServiceCheck struct {
+	TTL                            string
+	Status                         string
+	Notes                          string
+	DeregisterCriticalServiceAfter string
+}
+
+// CheckRegistration is an additional TTL check for a registered service
+type CheckRegistration struct {
+	ID        string
+	Name      string
+	Notes     string
+	ServiceID string
+	ServiceCheck
+}
+
+// ServiceInstance is a healthy instance of a watched service, as found
+// on the most recent check for upstream changes
+type ServiceInstance struct {
+	ID       string
+	Name     string
+	Address  string
+	Port     int
+	Tags     []string
+	Meta     map[string]string
+	Node     string
+	NodeMeta map[string]string
+	Checks   map[string]string // check ID to status
+}
+
+// KVPair is a key and its value in the key/value store, as found on the
+// most recent check for changes to a watched key or prefix
+type KVPair struct {
+	Key         string
+	Value       string
+	ModifyIndex uint64
+}
+
+// maintenanceCheckID is the ID of the critical check that puts a service
+// into maintenance mode, which matches the ID that Consul gives it
+func maintenanceCheckID(serviceID string) string {
+	return "_service_maintenance:" + serviceID
+}
+
+// the reason given for maintenance mode if none is provided
+const defaultMaintenanceReason = "Maintenance mode is enabled for this service"
+
+// serviceCheckID is the ID of the TTL check registered with a service,
+// which matches the ID that Consul gives it
+func serviceCheckID(serviceID string) string {
+	return "service:" + serviceID
+}
+
+// normalizeStatus converts the short forms of the health check statuses
+// accepted by UpdateTTL
+func normalizeStatus(status string) string {
+	switch status {
+	case "pass":
+		return HealthPassing
+	case "warn":
+		return HealthWarning
+	case "fail":
+		return HealthCritical
+	}
+	return status
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// instanceCache holds the healthy instances and the keys found on the most
+// recent checks, for the backends to embed. Consul keeps its own entries
+// because it needs their full health checks.
+type instanceCache struct {
+	lock            sync.RWMutex
+	watchedServices map[string][]*ServiceInstance
+	watchedKeys     map[string][]*KVPair
+}
+
+// ServiceInstances returns the healthy instances of a service found on
+// the last call to CheckForUpstreamChanges for that service, sorted by ID
+func (c *instanceCache) ServiceInstances(service string) []*ServiceInstance {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	instances := c.watchedServices[service]
+	if instances == nil {
+		return []*ServiceInstance{}
+	}
+	return instances
+}
+
+// KVPairs returns the keys found on the last call to CheckForKVChanges
+// for the key or prefix, sorted by key
+func (c *instanceCache) KVPairs(key string) []*KVPair {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.watchedKeys[key]
+}
+
+// swapInstances sorts and caches the healthy instances of a service, and
+// returns whether they changed since the last check and whether there
+// are any
+func (c *instanceCache) swapInstances(service string, instances []*ServiceInstance) (didChange, isHealthy bool) {
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].ID < instances[j].ID
+	})
+	collector.WithLabelValues(service).Set(float64(len(instances)))
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.watchedServices == nil {
+		c.watchedServices = make(map[string][]*ServiceInstance)
+	}
+	existing := c.watchedServices[service]
+	c.watchedServices[service] = instances
+	return compareInstances(existing, instances), len(instances) > 0
+}
+
+// swapKVPairs sorts and caches the keys found for a key or prefix, and
+// returns whether they changed since the last check
+func (c *instanceCache) swapKVPairs(key string, pairs []*KVPair) bool {
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Key < pairs[j].Key
+	})
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.watchedKeys == nil {
+		c.watchedKeys = make(map[string][]*KVPair)
+	}
+	existing := c.watchedKeys[key]
+	c.watchedKeys[key] = pairs
+	return compareKVPairs(existing, pairs)
+}
+
+// compareInstances returns true if instances were added or removed, or if
+// the address or port of any instance changed. Both slices must be sorted
+// by ID.
+func compareInstances(existing, instances []*ServiceInstance) bool {
+	if len(existing) != len(instances) {
+		return true
+	}
+	for i, ex := range existing {
+		if ex.ID != instances[i].ID || ex.Address != instances[i].Address ||
+			ex.Port != instances[i].Port {
+			return true
+		}
+	}
+	return false
+}
+
+// compareKVPairs returns true if keys were added or removed, or if any
+// key was modified. Both slices must be sorted by key.
+func compareKVPairs(existing, pairs []*KVPair) bool {
+	if len(existing) != len(pairs) {
+		return true
+	}
+	for i, ex := range existing {
+		if ex.Key != pairs[i].Key || ex.ModifyIndex != pairs[i].ModifyIndex ||
+			ex.Value != pairs[i].Value {
+			return true
+		}
+	}
+	return false
 }
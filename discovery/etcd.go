@@ -0,0 +1,637 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// the TTL of a service's lease if it was registered without a TTL check
+const defaultEtcdTTL = 30 * time.Second
+
+// how long we wait for an endpoint to answer before we try the next one
+const etcdRequestTimeout = 10 * time.Second
+
+// Etcd is the service discovery backend for etcd v3, which it reaches
+// through the etcd JSON gateway. Services are registered as keys under
+// <prefix>services/<name>/<id> which are attached to a lease, and their
+// heartbeats keep the lease alive, so that a service whose heartbeats
+// stop is removed when its lease expires.
+type Etcd struct {
+	endpoints []string
+	prefix    string
+	client    *http.Client
+	timeout   time.Duration
+
+	instanceCache
+
+	// regLock only guards the maps; each registration has its own lock,
+	// which is held across its requests to etcd
+	regLock       sync.Mutex
+	registrations map[string]*etcdRegistration // by service ID
+	checks        map[string]string            // check ID to service ID
+}
+
+// etcdRegistration is a service registered by this ContainerPilot and the
+// lease that keeps it alive
+type etcdRegistration struct {
+	lock    sync.Mutex
+	service *ServiceRegistration
+	ttl     int64
+	lease   int64
+	checks  map[string]string // check ID to status
+	removed bool              // deregistered or registered again
+
+	// the reason the service is in maintenance, if it is
+	maintenance string
+}
+
+// etcdService is the value of a service's key
+type etcdService struct {
+	ID      string            `json:"id"`
+	Name    string            `json:"name"`
+	Address string            `json:"address"`
+	Port    int               `json:"port"`
+	Tags    []string          `json:"tags,omitempty"`
+	Node    string            `json:"node,omitempty"`
+	Checks  map[string]string `json:"checks"`
+	Meta    map[string]string `json:"meta,omitempty"`
+	Weights *ServiceWeights   `json:"weights,omitempty"`
+
+	Maintenance string `json:"maintenance,omitempty"` // the reason
+}
+
+// NewEtcd creates a new service discovery backend for etcd
+func NewEtcd(config interface{}) (*Etcd, error) {
+	var parsed *EtcdConfig
+	var err error
+	switch t := config.(type) {
+	case string:
+		parsed = &EtcdConfig{Endpoints: []string{t}}
+	case map[string]interface{}:
+		parsed, err = etcdConfigFromMap(t)
+	default:
+		return nil, fmt.Errorf("no discovery backend defined")
+	}
+	if err != nil {
+		return nil, err
+	}
+	endpoints, err := parsed.endpoints()
+	if err != nil {
+		return nil, err
+	}
+	etcd := &Etcd{
+		endpoints:     endpoints,
+		prefix:        parsed.prefix(),
+		client:        newEtcdClient(etcdRequestTimeout),
+		timeout:       etcdRequestTimeout,
+		registrations: make(map[string]*etcdRegistration),
+		checks:        make(map[string]string),
+	}
+	return etcd, nil
+}
+
+// newEtcdClient creates an HTTP client that gives up on an endpoint that
+// doesn't connect or send its response headers within the timeout. We
+// can't set a timeout for the whole request because watches stream their
+// response.
+func newEtcdClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   timeout,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			ResponseHeaderTimeout: timeout,
+			IdleConnTimeout:       90 * time.Second,
+		},
+	}
+}
+
+// ServiceRegister grants a lease with the TTL of the service's check and
+// puts the service's key with that lease
+func (e *Etcd) ServiceRegister(service *ServiceRegistration) error {
+	ttl := defaultEtcdTTL
+	status := HealthCritical
+	if service.Check != nil {
+		if service.Check.TTL != "" {
+			parsed, err := time.ParseDuration(service.Check.TTL)
+			if err != nil {
+				return fmt.Errorf("invalid check TTL: %v", err)
+			}
+			ttl = parsed
+		}
+		if service.Check.Status != "" {
+			status = normalizeStatus(service.Check.Status)
+		}
+	}
+	seconds := int64(ttl / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	reg := &etcdRegistration{
+		service: service,
+		ttl:     seconds,
+		checks:  map[string]string{serviceCheckID(service.ID): status},
+	}
+	e.regLock.Lock()
+	old := e.registrations[service.ID]
+	e.registrations[service.ID] = reg
+	e.checks[serviceCheckID(service.ID)] = service.ID
+	reg.lock.Lock()
+	e.regLock.Unlock()
+	defer reg.lock.Unlock()
+
+	if old != nil {
+		// keep the status of any checks registered for an earlier
+		// registration of the service
+		old.lock.Lock()
+		for id, status := range old.checks {
+			if _, ok := reg.checks[id]; !ok {
+				reg.checks[id] = status
+			}
+		}
+		old.removed = true
+		lease := old.lease
+		old.lock.Unlock()
+		e.revoke(lease)
+	}
+	return e.grant(reg)
+}
+
+// CheckRegister adds a check to a service that was registered by this
+// ContainerPilot. The service is healthy only while all its checks are
+// passing.
+func (e *Etcd) CheckRegister(check *CheckRegistration) error {
+	e.regLock.Lock()
+	reg, ok := e.registrations[check.ServiceID]
+	if ok {
+		e.checks[check.ID] = check.ServiceID
+	}
+	e.regLock.Unlock()
+	if !lockRegistration(reg) {
+		return fmt.Errorf("service %q is not registered", check.ServiceID)
+	}
+	defer reg.lock.Unlock()
+	status := HealthCritical
+	if check.Status != "" {
+		status = normalizeStatus(check.Status)
+	}
+	reg.checks[check.ID] = status
+	return e.putService(reg)
+}
+
+// UpdateTTL sets the status of a check and keeps the lease of its service
+// alive. If the lease has already expired, the service is registered
+// again with a new lease.
+func (e *Etcd) UpdateTTL(checkID, output, status string) error {
+	e.regLock.Lock()
+	reg := e.registrations[e.checks[checkID]]
+	e.regLock.Unlock()
+	if !lockRegistration(reg) {
+		return &unknownCheckError{checkID}
+	}
+	defer reg.lock.Unlock()
+	if _, ok := reg.checks[checkID]; !ok {
+		return &unknownCheckError{checkID}
+	}
+	status = normalizeStatus(status)
+	if reg.maintenance != "" {
+		// in maintenance mode there's no lease to keep alive
+		reg.checks[checkID] = status
+		return e.putService(reg)
+	}
+	if reg.lease == 0 {
+		// the registration failed to grant a lease
+		reg.checks[checkID] = status
+		return e.grant(reg)
+	}
+	var resp struct {
+		Result struct {
+			TTL int64 `json:"TTL,string"`
+		} `json:"result"`
+	}
+	req := map[string]interface{}{"ID": fmt.Sprintf("%d", reg.lease)}
+	if err := e.call(context.Background(), "/v3/lease/keepalive", req, &resp); err != nil {
+		return err
+	}
+	changed := reg.checks[checkID] != status
+	reg.checks[checkID] = status
+	switch {
+	case resp.Result.TTL <= 0:
+		log.Debugf("lease for %s expired, registering again", reg.service.ID)
+		return e.grant(reg)
+	case changed:
+		return e.putService(reg)
+	}
+	return nil
+}
+
+// ServiceDeregister deletes the service's key and revokes its lease
+func (e *Etcd) ServiceDeregister(serviceID string) error {
+	e.regLock.Lock()
+	reg, ok := e.registrations[serviceID]
+	if ok {
+		delete(e.registrations, serviceID)
+		for checkID, id := range e.checks {
+			if id == serviceID {
+				delete(e.checks, checkID)
+			}
+		}
+	}
+	e.regLock.Unlock()
+	if !lockRegistration(reg) {
+		return nil
+	}
+	defer reg.lock.Unlock()
+	reg.removed = true
+	req := map[string]interface{}{"key": []byte(e.serviceKey(reg.service))}
+	if err := e.call(context.Background(), "/v3/kv/deleterange", req, nil); err != nil {
+		return err
+	}
+	e.revoke(reg.lease)
+	return nil
+}
+
+// RegisteredServices returns the IDs of the services registered by this
+// ContainerPilot whose keys are still in etcd, sorted
+func (e *Etcd) RegisteredServices() ([]string, error) {
+	e.regLock.Lock()
+	keys := make(map[string]string, len(e.registrations))
+	for id, reg := range e.registrations {
+		keys[id] = e.serviceKey(reg.service)
+	}
+	e.regLock.Unlock()
+
+	ids := []string{}
+	for id, key := range keys {
+		req := etcdRangeRequest{Key: []byte(key)}
+		resp := &etcdRangeResponse{}
+		if err := e.call(context.Background(), "/v3/kv/range", req, resp); err != nil {
+			return nil, err
+		}
+		if len(resp.Kvs) > 0 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// EnableServiceMaintenance adds a critical check with the reason to a
+// service that was registered by this ContainerPilot. Heartbeats stop in
+// maintenance mode, so the service's key is put without a lease to keep
+// it registered until maintenance mode is disabled.
+func (e *Etcd) EnableServiceMaintenance(serviceID, reason string) error {
+	reg := e.registration(serviceID)
+	if !lockRegistration(reg) {
+		return fmt.Errorf("service %q is not registered", serviceID)
+	}
+	defer reg.lock.Unlock()
+	if reason == "" {
+		reason = defaultMaintenanceReason
+	}
+	reg.checks[maintenanceCheckID(serviceID)] = HealthCritical
+	reg.maintenance = reason
+	e.revoke(reg.lease)
+	reg.lease = 0
+	return e.putService(reg)
+}
+
+// DisableServiceMaintenance removes the maintenance check from a service
+// and attaches its key to a new lease
+func (e *Etcd) DisableServiceMaintenance(serviceID string) error {
+	reg := e.registration(serviceID)
+	if !lockRegistration(reg) {
+		return fmt.Errorf("service %q is not registered", serviceID)
+	}
+	defer reg.lock.Unlock()
+	delete(reg.checks, maintenanceCheckID(serviceID))
+	reg.maintenance = ""
+	return e.grant(reg)
+}
+
+// CheckForUpstreamChanges requests the instances of a service from etcd
+// and checks whether there has been a change to the healthy instances
+// since the last check. etcd has no datacenters so dc is ignored.
+func (e *Etcd) CheckForUpstreamChanges(backendName, backendTag, dc string) (didChange, isHealthy bool) {
+	didChange, isHealthy, _, err := e.rangeService(context.Background(), backendName, backendTag)
+	if err != nil {
+		log.Warnf("failed to query %v: %s", backendName, err)
+		return false, false
+	}
+	return didChange, isHealthy
+}
+
+// WaitForUpstreamChanges watches the keys of a service for any changes
+// after the revision waitIndex, which returns when a key has changed or
+// after waitTime, and checks whether there has been a change to the
+// healthy instances since the last check. Returns the revision to wait on
+// for the next query.
+func (e *Etcd) WaitForUpstreamChanges(ctx context.Context, backendName, backendTag, dc string,
+	waitIndex uint64, waitTime time.Duration) (didChange, isHealthy bool, lastIndex uint64, err error) {
+	if waitIndex > 0 {
+		if err := e.watch(ctx, e.servicePrefix(backendName), waitIndex+1, waitTime); err != nil {
+			return false, false, waitIndex, err
+		}
+	}
+	didChange, isHealthy, revision, err := e.rangeService(ctx, backendName, backendTag)
+	if err != nil {
+		return false, false, waitIndex, err
+	}
+	return didChange, isHealthy, revision, nil
+}
+
+// CheckForKVChanges requests a key, or all the keys under a prefix if
+// recurse is set, from etcd and checks whether any keys have been added,
+// removed, or modified since the last check. The keys aren't under the
+// backend's prefix. etcd has no datacenters so dc is ignored.
+func (e *Etcd) CheckForKVChanges(key string, recurse bool, dc string) (bool, error) {
+	req := etcdRangeRequest{Key: []byte(key)}
+	if recurse {
+		req.RangeEnd = prefixEnd(key)
+	}
+	resp := &etcdRangeResponse{}
+	if err := e.call(context.Background(), "/v3/kv/range", req, resp); err != nil {
+		return false, err
+	}
+	pairs := make([]*KVPair, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		pairs = append(pairs, &KVPair{
+			Key:         string(kv.Key),
+			Value:       string(kv.Value),
+			ModifyIndex: uint64(kv.ModRevision),
+		})
+	}
+	return e.swapKVPairs(key, pairs), nil
+}
+
+// rangeService requests the keys of a service, caches the healthy
+// instances, and returns whether they changed along with the revision of
+// the store
+func (e *Etcd) rangeService(ctx context.Context, service, tag string) (didChange, isHealthy bool, revision uint64, err error) {
+	prefix := e.servicePrefix(service)
+	req := etcdRangeRequest{Key: []byte(prefix), RangeEnd: prefixEnd(prefix)}
+	resp := &etcdRangeResponse{}
+	if err := e.call(ctx, "/v3/kv/range", req, resp); err != nil {
+		return false, false, 0, err
+	}
+	instances := []*ServiceInstance{}
+	for _, kv := range resp.Kvs {
+		svc := &etcdService{}
+		if err := json.Unmarshal(kv.Value, svc); err != nil {
+			log.Warnf("invalid service at %s: %v", kv.Key, err)
+			continue
+		}
+		if !svc.isPassing() || (tag != "" && !hasTag(svc.Tags, tag)) {
+			continue
+		}
+		instances = append(instances, &ServiceInstance{
+			ID:      svc.ID,
+			Name:    svc.Name,
+			Address: svc.Address,
+			Port:    svc.Port,
+			Tags:    svc.Tags,
+			Meta:    svc.Meta,
+			Node:    svc.Node,
+			Checks:  svc.Checks,
+		})
+	}
+	didChange, isHealthy = e.swapInstances(service, instances)
+	return didChange, isHealthy, uint64(resp.Header.Revision), nil
+}
+
+// watch blocks until a key under the prefix has changed at or after the
+// revision, or until waitTime has passed
+func (e *Etcd) watch(ctx context.Context, prefix string, revision uint64, waitTime time.Duration) error {
+	wctx, cancel := context.WithTimeout(ctx, waitTime)
+	defer cancel()
+	req := map[string]interface{}{
+		"create_request": map[string]interface{}{
+			"key":            []byte(prefix),
+			"range_end":      prefixEnd(prefix),
+			"start_revision": fmt.Sprintf("%d", revision),
+		},
+	}
+	body, err := e.post(wctx, "/v3/watch", req)
+	if err != nil {
+		if ctx.Err() == nil && wctx.Err() != nil {
+			return nil // waited for waitTime without a change
+		}
+		return err
+	}
+	defer body.Close()
+	dec := json.NewDecoder(body)
+	for {
+		var resp struct {
+			Result struct {
+				Canceled        bool              `json:"canceled"`
+				CompactRevision int64             `json:"compact_revision,string"`
+				Events          []json.RawMessage `json:"events"`
+			} `json:"result"`
+		}
+		if err := dec.Decode(&resp); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if wctx.Err() != nil || err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		// a compacted or canceled watch can't tell us about changes, so
+		// we treat it as one and let the caller make a new range request
+		if len(resp.Result.Events) > 0 || resp.Result.Canceled ||
+			resp.Result.CompactRevision > 0 {
+			return nil
+		}
+	}
+}
+
+// grant grants a new lease for a registration and puts the service's key
+// with it. Callers must hold the registration's lock.
+func (e *Etcd) grant(reg *etcdRegistration) error {
+	var resp struct {
+		ID int64 `json:"ID,string"`
+	}
+	req := map[string]interface{}{"TTL": fmt.Sprintf("%d", reg.ttl)}
+	if err := e.call(context.Background(), "/v3/lease/grant", req, &resp); err != nil {
+		return err
+	}
+	reg.lease = resp.ID
+	return e.putService(reg)
+}
+
+// revoke revokes a lease, deleting any keys attached to it. Errors are
+// only logged because an expired lease can't be revoked.
+func (e *Etcd) revoke(lease int64) {
+	if lease == 0 {
+		return
+	}
+	req := map[string]interface{}{"ID": fmt.Sprintf("%d", lease)}
+	if err := e.call(context.Background(), "/v3/lease/revoke", req, nil); err != nil {
+		log.Debugf("failed to revoke lease %d: %v", lease, err)
+	}
+}
+
+// putService puts the key of a registered service with its lease. Callers
+// must hold the registration's lock.
+func (e *Etcd) putService(reg *etcdRegistration) error {
+	node, _ := os.Hostname()
+	svc := &etcdService{
+		ID:      reg.service.ID,
+		Name:    reg.service.Name,
+		Address: reg.service.Address,
+		Port:    reg.service.Port,
+		Tags:    reg.service.Tags,
+		Node:    node,
+		Checks:  reg.checks,
+		Meta:    reg.service.Meta,
+		Weights: reg.service.Weights,
+
+		Maintenance: reg.maintenance,
+	}
+	value, err := json.Marshal(svc)
+	if err != nil {
+		return err
+	}
+	req := map[string]interface{}{
+		"key":   []byte(e.serviceKey(reg.service)),
+		"value": value,
+	}
+	if reg.lease != 0 {
+		req["lease"] = fmt.Sprintf("%d", reg.lease)
+	}
+	return e.call(context.Background(), "/v3/kv/put", req, nil)
+}
+
+// registration finds a service registered by this ContainerPilot
+func (e *Etcd) registration(serviceID string) *etcdRegistration {
+	e.regLock.Lock()
+	defer e.regLock.Unlock()
+	return e.registrations[serviceID]
+}
+
+// lockRegistration locks a registration, and returns false without the
+// lock if there's no registration or it was removed while we waited
+func lockRegistration(reg *etcdRegistration) bool {
+	if reg == nil {
+		return false
+	}
+	reg.lock.Lock()
+	if reg.removed {
+		reg.lock.Unlock()
+		return false
+	}
+	return true
+}
+
+func (e *Etcd) servicePrefix(service string) string {
+	return e.prefix + "services/" + service + "/"
+}
+
+func (e *Etcd) serviceKey(service *ServiceRegistration) string {
+	return e.servicePrefix(service.Name) + service.ID
+}
+
+// call makes a request to the etcd JSON gateway and decodes the response
+// into resp, if it's not nil. The request fails if it isn't answered
+// before every endpoint has timed out.
+func (e *Etcd) call(ctx context.Context, path string, req, resp interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout*time.Duration(len(e.endpoints)))
+	defer cancel()
+	body, err := e.post(ctx, path, req)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if resp == nil {
+		_, err = io.Copy(ioutil.Discard, body)
+		return err
+	}
+	return json.NewDecoder(body).Decode(resp)
+}
+
+// post sends a request to the first of the endpoints that answers it and
+// returns the body of its response, which the caller must close
+func (e *Etcd) post(ctx context.Context, path string, req interface{}) (io.ReadCloser, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var lastErr error
+	for _, endpoint := range e.endpoints {
+		r, err := http.NewRequest("POST", endpoint+path, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		r.Header.Set("Content-Type", "application/json")
+		resp, err := e.client.Do(r.WithContext(ctx))
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+			resp.Body.Close()
+			return nil, fmt.Errorf("etcd returned %s: %s", resp.Status,
+				strings.TrimSpace(string(msg)))
+		}
+		return resp.Body, nil
+	}
+	return nil, lastErr
+}
+
+type etcdRangeRequest struct {
+	Key      []byte `json:"key"`
+	RangeEnd []byte `json:"range_end,omitempty"`
+}
+
+type etcdRangeResponse struct {
+	Header struct {
+		Revision int64 `json:"revision,string"`
+	} `json:"header"`
+	Kvs []struct {
+		Key         []byte `json:"key"`
+		Value       []byte `json:"value"`
+		ModRevision int64  `json:"mod_revision,string"`
+	} `json:"kvs"`
+}
+
+// isPassing returns true if all of the service's checks are passing
+func (svc *etcdService) isPassing() bool {
+	for _, status := range svc.Checks {
+		if status != HealthPassing {
+			return false
+		}
+	}
+	return true
+}
+
+// prefixEnd returns the end of the range of keys that start with prefix
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return []byte{0} // all keys
+}
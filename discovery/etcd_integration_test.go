@@ -0,0 +1,183 @@
+// +build integration
+
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// These tests run against a real etcd rather than the fake in etcd_test.go,
+// so that they cover the framing of the gateway's streaming responses and
+// the compaction of watched revisions. They depend on a local `etcd` binary
+// installed into our environ's PATH:
+//
+//     go test -tags integration -run TestWithEtcd ./discovery/
+
+const (
+	etcdTestClientURL = "http://127.0.0.1:22379"
+	etcdTestPeerURL   = "http://127.0.0.1:22380"
+)
+
+func TestWithEtcd(t *testing.T) {
+	if _, err := exec.LookPath("etcd"); err != nil {
+		t.Fatal("etcd not found on $PATH - download and install etcd " +
+			"or skip this test")
+	}
+	dataDir, _ := ioutil.TempDir("", "etcd-test")
+	defer os.RemoveAll(dataDir)
+	cmd := exec.Command("etcd",
+		"--data-dir", dataDir,
+		"--listen-client-urls", etcdTestClientURL,
+		"--advertise-client-urls", etcdTestClientURL,
+		"--listen-peer-urls", etcdTestPeerURL,
+		"--initial-advertise-peer-urls", etcdTestPeerURL,
+		"--initial-cluster", "default="+etcdTestPeerURL)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed starting etcd: %v", err)
+	}
+	defer func() {
+		cmd.Process.Signal(os.Interrupt)
+		cmd.Wait()
+	}()
+	if err := waitForEtcd(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("TestEtcdKeepalive", testEtcdKeepalive)
+	t.Run("TestEtcdWatch", testEtcdWatch)
+	t.Run("TestEtcdWatchCompacted", testEtcdWatchCompacted)
+}
+
+func waitForEtcd(timeout time.Duration) error {
+	etcd, _ := NewEtcd(etcdTestClientURL)
+	deadline := time.Now().Add(timeout)
+	for {
+		_, err := etcd.CheckForKVChanges("ready", false, "")
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("failed waiting for etcd: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func newTestEtcd(prefix string) *Etcd {
+	etcd, _ := NewEtcd(map[string]interface{}{
+		"endpoints": []interface{}{etcdTestClientURL},
+		"prefix":    prefix,
+	})
+	return etcd
+}
+
+// heartbeats keep the same lease alive for longer than its TTL, and the
+// service is removed once they stop
+func testEtcdKeepalive(t *testing.T) {
+	etcd := newTestEtcd("keepalive/")
+	err := etcd.ServiceRegister(&ServiceRegistration{
+		ID: "app-1", Name: "app", Address: "10.0.0.1", Port: 8000,
+		Check: &ServiceCheck{TTL: "2s"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lease := etcd.registrations["app-1"].lease
+	for i := 0; i < 4; i++ {
+		if err := etcd.UpdateTTL("service:app-1", "ok", "pass"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		time.Sleep(time.Second)
+	}
+	assert.Equal(t, lease, etcd.registrations["app-1"].lease,
+		"expected heartbeats to keep the lease alive rather than replace it")
+	_, isHealthy := etcd.CheckForUpstreamChanges("app", "", "")
+	assert.True(t, isHealthy, "expected service to be healthy after heartbeats")
+
+	deadline := time.Now().Add(10 * time.Second)
+	for isHealthy && time.Now().Before(deadline) {
+		time.Sleep(500 * time.Millisecond)
+		_, isHealthy = etcd.CheckForUpstreamChanges("app", "", "")
+	}
+	assert.False(t, isHealthy, "expected service to expire without heartbeats")
+}
+
+// a blocking query returns as soon as the service changes, and after the
+// wait time if it doesn't
+func testEtcdWatch(t *testing.T) {
+	etcd := newTestEtcd("watch/")
+	ctx := context.Background()
+	_, _, index, err := etcd.WaitForUpstreamChanges(ctx, "app", "", "", 0, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	start := time.Now()
+	didChange, _, next, err := etcd.WaitForUpstreamChanges(ctx, "app", "", "", index, time.Second)
+	assert.Nil(t, err)
+	assert.False(t, didChange, "expected no change")
+	assert.Equal(t, index, next)
+	assert.True(t, time.Since(start) >= time.Second, "expected to wait for the wait time")
+
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		etcd.ServiceRegister(&ServiceRegistration{
+			ID: "app-1", Name: "app", Address: "10.0.0.1", Port: 8000,
+			Check: &ServiceCheck{TTL: "10s", Status: HealthPassing},
+		})
+	}()
+	start = time.Now()
+	didChange, isHealthy, next, err := etcd.WaitForUpstreamChanges(ctx, "app", "", "", index, time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, didChange, "expected change after registration")
+	assert.True(t, isHealthy, "expected service to be healthy")
+	assert.True(t, next > index, "expected revision to advance")
+	assert.True(t, time.Since(start) < 10*time.Second,
+		"expected the watch to return when the service changed")
+	etcd.ServiceDeregister("app-1")
+}
+
+// a watch from a revision that's been compacted returns right away so that
+// the caller makes a new range request
+func testEtcdWatchCompacted(t *testing.T) {
+	etcd := newTestEtcd("compact/")
+	ctx := context.Background()
+	_, _, index, err := etcd.WaitForUpstreamChanges(ctx, "app", "", "", 0, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		req := map[string]interface{}{
+			"key":   []byte("compact/other"),
+			"value": []byte(fmt.Sprintf("%d", i)),
+		}
+		if err := etcd.call(ctx, "/v3/kv/put", req, nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	_, _, latest, _ := etcd.WaitForUpstreamChanges(ctx, "app", "", "", 0, time.Minute)
+	req := map[string]interface{}{
+		"revision": fmt.Sprintf("%d", latest),
+		"physical": true,
+	}
+	if err := etcd.call(ctx, "/v3/kv/compaction", req, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	start := time.Now()
+	_, _, next, err := etcd.WaitForUpstreamChanges(ctx, "app", "", "", index, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, latest, next)
+	assert.True(t, time.Since(start) < 10*time.Second,
+		"expected the compacted watch to return right away")
+}
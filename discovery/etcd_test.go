@@ -0,0 +1,455 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEtcdConfig(t *testing.T) {
+	etcd, _ := NewEtcd("etcd:2379")
+	assert.Equal(t, []string{"http://etcd:2379"}, etcd.endpoints)
+	assert.Equal(t, "containerpilot/", etcd.prefix)
+
+	etcd, _ = NewEtcd(map[string]interface{}{
+		"endpoints": []interface{}{"https://etcd1:2379/", "etcd2:2379"},
+		"prefix":    "/cp",
+	})
+	assert.Equal(t, []string{"https://etcd1:2379", "http://etcd2:2379"}, etcd.endpoints)
+	assert.Equal(t, "/cp/", etcd.prefix)
+
+	etcd, _ = NewEtcd(map[string]interface{}{})
+	assert.Equal(t, []string{"http://127.0.0.1:2379"}, etcd.endpoints)
+
+	_, err := NewEtcd(map[string]interface{}{"endpoint": "etcd:2379"})
+	assert.Error(t, err)
+}
+
+func TestEtcdRegistration(t *testing.T) {
+	server := newFakeEtcd()
+	defer server.Close()
+	etcd, _ := NewEtcd(server.URL)
+
+	err := etcd.ServiceRegister(&ServiceRegistration{
+		ID: "app-1", Name: "app", Address: "10.0.0.1", Port: 8000,
+		Tags:  []string{"dev"},
+		Meta:  map[string]string{"version": "1.2.3"},
+		Check: &ServiceCheck{TTL: "10s"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, int64(10), server.leaseTTL("containerpilot/services/app/app-1"))
+
+	// a service isn't healthy until its first heartbeat
+	didChange, isHealthy := etcd.CheckForUpstreamChanges("app", "", "")
+	assert.False(t, didChange)
+	assert.False(t, isHealthy)
+
+	if err := etcd.UpdateTTL("service:app-1", "ok", "pass"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	didChange, isHealthy = etcd.CheckForUpstreamChanges("app", "", "")
+	assert.True(t, didChange)
+	assert.True(t, isHealthy)
+	instances := etcd.ServiceInstances("app")
+	if len(instances) != 1 {
+		t.Fatalf("expected 1 instance but got %v", instances)
+	}
+	assert.Equal(t, "10.0.0.1", instances[0].Address)
+	assert.Equal(t, 8000, instances[0].Port)
+	assert.Equal(t, map[string]string{"service:app-1": HealthPassing}, instances[0].Checks)
+	assert.Equal(t, map[string]string{"version": "1.2.3"}, instances[0].Meta)
+
+	// the service is unhealthy while any of its checks fail
+	err = etcd.CheckRegister(&CheckRegistration{ID: "disk", ServiceID: "app-1",
+		ServiceCheck: ServiceCheck{Status: HealthPassing}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	etcd.UpdateTTL("disk", "full", "fail")
+	didChange, isHealthy = etcd.CheckForUpstreamChanges("app", "", "")
+	assert.True(t, didChange)
+	assert.False(t, isHealthy)
+	assert.Error(t, etcd.UpdateTTL("missing", "", "pass"))
+	assert.Error(t, etcd.CheckRegister(&CheckRegistration{ID: "x", ServiceID: "missing"}))
+
+	etcd.UpdateTTL("disk", "ok", "pass")
+	_, isHealthy = etcd.CheckForUpstreamChanges("app", "prod", "")
+	assert.False(t, isHealthy)
+	_, isHealthy = etcd.CheckForUpstreamChanges("app", "dev", "")
+	assert.True(t, isHealthy)
+
+	if err := etcd.ServiceDeregister("app-1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, 0, server.leaseCount())
+	_, isHealthy = etcd.CheckForUpstreamChanges("app", "", "")
+	assert.False(t, isHealthy)
+}
+
+func TestEtcdLeaseExpired(t *testing.T) {
+	server := newFakeEtcd()
+	defer server.Close()
+	etcd, _ := NewEtcd(server.URL)
+	etcd.ServiceRegister(&ServiceRegistration{ID: "app-1", Name: "app",
+		Check: &ServiceCheck{TTL: "10s", Status: HealthPassing}})
+	_, isHealthy := etcd.CheckForUpstreamChanges("app", "", "")
+	assert.True(t, isHealthy)
+
+	server.expireLeases()
+	_, isHealthy = etcd.CheckForUpstreamChanges("app", "", "")
+	assert.False(t, isHealthy)
+
+	// the next heartbeat registers the service again
+	if err := etcd.UpdateTTL("service:app-1", "ok", "pass"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, isHealthy = etcd.CheckForUpstreamChanges("app", "", "")
+	assert.True(t, isHealthy)
+}
+
+// a service in maintenance keeps its key without a lease so that it isn't
+// removed while its heartbeats are stopped
+func TestEtcdMaintenance(t *testing.T) {
+	server := newFakeEtcd()
+	defer server.Close()
+	etcd, _ := NewEtcd(server.URL)
+	etcd.ServiceRegister(&ServiceRegistration{ID: "app-1", Name: "app",
+		Check: &ServiceCheck{TTL: "10s", Status: HealthPassing}})
+
+	if err := etcd.EnableServiceMaintenance("app-1", "upgrading"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, 0, server.leaseCount())
+	_, isHealthy := etcd.CheckForUpstreamChanges("app", "", "")
+	assert.False(t, isHealthy)
+	server.lock.Lock()
+	_, ok := server.kvs["containerpilot/services/app/app-1"]
+	server.lock.Unlock()
+	assert.True(t, ok)
+
+	if err := etcd.DisableServiceMaintenance("app-1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, int64(10), server.leaseTTL("containerpilot/services/app/app-1"))
+	_, isHealthy = etcd.CheckForUpstreamChanges("app", "", "")
+	assert.True(t, isHealthy)
+
+	assert.Error(t, etcd.EnableServiceMaintenance("missing", ""))
+	assert.Error(t, etcd.DisableServiceMaintenance("missing"))
+}
+
+func TestEtcdWaitForUpstreamChanges(t *testing.T) {
+	server := newFakeEtcd()
+	defer server.Close()
+	etcd, _ := NewEtcd(map[string]interface{}{
+		"endpoints": []interface{}{server.URL}, "prefix": "cp"})
+	ctx := context.Background()
+
+	_, _, index, err := etcd.WaitForUpstreamChanges(ctx, "app", "", "", 0, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// no change before the wait time
+	start := time.Now()
+	didChange, _, next, err := etcd.WaitForUpstreamChanges(ctx, "app", "", "",
+		index, 100*time.Millisecond)
+	assert.NoError(t, err)
+	assert.False(t, didChange)
+	assert.Equal(t, index, next)
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		server.put("cp/services/app/app-2",
+			`{"id":"app-2","name":"app","address":"10.0.0.2","port":80,"checks":{}}`)
+	}()
+	start = time.Now()
+	didChange, isHealthy, next, err := etcd.WaitForUpstreamChanges(ctx, "app", "", "",
+		index, 5*time.Second)
+	assert.NoError(t, err)
+	assert.True(t, didChange)
+	assert.True(t, isHealthy)
+	assert.True(t, next > index)
+	assert.True(t, time.Since(start) < 5*time.Second)
+
+	// changes to other services don't return early
+	server.put("cp/services/other/other-1", `{"id":"other-1","checks":{}}`)
+	didChange, _, _, _ = etcd.WaitForUpstreamChanges(ctx, "app", "", "",
+		next, 100*time.Millisecond)
+	assert.False(t, didChange)
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, _, _, err = etcd.WaitForUpstreamChanges(cctx, "app", "", "", next, time.Second)
+	assert.Error(t, err)
+}
+
+func TestEtcdCheckForKVChanges(t *testing.T) {
+	server := newFakeEtcd()
+	defer server.Close()
+	etcd, _ := NewEtcd(server.URL)
+	server.put("config/a", "1")
+	server.put("config/b", "2")
+	server.put("configx", "3")
+
+	didChange, err := etcd.CheckForKVChanges("config/", true, "")
+	assert.NoError(t, err)
+	assert.True(t, didChange)
+	pairs := etcd.KVPairs("config/")
+	if len(pairs) != 2 {
+		t.Fatalf("expected 2 keys but got %v", pairs)
+	}
+	assert.Equal(t, "config/a", pairs[0].Key)
+	assert.Equal(t, "1", pairs[0].Value)
+
+	didChange, _ = etcd.CheckForKVChanges("config/", true, "")
+	assert.False(t, didChange)
+	server.put("config/b", "2")
+	didChange, _ = etcd.CheckForKVChanges("config/", true, "")
+	assert.True(t, didChange, "expected a change on a new revision")
+
+	didChange, _ = etcd.CheckForKVChanges("configx", false, "")
+	assert.True(t, didChange)
+	assert.Equal(t, "3", etcd.KVPairs("configx")[0].Value)
+}
+
+// an endpoint that doesn't answer times out and the next one is tried
+func TestEtcdEndpointTimeout(t *testing.T) {
+	hung := make(chan struct{})
+	unresponsive := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) { <-hung }))
+	defer unresponsive.Close()
+	defer close(hung)
+	server := newFakeEtcd()
+	defer server.Close()
+	etcd, _ := NewEtcd(map[string]interface{}{
+		"endpoints": []interface{}{unresponsive.URL, server.URL}})
+	etcd.timeout = 100 * time.Millisecond
+	etcd.client = newEtcdClient(etcd.timeout)
+
+	err := etcd.ServiceRegister(&ServiceRegistration{ID: "app-1", Name: "app",
+		Check: &ServiceCheck{TTL: "10s", Status: HealthPassing}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, isHealthy := etcd.CheckForUpstreamChanges("app", "", "")
+	assert.True(t, isHealthy)
+
+	etcd.endpoints = etcd.endpoints[:1]
+	assert.Error(t, etcd.UpdateTTL("service:app-1", "ok", "pass"))
+}
+
+func TestPrefixEnd(t *testing.T) {
+	assert.Equal(t, []byte("a0"), prefixEnd("a/"))
+	assert.Equal(t, []byte("b"), prefixEnd("a\xff"))
+	assert.Equal(t, []byte{0}, prefixEnd(""))
+}
+
+// fakeEtcd is a test double of the parts of the etcd v3 JSON gateway that
+// the backend uses
+type fakeEtcd struct {
+	*httptest.Server
+	lock     sync.Mutex
+	revision int64
+	kvs      map[string]*fakeKV
+	leases   map[int64]int64 // ID to TTL
+	nextID   int64
+	history  []fakeEvent
+}
+
+type fakeKV struct {
+	value       []byte
+	modRevision int64
+	lease       int64
+}
+
+type fakeEvent struct {
+	key      string
+	revision int64
+}
+
+func newFakeEtcd() *fakeEtcd {
+	f := &fakeEtcd{
+		revision: 1,
+		kvs:      map[string]*fakeKV{},
+		leases:   map[int64]int64{},
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	return f
+}
+
+func (f *fakeEtcd) handle(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Key           []byte `json:"key"`
+		RangeEnd      []byte `json:"range_end"`
+		Value         []byte `json:"value"`
+		Lease         string `json:"lease"`
+		ID            string `json:"ID"`
+		TTL           string `json:"TTL"`
+		CreateRequest struct {
+			Key           []byte `json:"key"`
+			RangeEnd      []byte `json:"range_end"`
+			StartRevision string `json:"start_revision"`
+		} `json:"create_request"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.URL.Path == "/v3/watch" {
+		start, _ := strconv.ParseInt(req.CreateRequest.StartRevision, 10, 64)
+		f.watch(w, r, string(req.CreateRequest.Key), string(req.CreateRequest.RangeEnd), start)
+		return
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var resp interface{}
+	switch r.URL.Path {
+	case "/v3/kv/range":
+		resp = f.rangeKeys(string(req.Key), string(req.RangeEnd))
+	case "/v3/kv/put":
+		lease, _ := strconv.ParseInt(req.Lease, 10, 64)
+		if _, ok := f.leases[lease]; lease != 0 && !ok {
+			http.Error(w, `{"error":"etcdserver: requested lease not found"}`,
+				http.StatusNotFound)
+			return
+		}
+		f.putLocked(string(req.Key), req.Value, lease)
+		resp = map[string]interface{}{}
+	case "/v3/kv/deleterange":
+		f.deleteLocked(string(req.Key))
+		resp = map[string]interface{}{}
+	case "/v3/lease/grant":
+		f.nextID++
+		ttl, _ := strconv.ParseInt(req.TTL, 10, 64)
+		f.leases[f.nextID] = ttl
+		resp = map[string]string{"ID": fmt.Sprintf("%d", f.nextID), "TTL": req.TTL}
+	case "/v3/lease/keepalive":
+		id, _ := strconv.ParseInt(req.ID, 10, 64)
+		result := map[string]string{"ID": req.ID}
+		if ttl, ok := f.leases[id]; ok {
+			result["TTL"] = fmt.Sprintf("%d", ttl)
+		}
+		resp = map[string]interface{}{"result": result}
+	case "/v3/lease/revoke":
+		id, _ := strconv.ParseInt(req.ID, 10, 64)
+		f.revokeLocked(id)
+		resp = map[string]interface{}{}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (f *fakeEtcd) rangeKeys(key, end string) interface{} {
+	kvs := []map[string]interface{}{}
+	for k, kv := range f.kvs {
+		if k == key || (end != "" && k >= key && (end == "\x00" || k < end)) {
+			kvs = append(kvs, map[string]interface{}{
+				"key":          []byte(k),
+				"value":        kv.value,
+				"mod_revision": fmt.Sprintf("%d", kv.modRevision),
+			})
+		}
+	}
+	resp := map[string]interface{}{
+		"header": map[string]string{"revision": fmt.Sprintf("%d", f.revision)},
+	}
+	if len(kvs) > 0 {
+		resp["kvs"] = kvs
+	}
+	return resp
+}
+
+// watch streams a result once a key in the range has changed since start
+func (f *fakeEtcd) watch(w http.ResponseWriter, r *http.Request, key, end string, start int64) {
+	enc := json.NewEncoder(w)
+	enc.Encode(map[string]interface{}{"result": map[string]bool{"created": true}})
+	w.(http.Flusher).Flush()
+	for {
+		f.lock.Lock()
+		var events []map[string]interface{}
+		for _, ev := range f.history {
+			if ev.revision >= start && ev.key >= key && ev.key < end {
+				events = append(events, map[string]interface{}{
+					"kv": map[string]interface{}{"key": []byte(ev.key)}})
+			}
+		}
+		f.lock.Unlock()
+		if len(events) > 0 {
+			enc.Encode(map[string]interface{}{
+				"result": map[string]interface{}{"events": events}})
+			w.(http.Flusher).Flush()
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func (f *fakeEtcd) put(key, value string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.putLocked(key, []byte(value), 0)
+}
+
+func (f *fakeEtcd) putLocked(key string, value []byte, lease int64) {
+	f.revision++
+	f.kvs[key] = &fakeKV{value: value, modRevision: f.revision, lease: lease}
+	f.history = append(f.history, fakeEvent{key, f.revision})
+}
+
+func (f *fakeEtcd) deleteLocked(key string) {
+	if _, ok := f.kvs[key]; ok {
+		f.revision++
+		delete(f.kvs, key)
+		f.history = append(f.history, fakeEvent{key, f.revision})
+	}
+}
+
+func (f *fakeEtcd) revokeLocked(id int64) {
+	delete(f.leases, id)
+	for k, kv := range f.kvs {
+		if kv.lease == id {
+			f.deleteLocked(k)
+		}
+	}
+}
+
+func (f *fakeEtcd) expireLeases() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for id := range f.leases {
+		f.revokeLocked(id)
+	}
+}
+
+func (f *fakeEtcd) leaseTTL(key string) int64 {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if kv, ok := f.kvs[key]; ok {
+		return f.leases[kv.lease]
+	}
+	return 0
+}
+
+func (f *fakeEtcd) leaseCount() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return len(f.leases)
+}
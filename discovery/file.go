@@ -0,0 +1,449 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/joyent/containerpilot/config/files"
+	log "github.com/sirupsen/logrus"
+)
+
+// File is a service discovery backend that keeps services and keys in a
+// JSON file, for running ContainerPilot without a Consul agent. Services
+// can be written into the file by hand, and registrations and heartbeats
+// update it, so that several ContainerPilots sharing the file can find
+// each other.
+type File struct {
+	path string
+
+	instanceCache
+}
+
+// fileData is the contents of the file
+type fileData struct {
+	Services []*fileService    `json:"services"`
+	KV       map[string]string `json:"kv,omitempty"`
+}
+
+// fileService is a service in the file. A service without any checks is
+// always healthy.
+type fileService struct {
+	ID      string                `json:"id"`
+	Name    string                `json:"name"`
+	Address string                `json:"address"`
+	Port    int                   `json:"port"`
+	Tags    []string              `json:"tags,omitempty"`
+	Node    string                `json:"node,omitempty"`
+	Checks  map[string]*fileCheck `json:"checks,omitempty"`
+	Meta    map[string]string     `json:"meta,omitempty"`
+	Weights *ServiceWeights       `json:"weights,omitempty"`
+}
+
+// fileCheck is a TTL check of a service in the file. The check is
+// critical once it expires, until its next heartbeat.
+type fileCheck struct {
+	Status  string     `json:"status"`
+	Output  string     `json:"output,omitempty"`
+	TTL     string     `json:"ttl,omitempty"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// NewFile creates a new service discovery backend for the file at path,
+// which doesn't need to exist yet
+func NewFile(path string) (*File, error) {
+	if path == "" {
+		return nil, fmt.Errorf("file path must not be empty")
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	return &File{path: path}, nil
+}
+
+// ServiceRegister adds the service to the file, replacing any service
+// with the same ID
+func (f *File) ServiceRegister(service *ServiceRegistration) error {
+	node, _ := os.Hostname()
+	svc := &fileService{
+		ID:      service.ID,
+		Name:    service.Name,
+		Address: service.Address,
+		Port:    service.Port,
+		Tags:    service.Tags,
+		Node:    node,
+		Meta:    service.Meta,
+		Weights: service.Weights,
+	}
+	if service.Check != nil {
+		check, err := newFileCheck(&ServiceCheck{
+			TTL: service.Check.TTL, Status: service.Check.Status})
+		if err != nil {
+			return err
+		}
+		svc.Checks = map[string]*fileCheck{serviceCheckID(service.ID): check}
+	}
+	return f.update(func(data *fileData) error {
+		for i, existing := range data.Services {
+			if existing.ID == service.ID {
+				data.Services[i] = svc
+				return nil
+			}
+		}
+		data.Services = append(data.Services, svc)
+		return nil
+	})
+}
+
+// RegisteredServices returns the IDs of all the services in the file,
+// sorted
+func (f *File) RegisteredServices() ([]string, error) {
+	data, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(data.Services))
+	for _, svc := range data.Services {
+		ids = append(ids, svc.ID)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// CheckRegister adds a check to a service in the file
+func (f *File) CheckRegister(check *CheckRegistration) error {
+	fc, err := newFileCheck(&check.ServiceCheck)
+	if err != nil {
+		return err
+	}
+	return f.update(func(data *fileData) error {
+		for _, svc := range data.Services {
+			if svc.ID == check.ServiceID {
+				if svc.Checks == nil {
+					svc.Checks = map[string]*fileCheck{}
+				}
+				svc.Checks[check.ID] = fc
+				return nil
+			}
+		}
+		return fmt.Errorf("service %q is not registered", check.ServiceID)
+	})
+}
+
+// UpdateTTL sets the status and output of a check and pushes back the
+// time it expires
+func (f *File) UpdateTTL(checkID, output, status string) error {
+	return f.update(func(data *fileData) error {
+		for _, svc := range data.Services {
+			if check, ok := svc.Checks[checkID]; ok {
+				check.Status = normalizeStatus(status)
+				check.Output = output
+				check.setExpires(time.Now())
+				return nil
+			}
+		}
+		return &unknownCheckError{checkID}
+	})
+}
+
+// ServiceDeregister removes the service from the file
+func (f *File) ServiceDeregister(serviceID string) error {
+	return f.update(func(data *fileData) error {
+		for i, svc := range data.Services {
+			if svc.ID == serviceID {
+				data.Services = append(data.Services[:i], data.Services[i+1:]...)
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+// EnableServiceMaintenance adds a critical check with the reason to a
+// service in the file
+func (f *File) EnableServiceMaintenance(serviceID, reason string) error {
+	if reason == "" {
+		reason = defaultMaintenanceReason
+	}
+	return f.update(func(data *fileData) error {
+		for _, svc := range data.Services {
+			if svc.ID == serviceID {
+				if svc.Checks == nil {
+					svc.Checks = map[string]*fileCheck{}
+				}
+				svc.Checks[maintenanceCheckID(serviceID)] = &fileCheck{
+					Status: HealthCritical, Output: reason}
+				return nil
+			}
+		}
+		return fmt.Errorf("service %q is not registered", serviceID)
+	})
+}
+
+// DisableServiceMaintenance removes the maintenance check from a service
+// in the file
+func (f *File) DisableServiceMaintenance(serviceID string) error {
+	return f.update(func(data *fileData) error {
+		for _, svc := range data.Services {
+			if svc.ID == serviceID {
+				delete(svc.Checks, maintenanceCheckID(serviceID))
+				return nil
+			}
+		}
+		return fmt.Errorf("service %q is not registered", serviceID)
+	})
+}
+
+// CheckForUpstreamChanges reads the instances of a service from the file
+// and checks whether there has been a change to the healthy instances
+// since the last check. The file has no datacenters so dc is ignored.
+func (f *File) CheckForUpstreamChanges(backendName, backendTag, dc string) (didChange, isHealthy bool) {
+	didChange, isHealthy, err := f.readService(backendName, backendTag)
+	if err != nil {
+		log.Warnf("failed to query %v: %s", backendName, err)
+		return false, false
+	}
+	return didChange, isHealthy
+}
+
+// WaitForUpstreamChanges waits until the file has been modified since
+// waitIndex, a check of the service expires, or waitTime passes, and then
+// checks whether there has been a change to the healthy instances since
+// the last check. The index is the modification time of the file.
+func (f *File) WaitForUpstreamChanges(ctx context.Context, backendName, backendTag, dc string,
+	waitIndex uint64, waitTime time.Duration) (didChange, isHealthy bool, lastIndex uint64, err error) {
+	if waitIndex > 0 {
+		if err := f.wait(ctx, backendName, waitIndex, waitTime); err != nil {
+			return false, false, waitIndex, err
+		}
+	}
+	// we take the index before reading so that a write in between is
+	// seen by the next wait rather than lost
+	index := f.index()
+	didChange, isHealthy, err = f.readService(backendName, backendTag)
+	if err != nil {
+		return false, false, waitIndex, err
+	}
+	return didChange, isHealthy, index, nil
+}
+
+// CheckForKVChanges reads a key, or all the keys under a prefix if recurse
+// is set, from the file's "kv" object and checks whether any keys have been
+// added, removed, or modified since the last check
+func (f *File) CheckForKVChanges(key string, recurse bool, dc string) (bool, error) {
+	data, err := f.read()
+	if err != nil {
+		return false, err
+	}
+	pairs := []*KVPair{}
+	for k, v := range data.KV {
+		if k == key || (recurse && strings.HasPrefix(k, key)) {
+			pairs = append(pairs, &KVPair{Key: k, Value: v})
+		}
+	}
+	return f.swapKVPairs(key, pairs), nil
+}
+
+// readService reads the instances of a service from the file, caches the
+// healthy instances, and returns whether they changed
+func (f *File) readService(service, tag string) (didChange, isHealthy bool, err error) {
+	data, err := f.read()
+	if err != nil {
+		return false, false, err
+	}
+	instances := []*ServiceInstance{}
+	now := time.Now()
+	for _, svc := range data.Services {
+		if svc.Name != service || (tag != "" && !hasTag(svc.Tags, tag)) {
+			continue
+		}
+		checks, passing := svc.statuses(now)
+		if !passing {
+			continue
+		}
+		instances = append(instances, &ServiceInstance{
+			ID:      svc.ID,
+			Name:    svc.Name,
+			Address: svc.Address,
+			Port:    svc.Port,
+			Tags:    svc.Tags,
+			Meta:    svc.Meta,
+			Node:    svc.Node,
+			Checks:  checks,
+		})
+	}
+	didChange, isHealthy = f.swapInstances(service, instances)
+	return didChange, isHealthy, nil
+}
+
+// nextExpiry returns the time the next of the checks of a service
+// expires, or the zero time if none of them will
+func (data *fileData) nextExpiry(service string, now time.Time) time.Time {
+	var next time.Time
+	for _, svc := range data.Services {
+		if svc.Name != service {
+			continue
+		}
+		for _, check := range svc.Checks {
+			if check.Expires != nil && check.Expires.After(now) &&
+				(next.IsZero() || check.Expires.Before(next)) {
+				next = *check.Expires
+			}
+		}
+	}
+	return next
+}
+
+// wait blocks until the file has been modified since waitIndex, a check
+// of the service expires, or waitTime passes
+func (f *File) wait(ctx context.Context, service string, waitIndex uint64, waitTime time.Duration) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	// we watch the directory so that we see the file being replaced
+	if err := watcher.Add(filepath.Dir(f.path)); err != nil {
+		return err
+	}
+	// the file may have changed before we started watching it
+	if f.index() != waitIndex {
+		return nil
+	}
+	data, err := f.read()
+	if err != nil {
+		return err
+	}
+	if nextExpiry := data.nextExpiry(service, time.Now()); !nextExpiry.IsZero() {
+		if untilExpiry := time.Until(nextExpiry); untilExpiry < waitTime {
+			waitTime = untilExpiry
+		}
+	}
+	timer := time.NewTimer(waitTime)
+	defer timer.Stop()
+	for {
+		select {
+		case event := <-watcher.Events:
+			if event.Name == f.path {
+				return nil
+			}
+		case err := <-watcher.Errors:
+			return err
+		case <-timer.C:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// index returns the modification time of the file, or 1 if it doesn't
+// exist so that blocking queries still wait for it to be created
+func (f *File) index() uint64 {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return 1
+	}
+	return uint64(info.ModTime().UnixNano())
+}
+
+// read reads the file, which is empty if it doesn't exist
+func (f *File) read() (*fileData, error) {
+	data := &fileData{}
+	raw, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return data, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, data); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %v", f.path, err)
+	}
+	return data, nil
+}
+
+// update applies fn to the contents of the file and writes the result.
+// The file is locked while it's updated so that the updates of other
+// ContainerPilots sharing the file aren't lost. The file itself is
+// replaced on each write, so we lock a separate file next to it.
+func (f *File) update(fn func(*fileData) error) error {
+	lock, err := os.OpenFile(f.path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	data, err := f.read()
+	if err != nil {
+		return err
+	}
+	if err := fn(data); err != nil {
+		return err
+	}
+	raw, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	return files.WriteAtomic(f.path, append(raw, '\n'), 0644, -1, -1)
+}
+
+// newFileCheck creates a check with the TTL and initial status of a
+// ServiceCheck. Like Consul, the check is critical until its first
+// heartbeat unless another status is given, and expires a TTL after it's
+// registered.
+func newFileCheck(check *ServiceCheck) (*fileCheck, error) {
+	fc := &fileCheck{Status: HealthCritical, TTL: check.TTL}
+	if check.Status != "" {
+		fc.Status = normalizeStatus(check.Status)
+	}
+	if check.TTL != "" {
+		if _, err := time.ParseDuration(check.TTL); err != nil {
+			return nil, fmt.Errorf("invalid check TTL: %v", err)
+		}
+	}
+	fc.setExpires(time.Now())
+	return fc, nil
+}
+
+// setExpires sets the time the check expires to a TTL after now
+func (check *fileCheck) setExpires(now time.Time) {
+	if ttl, err := time.ParseDuration(check.TTL); err == nil && ttl > 0 {
+		expires := now.Add(ttl)
+		check.Expires = &expires
+	}
+}
+
+// statuses returns the status of each of the service's checks and whether
+// they're all passing, counting expired checks as critical
+func (svc *fileService) statuses(now time.Time) (map[string]string, bool) {
+	if len(svc.Checks) == 0 {
+		return nil, true
+	}
+	statuses := make(map[string]string, len(svc.Checks))
+	passing := true
+	for id, check := range svc.Checks {
+		status := check.Status
+		if check.Expires != nil && now.After(*check.Expires) {
+			status = HealthCritical
+		}
+		statuses[id] = status
+		if status != HealthPassing {
+			passing = false
+		}
+	}
+	return statuses, passing
+}
@@ -0,0 +1,227 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestFile(t *testing.T, contents string) (*File, func()) {
+	dir, err := ioutil.TempDir("", "discovery-file")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	path := filepath.Join(dir, "services.json")
+	if contents != "" {
+		ioutil.WriteFile(path, []byte(contents), 0644)
+	}
+	f, err := NewFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return f, func() { os.RemoveAll(dir) }
+}
+
+func TestFileStaticServices(t *testing.T) {
+	f, cleanup := newTestFile(t, `{
+  "services": [
+    {"id": "db-1", "name": "db", "address": "10.0.0.5", "port": 5432, "tags": ["primary"],
+     "meta": {"version": "1.2.3"}},
+    {"id": "db-2", "name": "db", "address": "10.0.0.6", "port": 5432,
+     "checks": {"service:db-2": {"status": "critical"}}},
+    {"id": "app-1", "name": "app", "address": "10.0.0.7", "port": 80}
+  ],
+  "kv": {"config/a": "1", "config/b": "2", "other": "3"}
+}`)
+	defer cleanup()
+
+	didChange, isHealthy := f.CheckForUpstreamChanges("db", "", "")
+	assert.True(t, didChange)
+	assert.True(t, isHealthy)
+	instances := f.ServiceInstances("db")
+	if len(instances) != 1 {
+		t.Fatalf("expected 1 instance but got %v", instances)
+	}
+	assert.Equal(t, "db-1", instances[0].ID)
+	assert.Equal(t, "10.0.0.5", instances[0].Address)
+	assert.Equal(t, map[string]string{"version": "1.2.3"}, instances[0].Meta)
+
+	didChange, isHealthy = f.CheckForUpstreamChanges("db", "", "")
+	assert.False(t, didChange)
+	_, isHealthy = f.CheckForUpstreamChanges("db", "replica", "")
+	assert.False(t, isHealthy)
+	_, isHealthy = f.CheckForUpstreamChanges("cache", "", "")
+	assert.False(t, isHealthy)
+
+	didChange, err := f.CheckForKVChanges("config/", true, "")
+	assert.NoError(t, err)
+	assert.True(t, didChange)
+	assert.Equal(t, []*KVPair{{Key: "config/a", Value: "1"}, {Key: "config/b", Value: "2"}},
+		f.KVPairs("config/"))
+	didChange, _ = f.CheckForKVChanges("config/", true, "")
+	assert.False(t, didChange)
+}
+
+func TestFileRegistration(t *testing.T) {
+	f, cleanup := newTestFile(t, "")
+	defer cleanup()
+
+	// the file doesn't exist yet
+	_, isHealthy := f.CheckForUpstreamChanges("app", "", "")
+	assert.False(t, isHealthy)
+
+	err := f.ServiceRegister(&ServiceRegistration{
+		ID: "app-1", Name: "app", Address: "10.0.0.1", Port: 8000,
+		Meta:  map[string]string{"version": "1.2.3"},
+		Check: &ServiceCheck{TTL: "200ms"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, _ := f.read()
+	assert.Equal(t, map[string]string{"version": "1.2.3"}, data.Services[0].Meta)
+	// a service isn't healthy until its first heartbeat
+	_, isHealthy = f.CheckForUpstreamChanges("app", "", "")
+	assert.False(t, isHealthy)
+
+	if err := f.UpdateTTL("service:app-1", "ok", "pass"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	didChange, isHealthy := f.CheckForUpstreamChanges("app", "", "")
+	assert.True(t, didChange)
+	assert.True(t, isHealthy)
+	assert.Equal(t, map[string]string{"service:app-1": HealthPassing},
+		f.ServiceInstances("app")[0].Checks)
+
+	// the check is critical once its TTL passes without a heartbeat
+	time.Sleep(250 * time.Millisecond)
+	didChange, isHealthy = f.CheckForUpstreamChanges("app", "", "")
+	assert.True(t, didChange)
+	assert.False(t, isHealthy)
+
+	f.UpdateTTL("service:app-1", "ok", "pass")
+	err = f.CheckRegister(&CheckRegistration{ID: "disk", ServiceID: "app-1"})
+	assert.NoError(t, err)
+	_, isHealthy = f.CheckForUpstreamChanges("app", "", "")
+	assert.False(t, isHealthy)
+	f.UpdateTTL("disk", "ok", "pass")
+	_, isHealthy = f.CheckForUpstreamChanges("app", "", "")
+	assert.True(t, isHealthy)
+
+	assert.Error(t, f.UpdateTTL("missing", "", "pass"))
+	assert.Error(t, f.CheckRegister(&CheckRegistration{ID: "x", ServiceID: "missing"}))
+
+	assert.NoError(t, f.ServiceDeregister("app-1"))
+	assert.NoError(t, f.ServiceDeregister("app-1"))
+	_, isHealthy = f.CheckForUpstreamChanges("app", "", "")
+	assert.False(t, isHealthy)
+}
+
+func TestFileMaintenance(t *testing.T) {
+	f, cleanup := newTestFile(t, "")
+	defer cleanup()
+
+	f.ServiceRegister(&ServiceRegistration{ID: "app-1", Name: "app",
+		Check: &ServiceCheck{TTL: "10s", Status: HealthPassing}})
+	_, isHealthy := f.CheckForUpstreamChanges("app", "", "")
+	assert.True(t, isHealthy)
+
+	assert.NoError(t, f.EnableServiceMaintenance("app-1", "upgrading"))
+	_, isHealthy = f.CheckForUpstreamChanges("app", "", "")
+	assert.False(t, isHealthy)
+	data, _ := ioutil.ReadFile(f.path)
+	assert.Contains(t, string(data), "upgrading")
+
+	// heartbeats don't bring the service out of maintenance
+	f.UpdateTTL("service:app-1", "ok", "pass")
+	_, isHealthy = f.CheckForUpstreamChanges("app", "", "")
+	assert.False(t, isHealthy)
+
+	assert.NoError(t, f.DisableServiceMaintenance("app-1"))
+	_, isHealthy = f.CheckForUpstreamChanges("app", "", "")
+	assert.True(t, isHealthy)
+
+	assert.Error(t, f.EnableServiceMaintenance("missing", ""))
+	assert.Error(t, f.DisableServiceMaintenance("missing"))
+}
+
+// several ContainerPilots sharing the file shouldn't lose each other's
+// registrations
+func TestFileConcurrentRegistrations(t *testing.T) {
+	f, cleanup := newTestFile(t, "")
+	defer cleanup()
+	other, _ := NewFile(f.path)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			backend := f
+			if i%2 == 1 {
+				backend = other
+			}
+			backend.ServiceRegister(&ServiceRegistration{
+				ID: fmt.Sprintf("app-%d", i), Name: "app"})
+		}(i)
+	}
+	wg.Wait()
+	f.CheckForUpstreamChanges("app", "", "")
+	assert.Equal(t, 10, len(f.ServiceInstances("app")))
+}
+
+func TestFileWaitForUpstreamChanges(t *testing.T) {
+	f, cleanup := newTestFile(t, `{"services": []}`)
+	defer cleanup()
+	ctx := context.Background()
+
+	_, _, index, err := f.WaitForUpstreamChanges(ctx, "app", "", "", 0, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// no change before the wait time
+	start := time.Now()
+	didChange, _, next, err := f.WaitForUpstreamChanges(ctx, "app", "", "",
+		index, 100*time.Millisecond)
+	assert.NoError(t, err)
+	assert.False(t, didChange)
+	assert.Equal(t, index, next)
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		f.ServiceRegister(&ServiceRegistration{ID: "app-1", Name: "app",
+			Check: &ServiceCheck{TTL: "300ms", Status: HealthPassing}})
+	}()
+	start = time.Now()
+	didChange, isHealthy, next, err := f.WaitForUpstreamChanges(ctx, "app", "", "",
+		index, 5*time.Second)
+	assert.NoError(t, err)
+	assert.True(t, didChange)
+	assert.True(t, isHealthy)
+	assert.NotEqual(t, index, next)
+	assert.True(t, time.Since(start) < 5*time.Second)
+
+	// the wait ends when the check expires even though the file hasn't
+	// changed
+	start = time.Now()
+	didChange, isHealthy, _, err = f.WaitForUpstreamChanges(ctx, "app", "", "",
+		next, 5*time.Second)
+	assert.NoError(t, err)
+	assert.True(t, didChange)
+	assert.False(t, isHealthy)
+	assert.True(t, time.Since(start) < 5*time.Second)
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, _, _, err = f.WaitForUpstreamChanges(cctx, "app", "", "", next, time.Second)
+	assert.Error(t, err)
+}
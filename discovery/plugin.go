@@ -0,0 +1,546 @@
+package discovery
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/joyent/containerpilot/commands"
+	"github.com/joyent/containerpilot/config/decode"
+	"github.com/joyent/containerpilot/config/timing"
+	log "github.com/sirupsen/logrus"
+)
+
+// the timeout for requests to a plugin, other than watches which wait
+// for up to their wait time on top of it
+const defaultPluginTimeout = 10 * time.Second
+
+// the largest response line we'll read from a plugin
+const maxPluginLine = 4 * 1024 * 1024
+
+// a plugin that keeps exiting is restarted after a delay that doubles up
+// to the max, until it stays up for the reset time
+const (
+	pluginRestartDelay    = time.Second
+	pluginMaxRestartDelay = 30 * time.Second
+	pluginRestartReset    = time.Minute
+)
+
+// PluginConfig is the object form of the 'plugin' configuration field;
+// it can also be provided as just the exec
+type PluginConfig struct {
+	Exec    interface{} `mapstructure:"exec"`
+	Timeout string      `mapstructure:"timeout"`
+}
+
+// Plugin is a service discovery backend that runs an external program
+// and makes requests to it as lines of JSON over its stdin and stdout.
+// The program is started on the first request and started again on the
+// next request if it exits, after a delay if it keeps exiting. Each
+// request has an ID that the response must have, so that a plugin can
+// answer requests out of order, such as while it waits to answer a watch.
+type Plugin struct {
+	exec    string
+	args    []string
+	timeout time.Duration
+
+	procLock  sync.Mutex
+	proc      *pluginProcess
+	nextID    uint64
+	closed    bool
+	restarts  int       // restarts since the plugin last stayed up
+	restartAt time.Time // when the plugin can be started again
+
+	instanceCache
+}
+
+// pluginProcess is a running plugin program
+type pluginProcess struct {
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	stderr    io.Closer
+	writeLock sync.Mutex
+	started   time.Time
+	exited    time.Time     // set before done is closed
+	done      chan struct{} // closed once the program has exited
+	stopping  chan struct{} // closed when we ask the program to exit
+
+	pendingLock sync.Mutex
+	pending     map[uint64]chan *pluginResponse
+}
+
+type pluginRequest struct {
+	ID     uint64      `json:"id"`
+	Method string      `json:"method"`
+	Params interface{} `json:"params"`
+}
+
+type pluginResponse struct {
+	ID     uint64          `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  string          `json:"error"`
+}
+
+type pluginCheck struct {
+	ID                             string `json:"id,omitempty"`
+	Name                           string `json:"name,omitempty"`
+	Notes                          string `json:"notes,omitempty"`
+	ServiceID                      string `json:"service_id,omitempty"`
+	TTL                            string `json:"ttl,omitempty"`
+	Status                         string `json:"status,omitempty"`
+	DeregisterCriticalServiceAfter string `json:"deregister_critical_service_after,omitempty"`
+}
+
+type pluginService struct {
+	ID                string            `json:"id"`
+	Name              string            `json:"name"`
+	Tags              []string          `json:"tags,omitempty"`
+	Port              int               `json:"port"`
+	Address           string            `json:"address"`
+	EnableTagOverride bool              `json:"enable_tag_override,omitempty"`
+	Meta              map[string]string `json:"meta,omitempty"`
+	Weights           *ServiceWeights   `json:"weights,omitempty"`
+	Check             *pluginCheck      `json:"check,omitempty"`
+}
+
+type pluginInstance struct {
+	ID       string            `json:"id"`
+	Name     string            `json:"name"`
+	Address  string            `json:"address"`
+	Port     int               `json:"port"`
+	Tags     []string          `json:"tags"`
+	Meta     map[string]string `json:"meta"`
+	Node     string            `json:"node"`
+	NodeMeta map[string]string `json:"node_meta"`
+	Checks   map[string]string `json:"checks"`
+}
+
+type pluginInstances struct {
+	Index     uint64            `json:"index"`
+	Instances []*pluginInstance `json:"instances"`
+}
+
+type pluginKVPairs struct {
+	Pairs []struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+		Index uint64 `json:"index"`
+	} `json:"pairs"`
+}
+
+// NewPlugin creates a new service discovery backend for a plugin program
+func NewPlugin(config interface{}) (*Plugin, error) {
+	cfg := &PluginConfig{}
+	switch t := config.(type) {
+	case map[string]interface{}:
+		if err := decode.ToStruct(t, cfg); err != nil {
+			return nil, err
+		}
+	default:
+		cfg.Exec = config
+	}
+	exec, args, err := commands.ParseArgs(cfg.Exec)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse plugin exec: %v", err)
+	}
+	timeout := defaultPluginTimeout
+	if cfg.Timeout != "" {
+		timeout, err = timing.GetTimeout(cfg.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse plugin timeout '%v': %v",
+				cfg.Timeout, err)
+		}
+		if timeout <= 0 {
+			return nil, fmt.Errorf("plugin timeout must be > 0")
+		}
+	}
+	return &Plugin{
+		exec:    exec,
+		args:    args,
+		timeout: timeout,
+	}, nil
+}
+
+// ServiceRegister sends a "register" request with the service
+func (p *Plugin) ServiceRegister(service *ServiceRegistration) error {
+	params := &pluginService{
+		ID:                service.ID,
+		Name:              service.Name,
+		Tags:              service.Tags,
+		Port:              service.Port,
+		Address:           service.Address,
+		EnableTagOverride: service.EnableTagOverride,
+		Meta:              service.Meta,
+		Weights:           service.Weights,
+	}
+	if service.Check != nil {
+		params.Check = &pluginCheck{
+			TTL:                            service.Check.TTL,
+			Status:                         service.Check.Status,
+			Notes:                          service.Check.Notes,
+			DeregisterCriticalServiceAfter: service.Check.DeregisterCriticalServiceAfter,
+		}
+	}
+	return p.call(context.Background(), "register", params, nil, p.timeout)
+}
+
+// CheckRegister sends a "check" request with the check
+func (p *Plugin) CheckRegister(check *CheckRegistration) error {
+	params := &pluginCheck{
+		ID:                             check.ID,
+		Name:                           check.Name,
+		Notes:                          check.Notes,
+		ServiceID:                      check.ServiceID,
+		TTL:                            check.TTL,
+		Status:                         check.Status,
+		DeregisterCriticalServiceAfter: check.DeregisterCriticalServiceAfter,
+	}
+	return p.call(context.Background(), "check", params, nil, p.timeout)
+}
+
+// UpdateTTL sends a "heartbeat" request for the check
+func (p *Plugin) UpdateTTL(checkID, output, status string) error {
+	params := map[string]string{
+		"check_id": checkID,
+		"output":   output,
+		"status":   normalizeStatus(status),
+	}
+	return p.call(context.Background(), "heartbeat", params, nil, p.timeout)
+}
+
+// ServiceDeregister sends a "deregister" request for the service
+func (p *Plugin) ServiceDeregister(serviceID string) error {
+	params := map[string]string{"id": serviceID}
+	return p.call(context.Background(), "deregister", params, nil, p.timeout)
+}
+
+// RegisteredServices sends a "services" request for the IDs of the
+// services registered by the plugin
+func (p *Plugin) RegisteredServices() ([]string, error) {
+	var result struct {
+		Services []string `json:"services"`
+	}
+	if err := p.call(context.Background(), "services", nil, &result, p.timeout); err != nil {
+		return nil, err
+	}
+	sort.Strings(result.Services)
+	return result.Services, nil
+}
+
+// EnableServiceMaintenance sends a "maintenance" request to put the
+// service into maintenance mode with the reason
+func (p *Plugin) EnableServiceMaintenance(serviceID, reason string) error {
+	if reason == "" {
+		reason = defaultMaintenanceReason
+	}
+	params := map[string]interface{}{
+		"id":     serviceID,
+		"enable": true,
+		"reason": reason,
+	}
+	return p.call(context.Background(), "maintenance", params, nil, p.timeout)
+}
+
+// DisableServiceMaintenance sends a "maintenance" request to take the
+// service out of maintenance mode
+func (p *Plugin) DisableServiceMaintenance(serviceID string) error {
+	params := map[string]interface{}{"id": serviceID, "enable": false}
+	return p.call(context.Background(), "maintenance", params, nil, p.timeout)
+}
+
+// CheckForUpstreamChanges sends an "instances" request for the healthy
+// instances of a service and checks whether there has been a change since
+// the last check
+func (p *Plugin) CheckForUpstreamChanges(backendName, backendTag, dc string) (didChange, isHealthy bool) {
+	params := map[string]string{
+		"service": backendName,
+		"tag":     backendTag,
+		"dc":      dc,
+	}
+	result := &pluginInstances{}
+	err := p.call(context.Background(), "instances", params, result, p.timeout)
+	if err != nil {
+		log.Warnf("failed to query %v: %s", backendName, err)
+		return false, false
+	}
+	return p.compareAndSwap(backendName, result.Instances)
+}
+
+// WaitForUpstreamChanges sends a "watch" request, which the plugin
+// answers with the healthy instances of a service once they've changed
+// since waitIndex or after waitTime, and checks whether there has been a
+// change since the last check. Returns the index the plugin answered with
+// to wait on for the next query.
+func (p *Plugin) WaitForUpstreamChanges(ctx context.Context, backendName, backendTag, dc string,
+	waitIndex uint64, waitTime time.Duration) (didChange, isHealthy bool, lastIndex uint64, err error) {
+	params := map[string]interface{}{
+		"service": backendName,
+		"tag":     backendTag,
+		"dc":      dc,
+		"index":   waitIndex,
+		"wait_ms": int64(waitTime / time.Millisecond),
+	}
+	result := &pluginInstances{}
+	if err := p.call(ctx, "watch", params, result, waitTime+p.timeout); err != nil {
+		return false, false, waitIndex, err
+	}
+	didChange, isHealthy = p.compareAndSwap(backendName, result.Instances)
+	return didChange, isHealthy, result.Index, nil
+}
+
+// CheckForKVChanges sends a "kv" request for a key, or all the keys under
+// a prefix if recurse is set, and checks whether any keys have been added,
+// removed, or modified since the last check
+func (p *Plugin) CheckForKVChanges(key string, recurse bool, dc string) (bool, error) {
+	params := map[string]interface{}{
+		"key":     key,
+		"recurse": recurse,
+		"dc":      dc,
+	}
+	result := &pluginKVPairs{}
+	if err := p.call(context.Background(), "kv", params, result, p.timeout); err != nil {
+		return false, err
+	}
+	pairs := make([]*KVPair, 0, len(result.Pairs))
+	for _, pair := range result.Pairs {
+		pairs = append(pairs, &KVPair{
+			Key:         pair.Key,
+			Value:       pair.Value,
+			ModifyIndex: pair.Index,
+		})
+	}
+	return p.swapKVPairs(key, pairs), nil
+}
+
+// Close stops the plugin program by closing its stdin, and kills it if it
+// hasn't exited after the timeout. No more requests can be made after the
+// plugin is closed.
+func (p *Plugin) Close() error {
+	p.procLock.Lock()
+	defer p.procLock.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	proc := p.proc
+	if proc == nil {
+		return nil
+	}
+	close(proc.stopping)
+	proc.stdin.Close()
+	select {
+	case <-proc.done:
+	case <-time.After(p.timeout):
+		log.Warnf("discovery plugin %s didn't exit, killing it", p.exec)
+		syscall.Kill(-proc.cmd.Process.Pid, syscall.SIGKILL)
+		<-proc.done
+	}
+	return nil
+}
+
+// compareAndSwap caches the instances and returns whether they changed
+// and whether there are any
+func (p *Plugin) compareAndSwap(service string, found []*pluginInstance) (didChange, isHealthy bool) {
+	instances := make([]*ServiceInstance, 0, len(found))
+	for _, inst := range found {
+		if inst == nil {
+			continue
+		}
+		instances = append(instances, &ServiceInstance{
+			ID:       inst.ID,
+			Name:     inst.Name,
+			Address:  inst.Address,
+			Port:     inst.Port,
+			Tags:     inst.Tags,
+			Meta:     inst.Meta,
+			Node:     inst.Node,
+			NodeMeta: inst.NodeMeta,
+			Checks:   inst.Checks,
+		})
+	}
+	return p.swapInstances(service, instances)
+}
+
+// call sends a request to the plugin and waits for its response, which is
+// decoded into result if it's not nil
+func (p *Plugin) call(ctx context.Context, method string, params, result interface{},
+	timeout time.Duration) error {
+	proc, id, err := p.process()
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(&pluginRequest{ID: id, Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	respCh := make(chan *pluginResponse, 1)
+	proc.pendingLock.Lock()
+	proc.pending[id] = respCh
+	proc.pendingLock.Unlock()
+	defer func() {
+		proc.pendingLock.Lock()
+		delete(proc.pending, id)
+		proc.pendingLock.Unlock()
+	}()
+
+	proc.writeLock.Lock()
+	_, err = proc.stdin.Write(append(line, '\n'))
+	proc.writeLock.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to send %s request to plugin: %v", method, err)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case resp := <-respCh:
+		if resp.Error != "" {
+			return fmt.Errorf("plugin %s request failed: %s", method, resp.Error)
+		}
+		if result != nil && len(resp.Result) > 0 {
+			if err := json.Unmarshal(resp.Result, result); err != nil {
+				return fmt.Errorf("invalid plugin %s response: %v", method, err)
+			}
+		}
+		return nil
+	case <-proc.done:
+		return fmt.Errorf("plugin exited before answering %s request", method)
+	case <-timer.C:
+		return fmt.Errorf("timeout after %v waiting for plugin %s response",
+			timeout, method)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// process returns the running plugin program, starting it if it hasn't
+// been started or has exited, and the ID for the next request
+func (p *Plugin) process() (*pluginProcess, uint64, error) {
+	p.procLock.Lock()
+	defer p.procLock.Unlock()
+	if p.closed {
+		return nil, 0, fmt.Errorf("plugin has been closed")
+	}
+	p.nextID++
+	if p.proc != nil {
+		select {
+		case <-p.proc.done:
+			p.scheduleRestart(p.proc)
+		default:
+			return p.proc, p.nextID, nil
+		}
+	}
+	if wait := time.Until(p.restartAt); wait > 0 {
+		return nil, 0, fmt.Errorf("discovery plugin %s exited, restarting it in %v",
+			p.exec, wait.Round(time.Millisecond))
+	}
+	proc, err := p.start()
+	if err != nil {
+		return nil, 0, err
+	}
+	p.proc = proc
+	return proc, p.nextID, nil
+}
+
+// scheduleRestart sets when an exited plugin can be started again. The
+// first restart is immediate, and each restart after that waits twice as
+// long as the last, unless the plugin had stayed up for a while. Callers
+// must hold procLock.
+func (p *Plugin) scheduleRestart(proc *pluginProcess) {
+	p.proc = nil
+	if proc.exited.Sub(proc.started) >= pluginRestartReset {
+		p.restarts = 0
+	}
+	delay := time.Duration(0)
+	if p.restarts > 0 {
+		delay = pluginRestartDelay << uint(p.restarts-1)
+		if delay > pluginMaxRestartDelay || delay <= 0 {
+			delay = pluginMaxRestartDelay
+		}
+	}
+	p.restarts++
+	p.restartAt = proc.exited.Add(delay)
+}
+
+// start starts the plugin program, with its stderr going to our logs
+func (p *Plugin) start() (*pluginProcess, error) {
+	cmd := exec.Command(p.exec, p.args...)
+	cmd.Env = os.Environ()
+	stderr := log.WithField("plugin", p.exec).Writer()
+	cmd.Stderr = stderr
+	// the plugin gets its own process group, like jobs, so that we can
+	// kill any children it has
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		stderr.Close()
+		return nil, fmt.Errorf("unable to start discovery plugin: %v", err)
+	}
+	log.Debugf("discovery plugin %s started with pid %d", p.exec, cmd.Process.Pid)
+	proc := &pluginProcess{
+		cmd:      cmd,
+		stdin:    stdin,
+		stderr:   stderr,
+		started:  time.Now(),
+		done:     make(chan struct{}),
+		stopping: make(chan struct{}),
+		pending:  make(map[uint64]chan *pluginResponse),
+	}
+	go proc.read(stdout, p.exec)
+	return proc, nil
+}
+
+// read hands each response from the program to the request waiting for
+// it, until the program closes its stdout
+func (proc *pluginProcess) read(stdout io.Reader, name string) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), maxPluginLine)
+	for scanner.Scan() {
+		resp := &pluginResponse{}
+		if err := json.Unmarshal(scanner.Bytes(), resp); err != nil {
+			log.Warnf("discovery plugin %s sent invalid response: %v", name, err)
+			continue
+		}
+		proc.pendingLock.Lock()
+		respCh, ok := proc.pending[resp.ID]
+		proc.pendingLock.Unlock()
+		if !ok {
+			log.Debugf("discovery plugin %s answered unknown request %d", name, resp.ID)
+			continue
+		}
+		select {
+		case respCh <- resp:
+		default: // the request already has a response
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Warnf("failed to read from discovery plugin %s: %v", name, err)
+		// we can't make sense of the rest of its responses
+		syscall.Kill(-proc.cmd.Process.Pid, syscall.SIGKILL)
+	}
+	err := proc.cmd.Wait()
+	proc.stderr.Close()
+	proc.exited = time.Now()
+	select {
+	case <-proc.stopping:
+		log.Debugf("discovery plugin %s exited: %v", name, err)
+	default:
+		log.Warnf("discovery plugin %s exited unexpectedly: %v", name, err)
+	}
+	close(proc.done)
+}
@@ -0,0 +1,359 @@
+package discovery
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestPlugin(t *testing.T, timeout string) *Plugin {
+	cfg := map[string]interface{}{
+		"exec": []interface{}{os.Args[0], "-test.run=TestPluginHelperProcess",
+			"--", "fake-plugin"},
+	}
+	if timeout != "" {
+		cfg["timeout"] = timeout
+	}
+	p, err := NewPlugin(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return p
+}
+
+func TestPluginConfig(t *testing.T) {
+	p, err := NewPlugin("/bin/registry -v")
+	assert.NoError(t, err)
+	assert.Equal(t, "/bin/registry", p.exec)
+	assert.Equal(t, []string{"-v"}, p.args)
+	assert.Equal(t, defaultPluginTimeout, p.timeout)
+
+	p, err = NewPlugin(map[string]interface{}{
+		"exec": []interface{}{"/bin/registry", "-v"}, "timeout": "3s"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3*time.Second, p.timeout)
+
+	_, err = NewPlugin(map[string]interface{}{"exec": "/bin/registry", "timeout": "x"})
+	assert.Error(t, err)
+	_, err = NewPlugin(map[string]interface{}{"timeout": "1s"})
+	assert.Error(t, err)
+}
+
+func TestPluginRegistration(t *testing.T) {
+	p := newTestPlugin(t, "")
+	defer p.Close()
+
+	err := p.ServiceRegister(&ServiceRegistration{
+		ID: "app-1", Name: "app", Address: "10.0.0.1", Port: 8000,
+		Tags: []string{"dev"}, Meta: map[string]string{"version": "1.2.3"},
+		Check: &ServiceCheck{TTL: "10s"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	didChange, isHealthy := p.CheckForUpstreamChanges("app", "", "")
+	assert.False(t, didChange)
+	assert.False(t, isHealthy)
+
+	if err := p.UpdateTTL("service:app-1", "ok", "pass"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	didChange, isHealthy = p.CheckForUpstreamChanges("app", "", "")
+	assert.True(t, didChange)
+	assert.True(t, isHealthy)
+	instances := p.ServiceInstances("app")
+	if len(instances) != 1 {
+		t.Fatalf("expected 1 instance but got %v", instances)
+	}
+	assert.Equal(t, "10.0.0.1", instances[0].Address)
+	assert.Equal(t, 8000, instances[0].Port)
+	assert.Equal(t, []string{"dev"}, instances[0].Tags)
+	assert.Equal(t, map[string]string{"version": "1.2.3"}, instances[0].Meta)
+
+	ids, err := p.RegisteredServices()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"app-1"}, ids)
+
+	err = p.UpdateTTL("missing", "ok", "pass")
+	assert.EqualError(t, err, `plugin heartbeat request failed: unknown check "missing"`)
+	assert.Equal(t, ErrorUnknownCheck, ClassifyError(err))
+
+	assert.NoError(t, p.ServiceDeregister("app-1"))
+	_, isHealthy = p.CheckForUpstreamChanges("app", "", "")
+	assert.False(t, isHealthy)
+
+	didChange, err = p.CheckForKVChanges("config/", true, "")
+	assert.NoError(t, err)
+	assert.True(t, didChange)
+	assert.Equal(t, []*KVPair{{Key: "config/a", Value: "1", ModifyIndex: 3}},
+		p.KVPairs("config/"))
+}
+
+func TestPluginMaintenance(t *testing.T) {
+	p := newTestPlugin(t, "")
+	defer p.Close()
+
+	p.ServiceRegister(&ServiceRegistration{ID: "app-1", Name: "app",
+		Check: &ServiceCheck{TTL: "10s", Status: HealthPassing}})
+	_, isHealthy := p.CheckForUpstreamChanges("app", "", "")
+	assert.True(t, isHealthy)
+
+	assert.NoError(t, p.EnableServiceMaintenance("app-1", ""))
+	_, isHealthy = p.CheckForUpstreamChanges("app", "", "")
+	assert.False(t, isHealthy)
+
+	assert.NoError(t, p.DisableServiceMaintenance("app-1"))
+	_, isHealthy = p.CheckForUpstreamChanges("app", "", "")
+	assert.True(t, isHealthy)
+
+	err := p.EnableServiceMaintenance("missing", "")
+	assert.EqualError(t, err, `plugin maintenance request failed: unknown service "missing"`)
+}
+
+// the plugin can answer other requests while a watch is waiting
+func TestPluginWaitForUpstreamChanges(t *testing.T) {
+	p := newTestPlugin(t, "")
+	defer p.Close()
+	ctx := context.Background()
+
+	_, _, index, err := p.WaitForUpstreamChanges(ctx, "app", "", "", 0, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	didChange, _, next, err := p.WaitForUpstreamChanges(ctx, "app", "", "",
+		index, 100*time.Millisecond)
+	assert.NoError(t, err)
+	assert.False(t, didChange)
+	assert.Equal(t, index, next)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		p.ServiceRegister(&ServiceRegistration{ID: "app-1", Name: "app",
+			Check: &ServiceCheck{TTL: "10s", Status: HealthPassing}})
+	}()
+	start := time.Now()
+	didChange, isHealthy, next, err := p.WaitForUpstreamChanges(ctx, "app", "", "",
+		index, 5*time.Second)
+	assert.NoError(t, err)
+	assert.True(t, didChange)
+	assert.True(t, isHealthy)
+	assert.True(t, next > index)
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
+
+func TestPluginRestart(t *testing.T) {
+	p := newTestPlugin(t, "200ms")
+	defer p.Close()
+
+	err := p.UpdateTTL("service:app-1", "crash", "pass")
+	assert.EqualError(t, err, "plugin exited before answering heartbeat request")
+
+	// the next request starts the plugin again
+	assert.NoError(t, p.ServiceRegister(&ServiceRegistration{ID: "app-1", Name: "app"}))
+
+	err = p.UpdateTTL("service:app-1", "hang", "pass")
+	assert.EqualError(t, err, "timeout after 200ms waiting for plugin heartbeat response")
+	assert.NoError(t, p.UpdateTTL("service:app-1", "ok", "pass"))
+
+	proc := p.proc
+	assert.NoError(t, p.Close())
+	select {
+	case <-proc.done:
+	default:
+		t.Fatalf("expected plugin to exit after Close")
+	}
+	assert.EqualError(t, p.ServiceDeregister("app-1"), "plugin has been closed")
+}
+
+// a plugin that keeps exiting isn't restarted again right away
+func TestPluginRestartDelay(t *testing.T) {
+	p := newTestPlugin(t, "")
+	defer p.Close()
+
+	p.UpdateTTL("service:app-1", "crash", "pass")
+	assert.NoError(t, p.ServiceRegister(&ServiceRegistration{ID: "app-1", Name: "app"}))
+	p.UpdateTTL("service:app-1", "crash", "pass")
+	err := p.ServiceRegister(&ServiceRegistration{ID: "app-1", Name: "app"})
+	if err == nil || !strings.Contains(err.Error(), "restarting it in") {
+		t.Fatalf("expected plugin restart to be delayed but got: %v", err)
+	}
+	time.Sleep(pluginRestartDelay)
+	assert.NoError(t, p.ServiceRegister(&ServiceRegistration{ID: "app-1", Name: "app"}))
+	assert.Equal(t, 2, p.restarts)
+}
+
+// a service is registered again after the plugin loses it on a restart
+func TestPluginRestartReregisters(t *testing.T) {
+	p := newTestPlugin(t, "")
+	defer p.Close()
+	service := &ServiceDefinition{ID: "app-1", Name: "app", TTL: 10, Discovery: p}
+	repaired, err := service.SendHeartbeat()
+	assert.NoError(t, err)
+	assert.False(t, repaired)
+
+	service.UpdateCheck("", "crash", "pass")
+	repaired, err = service.SendHeartbeat()
+	assert.NoError(t, err)
+	assert.True(t, repaired)
+	_, isHealthy := p.CheckForUpstreamChanges("app", "", "")
+	assert.True(t, isHealthy)
+}
+
+// TestPluginHelperProcess isn't a real test; it's the plugin program for
+// the tests above, which run the test binary as the plugin
+func TestPluginHelperProcess(t *testing.T) {
+	if os.Args[len(os.Args)-1] != "fake-plugin" {
+		return
+	}
+	runFakePlugin()
+	os.Exit(0)
+}
+
+// runFakePlugin serves requests from stdin with an in-memory registry
+// until stdin is closed
+func runFakePlugin() {
+	var lock sync.Mutex
+	changed := sync.NewCond(&lock)
+	index := uint64(1)
+	services := map[string]*pluginService{}
+	statuses := map[string]string{}    // check ID to status
+	maintenance := map[string]string{} // service ID to reason
+	var writeLock sync.Mutex
+	respond := func(id uint64, result interface{}, errMsg string) {
+		writeLock.Lock()
+		defer writeLock.Unlock()
+		line, _ := json.Marshal(map[string]interface{}{
+			"id": id, "result": result, "error": errMsg})
+		fmt.Println(string(line))
+	}
+	instances := func(service, tag string) []*pluginInstance {
+		found := []*pluginInstance{}
+		for _, svc := range services {
+			checkID := "service:" + svc.ID
+			if svc.Name != service || statuses[checkID] != HealthPassing {
+				continue
+			}
+			if _, ok := maintenance[svc.ID]; ok {
+				continue
+			}
+			if tag != "" && !hasTag(svc.Tags, tag) {
+				continue
+			}
+			found = append(found, &pluginInstance{ID: svc.ID, Name: svc.Name,
+				Address: svc.Address, Port: svc.Port, Tags: svc.Tags,
+				Meta: svc.Meta, Checks: map[string]string{checkID: HealthPassing}})
+		}
+		return found
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var req struct {
+			ID     uint64          `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		json.Unmarshal(scanner.Bytes(), &req)
+		var params struct {
+			pluginService
+			CheckID string `json:"check_id"`
+			Output  string `json:"output"`
+			Status  string `json:"status"`
+			Service string `json:"service"`
+			Tag     string `json:"tag"`
+			Index   uint64 `json:"index"`
+			WaitMS  int64  `json:"wait_ms"`
+			Enable  bool   `json:"enable"`
+			Reason  string `json:"reason"`
+		}
+		json.Unmarshal(req.Params, &params)
+
+		lock.Lock()
+		switch req.Method {
+		case "register":
+			svc := params.pluginService
+			services[svc.ID] = &svc
+			status := HealthCritical
+			if svc.Check != nil && svc.Check.Status != "" {
+				status = svc.Check.Status
+			}
+			statuses["service:"+svc.ID] = status
+			index++
+			changed.Broadcast()
+			respond(req.ID, nil, "")
+		case "deregister":
+			delete(services, params.ID)
+			index++
+			changed.Broadcast()
+			respond(req.ID, nil, "")
+		case "heartbeat":
+			switch {
+			case params.Output == "crash":
+				os.Exit(1)
+			case params.Output == "hang":
+			case statuses[params.CheckID] == "":
+				respond(req.ID, nil, fmt.Sprintf("unknown check %q", params.CheckID))
+			default:
+				statuses[params.CheckID] = params.Status
+				index++
+				changed.Broadcast()
+				respond(req.ID, nil, "")
+			}
+		case "maintenance":
+			switch {
+			case services[params.ID] == nil:
+				respond(req.ID, nil, fmt.Sprintf("unknown service %q", params.ID))
+			case params.Enable:
+				maintenance[params.ID] = params.Reason
+				index++
+				changed.Broadcast()
+				respond(req.ID, nil, "")
+			default:
+				delete(maintenance, params.ID)
+				index++
+				changed.Broadcast()
+				respond(req.ID, nil, "")
+			}
+		case "services":
+			ids := []string{}
+			for id := range services {
+				ids = append(ids, id)
+			}
+			respond(req.ID, map[string]interface{}{"services": ids}, "")
+		case "instances":
+			respond(req.ID, map[string]interface{}{
+				"instances": instances(params.Service, params.Tag)}, "")
+		case "watch":
+			go func(id uint64, service, tag string, waitIndex uint64, wait time.Duration) {
+				deadline := time.Now().Add(wait)
+				time.AfterFunc(wait, func() {
+					lock.Lock()
+					changed.Broadcast()
+					lock.Unlock()
+				})
+				lock.Lock()
+				defer lock.Unlock()
+				for index == waitIndex && time.Now().Before(deadline) {
+					changed.Wait()
+				}
+				respond(id, map[string]interface{}{
+					"index": index, "instances": instances(service, tag)}, "")
+			}(req.ID, params.Service, params.Tag, params.Index,
+				time.Duration(params.WaitMS)*time.Millisecond)
+		case "kv":
+			respond(req.ID, map[string]interface{}{"pairs": []map[string]interface{}{
+				{"key": "config/a", "value": "1", "index": 3}}}, "")
+		default:
+			respond(req.ID, nil, "unknown method "+req.Method)
+		}
+		lock.Unlock()
+	}
+}
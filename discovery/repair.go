@@ -0,0 +1,110 @@
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	updateErrorsCollector *prometheus.CounterVec
+	repairsCollector      *prometheus.CounterVec
+)
+
+func init() {
+	updateErrorsCollector = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "containerpilot_service_check_update_errors",
+		Help: "count of failed updates to service TTL checks, partitioned by service and class of error",
+	}, []string{"service", "class"})
+	repairsCollector = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "containerpilot_service_reregistrations",
+		Help: "count of services registered again after the discovery backend lost them, partitioned by service and reason",
+	}, []string{"service", "reason"})
+	prometheus.MustRegister(updateErrorsCollector, repairsCollector)
+}
+
+// The classes of errors returned by a backend's UpdateTTL
+const (
+	ErrorUnknownCheck = "unknown_check" // the check isn't registered
+	ErrorUnavailable  = "unavailable"   // the backend couldn't be reached
+	ErrorOther        = "other"
+)
+
+// The reasons a service is registered again
+const (
+	repairUnknownCheck = ErrorUnknownCheck // a check update was rejected
+	repairMissing      = "missing"         // found missing by Reconcile
+)
+
+// unknownCheckError is returned by backends that keep their own record of
+// the registered checks when a check isn't registered
+type unknownCheckError struct {
+	checkID string
+}
+
+func (err *unknownCheckError) Error() string {
+	return fmt.Sprintf("check %q is not registered", err.checkID)
+}
+
+// the messages Consul and discovery plugins give for checks that aren't
+// registered, as with a Consul agent that has restarted without its state
+var unknownCheckMessage = regexp.MustCompile(
+	`(?i)unknown check|does not have associated TTL|is not registered`)
+
+// ClassifyError returns the class of an error returned by UpdateTTL
+func ClassifyError(err error) string {
+	if err == nil {
+		return ""
+	}
+	if _, ok := err.(*unknownCheckError); ok {
+		return ErrorUnknownCheck
+	}
+	if _, ok := err.(net.Error); ok {
+		return ErrorUnavailable
+	}
+	msg := err.Error()
+	switch {
+	case unknownCheckMessage.MatchString(msg):
+		return ErrorUnknownCheck
+	case strings.Contains(msg, "connection refused"):
+		return ErrorUnavailable
+	}
+	return ErrorOther
+}
+
+// Reconcile compares the services registered with the discovery backend
+// with the service, and registers the service again if the backend has
+// lost it, as when a Consul agent restarts without its state. It returns
+// true if the service was registered again.
+func (service *ServiceDefinition) Reconcile() (bool, error) {
+	if !service.wasRegistered {
+		return false, nil // not registered yet, or deregistered
+	}
+	ids, err := service.Discovery.RegisteredServices()
+	if err != nil {
+		return false, err
+	}
+	for _, id := range ids {
+		if id == service.ID {
+			return false, nil
+		}
+	}
+	log.Warnf("service %s is missing from the discovery backend, registering it again",
+		service.ID)
+	return service.repair(repairMissing, ""), nil
+}
+
+// repair registers the service and its checks again, along with its
+// maintenance mode, and returns true if the registration succeeded
+func (service *ServiceDefinition) repair(reason, status string) bool {
+	service.wasRegistered = false
+	if err := service.register(status); err != nil {
+		return false
+	}
+	repairsCollector.WithLabelValues(service.Name, reason).Inc()
+	return true
+}
@@ -3,11 +3,10 @@ package discovery
 import (
 	"fmt"
 
-	"github.com/hashicorp/consul/api"
 	log "github.com/sirupsen/logrus"
 )
 
-// ServiceDefinition is how a job communicates with the Consul service
+// ServiceDefinition is how a job communicates with the service
 // discovery backend.
 type ServiceDefinition struct {
 	ID                             string
@@ -15,39 +14,104 @@ type ServiceDefinition struct {
 	Port                           int
 	TTL                            int
 	Tags                           []string
+	Meta                           map[string]string
+	Weights                        *ServiceWeights
 	InitialStatus                  string
 	IPAddress                      string
 	EnableTagOverride              bool
 	DeregisterCriticalServiceAfter string
-	Consul                         Backend
+	DeregisterOnMaintenance        bool
+	Checks                         []*CheckRegistration
+	Discovery                      Backend
 
-	wasRegistered bool
+	wasRegistered     bool
+	inMaintenance     bool
+	maintenanceReason string
 }
 
-// Deregister removes the service from Consul.
+// Deregister removes the service from the discovery backend.
 func (service *ServiceDefinition) Deregister() {
 	log.Debugf("deregistering: %s", service.ID)
-	if err := service.Consul.ServiceDeregister(service.ID); err != nil {
+	if err := service.Discovery.ServiceDeregister(service.ID); err != nil {
 		log.Infof("deregistering failed: %s", err)
 	}
+	service.wasRegistered = false
 }
 
-// MarkForMaintenance removes the service from Consul.
-func (service *ServiceDefinition) MarkForMaintenance() {
-	service.Deregister()
+// MarkForMaintenance puts the service into maintenance mode in the
+// discovery backend with the given reason, or removes the service if it's
+// configured to deregister on maintenance.
+func (service *ServiceDefinition) MarkForMaintenance(reason string) {
+	service.inMaintenance = true
+	service.maintenanceReason = reason
+	if service.DeregisterOnMaintenance {
+		service.Deregister()
+		return
+	}
+	log.Debugf("enabling maintenance: %s", service.ID)
+	if err := service.Discovery.EnableServiceMaintenance(service.ID, reason); err != nil {
+		log.Infof("enabling maintenance failed: %s", err)
+	}
 }
 
-// SendHeartbeat writes a TTL check status=ok to the Consul store.
-func (service *ServiceDefinition) SendHeartbeat() error {
-	// Make sure the service is registered.
-	service.register(api.HealthPassing)
+// ClearMaintenance takes the service out of maintenance mode in the
+// discovery backend.
+func (service *ServiceDefinition) ClearMaintenance() {
+	service.inMaintenance = false
+	service.maintenanceReason = ""
+	if service.DeregisterOnMaintenance {
+		return
+	}
+	log.Debugf("disabling maintenance: %s", service.ID)
+	if err := service.Discovery.DisableServiceMaintenance(service.ID); err != nil {
+		log.Infof("disabling maintenance failed: %s", err)
+	}
+}
+
+// SendHeartbeat writes a TTL check status=ok to the discovery backend. It
+// returns true if the service had to be registered again.
+func (service *ServiceDefinition) SendHeartbeat() (bool, error) {
+	return service.UpdateCheck("", "ok", "pass")
+}
 
-	checkID := fmt.Sprintf("service:%s", service.ID)
-	if err := service.Consul.UpdateTTL(checkID, "ok", "pass"); err != nil {
-		log.Warnf("service update TTL failed: %s", err)
+// UpdateCheck writes the status and output of a TTL check to the discovery
+// backend. The checkID is the ID of one of the service's additional checks,
+// or empty for the service's own check. The status is one of the health
+// check statuses or the short forms accepted by UpdateTTL. If the backend
+// doesn't know the check, the service and its checks are registered again
+// and the update retried, and UpdateCheck returns true.
+func (service *ServiceDefinition) UpdateCheck(checkID, output, status string) (bool, error) {
+	// Make sure the service and its checks are registered. A new service
+	// is only registered as passing if its own check is passing.
+	initialStatus := ""
+	if checkID == "" && normalizeStatus(status) == HealthPassing {
+		initialStatus = HealthPassing
 	}
+	service.register(initialStatus)
 
-	return nil
+	if checkID == "" {
+		checkID = serviceCheckID(service.ID)
+	}
+	err := service.Discovery.UpdateTTL(checkID, output, status)
+	if err == nil {
+		return false, nil
+	}
+	class := ClassifyError(err)
+	updateErrorsCollector.WithLabelValues(service.Name, class).Inc()
+	if class != ErrorUnknownCheck || !service.wasRegistered {
+		log.Warnf("check update TTL failed: %s", err)
+		return false, err
+	}
+	log.Warnf("check %s is unknown to the discovery backend, registering service %s again",
+		checkID, service.ID)
+	if !service.repair(repairUnknownCheck, initialStatus) {
+		return false, err
+	}
+	if err := service.Discovery.UpdateTTL(checkID, output, status); err != nil {
+		log.Warnf("check update TTL failed: %s", err)
+		return true, err
+	}
+	return true, nil
 }
 
 // RegisterWithInitialStatus registers the service with its configured initial status.
@@ -60,13 +124,13 @@ func (service *ServiceDefinition) RegisterWithInitialStatus() {
 
 	switch service.InitialStatus {
 		case "passing":
-			status = api.HealthPassing
+			status = HealthPassing
 			break
 		case "warning":
-			status = api.HealthWarning
+			status = HealthWarning
 			break
 		case "critical":
-			status = api.HealthCritical
+			status = HealthCritical
 			break
 	}
 
@@ -75,15 +139,27 @@ func (service *ServiceDefinition) RegisterWithInitialStatus() {
 	service.register(status)
 }
 
-// Register registers the service with the given status in Consul.
+// Register registers the service with the given status in the discovery
+// backend.
 func (service *ServiceDefinition) register(status string) error {
 	if !service.wasRegistered {
 		if err := service.registerService(status); err != nil {
 			log.Warnf("service registration failed: %s", err)
 			return err
 		}
+		if err := service.registerChecks(); err != nil {
+			log.Warnf("check registration failed: %s", err)
+			return err
+		}
 		log.Infof("Service registered: %v", service.Name)
 		service.wasRegistered = true
+		if service.inMaintenance && !service.DeregisterOnMaintenance {
+			// the service was registered again while in maintenance
+			if err := service.Discovery.EnableServiceMaintenance(
+				service.ID, service.maintenanceReason); err != nil {
+				log.Infof("enabling maintenance failed: %s", err)
+			}
+		}
 	}
 
 	return nil
@@ -91,15 +167,17 @@ func (service *ServiceDefinition) register(status string) error {
 
 // registers the service along with a check set to the passing state
 func (service *ServiceDefinition) registerService(status string) error {
-	return service.Consul.ServiceRegister(
-		&api.AgentServiceRegistration{
+	return service.Discovery.ServiceRegister(
+		&ServiceRegistration{
 			ID:                service.ID,
 			Name:              service.Name,
 			Tags:              service.Tags,
 			Port:              service.Port,
 			Address:           service.IPAddress,
 			EnableTagOverride: service.EnableTagOverride,
-			Check: &api.AgentServiceCheck{
+			Meta:              service.Meta,
+			Weights:           service.Weights,
+			Check: &ServiceCheck{
 				TTL:    fmt.Sprintf("%ds", service.TTL),
 				Status: status,
 				Notes:  fmt.Sprintf("TTL for %s set by containerpilot", service.Name),
@@ -108,3 +186,13 @@ func (service *ServiceDefinition) registerService(status string) error {
 		},
 	)
 }
+
+// registers the service's additional checks
+func (service *ServiceDefinition) registerChecks() error {
+	for _, check := range service.Checks {
+		if err := service.Discovery.CheckRegister(check); err != nil {
+			return err
+		}
+	}
+	return nil
+}
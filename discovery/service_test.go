@@ -0,0 +1,125 @@
+package discovery
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceMaintenance(t *testing.T) {
+	f, cleanup := newTestFile(t, "")
+	defer cleanup()
+	service := &ServiceDefinition{ID: "app-1", Name: "app", TTL: 10, Discovery: f}
+	service.SendHeartbeat()
+	_, isHealthy := f.CheckForUpstreamChanges("app", "", "")
+	assert.True(t, isHealthy)
+
+	// the service stays registered while it's in maintenance
+	service.MarkForMaintenance("upgrading")
+	data, _ := f.read()
+	assert.Equal(t, 1, len(data.Services))
+	assert.Equal(t, "upgrading",
+		data.Services[0].Checks[maintenanceCheckID("app-1")].Output)
+	_, isHealthy = f.CheckForUpstreamChanges("app", "", "")
+	assert.False(t, isHealthy)
+
+	service.ClearMaintenance()
+	_, isHealthy = f.CheckForUpstreamChanges("app", "", "")
+	assert.True(t, isHealthy)
+
+	service.DeregisterOnMaintenance = true
+	service.MarkForMaintenance("upgrading")
+	data, _ = f.read()
+	assert.Equal(t, 0, len(data.Services))
+}
+
+func TestServiceUpdateCheck(t *testing.T) {
+	f, cleanup := newTestFile(t, "")
+	defer cleanup()
+	service := &ServiceDefinition{ID: "app-1", Name: "app", TTL: 10, Discovery: f}
+	service.Checks = []*CheckRegistration{{ID: "service:app-1:db", Name: "db",
+		ServiceID: "app-1", ServiceCheck: ServiceCheck{TTL: "10s"}}}
+
+	// a failing check registers the service without marking it passing
+	service.UpdateCheck("", "connection refused", "fail")
+	data, _ := f.read()
+	check := data.Services[0].Checks[serviceCheckID("app-1")]
+	assert.Equal(t, HealthCritical, check.Status)
+	assert.Equal(t, "connection refused", check.Output)
+
+	service.UpdateCheck("service:app-1:db", "slow queries", "warn")
+	data, _ = f.read()
+	check = data.Services[0].Checks["service:app-1:db"]
+	assert.Equal(t, HealthWarning, check.Status)
+	assert.Equal(t, "slow queries", check.Output)
+}
+
+func TestClassifyError(t *testing.T) {
+	assert.Equal(t, "", ClassifyError(nil))
+	assert.Equal(t, ErrorUnknownCheck, ClassifyError(&unknownCheckError{"x"}))
+	assert.Equal(t, ErrorUnknownCheck, ClassifyError(errors.New(
+		`Unexpected response code: 500 (CheckID "service:app-1" does not have associated TTL)`)))
+	assert.Equal(t, ErrorUnknownCheck, ClassifyError(errors.New(
+		`Unexpected response code: 404 (Unknown check "service:app-1")`)))
+	assert.Equal(t, ErrorUnavailable, ClassifyError(errors.New(
+		"dial tcp 127.0.0.1:8500: connect: connection refused")))
+	assert.Equal(t, ErrorUnavailable, ClassifyError(&net.OpError{
+		Op: "dial", Err: errors.New("i/o timeout")}))
+	assert.Equal(t, ErrorOther, ClassifyError(errors.New("permission denied")))
+}
+
+// the service and its checks are registered again when the backend loses
+// them, keeping the service in maintenance
+func TestServiceUpdateCheckReregisters(t *testing.T) {
+	f, cleanup := newTestFile(t, "")
+	defer cleanup()
+	service := &ServiceDefinition{ID: "app-1", Name: "app", TTL: 10, Discovery: f}
+	service.Checks = []*CheckRegistration{{ID: "service:app-1:db", Name: "db",
+		ServiceID: "app-1", ServiceCheck: ServiceCheck{TTL: "10s"}}}
+	repaired, err := service.SendHeartbeat()
+	assert.NoError(t, err)
+	assert.False(t, repaired)
+	service.MarkForMaintenance("upgrading")
+
+	f.ServiceDeregister("app-1")
+	repaired, err = service.UpdateCheck("service:app-1:db", "ok", "pass")
+	assert.NoError(t, err)
+	assert.True(t, repaired)
+	data, _ := f.read()
+	checks := data.Services[0].Checks
+	assert.Equal(t, HealthPassing, checks["service:app-1:db"].Status)
+	assert.Equal(t, "upgrading", checks[maintenanceCheckID("app-1")].Output)
+}
+
+func TestServiceReconcile(t *testing.T) {
+	f, cleanup := newTestFile(t, "")
+	defer cleanup()
+	service := &ServiceDefinition{ID: "app-1", Name: "app", TTL: 10, Discovery: f}
+
+	// nothing to reconcile until the service is registered
+	repaired, err := service.Reconcile()
+	assert.NoError(t, err)
+	assert.False(t, repaired)
+	ids, _ := f.RegisteredServices()
+	assert.Equal(t, []string{}, ids)
+
+	service.SendHeartbeat()
+	repaired, err = service.Reconcile()
+	assert.NoError(t, err)
+	assert.False(t, repaired)
+
+	f.ServiceDeregister("app-1")
+	repaired, err = service.Reconcile()
+	assert.NoError(t, err)
+	assert.True(t, repaired)
+	ids, _ = f.RegisteredServices()
+	assert.Equal(t, []string{"app-1"}, ids)
+
+	// a deregistered service isn't registered again
+	service.Deregister()
+	repaired, err = service.Reconcile()
+	assert.NoError(t, err)
+	assert.False(t, repaired)
+}
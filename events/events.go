@@ -4,6 +4,7 @@ package events
 
 import (
 	"fmt"
+	"sort"
 )
 
 // Event represents a single message in the EventBus
@@ -33,9 +34,15 @@ const (
 	Error
 	Quit
 	Metric
-	Startup  // fired once after events are set up and event loop is started
-	Shutdown // fired once after all jobs exit or on receiving SIGTERM
-	Signal   // fired when a UNIX signal hits a CP process/supervisor
+	Startup      // fired once after events are set up and event loop is started
+	Shutdown     // fired once after all jobs exit or on receiving SIGTERM
+	Signal       // fired when a UNIX signal hits a CP process/supervisor
+	Rendered     // fired when a template writes a changed file
+	BelowMin     // fired when a watch's instance count drops below its min
+	AboveMin     // fired when a watch's instance count reaches its min
+	BelowMax     // fired when a watch's instance count drops back to its max
+	AboveMax     // fired when a watch's instance count goes over its max
+	Reregistered // fired when a job's service is registered again
 )
 
 // global events
@@ -48,39 +55,60 @@ var (
 	QuitByTest             = Event{Code: Quit, Source: "closed"}
 )
 
+// codeNames maps the names used for events in the configuration to
+// their EventCode
+var codeNames = map[string]EventCode{
+	"exitSuccess":      ExitSuccess,
+	"exitFailed":       ExitFailed,
+	"stopping":         Stopping,
+	"stopped":          Stopped,
+	"healthy":          StatusHealthy,
+	"unhealthy":        StatusUnhealthy,
+	"changed":          StatusChanged,
+	"timerExpired":     TimerExpired, // end-users shouldn't use this in configs
+	"enterMaintenance": EnterMaintenance,
+	"exitMaintenance":  ExitMaintenance,
+	"error":            Error, // end-users shouldn't use this in configs
+	"quit":             Quit,  // end-users shouldn't use this in configs
+	"startup":          Startup,
+	"shutdown":         Shutdown,
+	"SIGHUP":           Signal,
+	"SIGUSR2":          Signal,
+	"rendered":         Rendered,
+	"belowMin":         BelowMin,
+	"aboveMin":         AboveMin,
+	"belowMax":         BelowMax,
+	"aboveMax":         AboveMax,
+	"reregistered":     Reregistered,
+}
+
+// internalCodeNames are accepted by FromString but aren't documented
+// for use in configs
+var internalCodeNames = map[string]bool{
+	"timerExpired": true,
+	"error":        true,
+	"quit":         true,
+	"SIGHUP":       true, // signals are configured as a job's 'when.source'
+	"SIGUSR2":      true,
+}
+
 // FromString parses a string as an EventCode enum
 func FromString(codeName string) (EventCode, error) {
-	switch codeName {
-	case "exitSuccess":
-		return ExitSuccess, nil
-	case "exitFailed":
-		return ExitFailed, nil
-	case "stopping":
-		return Stopping, nil
-	case "stopped":
-		return Stopped, nil
-	case "healthy":
-		return StatusHealthy, nil
-	case "unhealthy":
-		return StatusUnhealthy, nil
-	case "changed":
-		return StatusChanged, nil
-	case "timerExpired":
-		return TimerExpired, nil // end-users shouldn't use this in configs
-	case "enterMaintenance":
-		return EnterMaintenance, nil
-	case "exitMaintenance":
-		return ExitMaintenance, nil
-	case "error":
-		return Error, nil // end-users shouldn't use this in configs
-	case "quit":
-		return Quit, nil // end-users shouldn't use this in configs
-	case "startup":
-		return Startup, nil
-	case "shutdown":
-		return Shutdown, nil
-	case "SIGHUP", "SIGUSR2":
-		return Signal, nil
+	if code, ok := codeNames[codeName]; ok {
+		return code, nil
 	}
 	return None, fmt.Errorf("%s is not a valid event code", codeName)
 }
+
+// ConfigNames returns the sorted names of the events that end-users can
+// use in a job's 'when.once' or 'when.each' configuration
+func ConfigNames() []string {
+	names := []string{}
+	for name := range codeNames {
+		if !internalCodeNames[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
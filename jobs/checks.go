@@ -0,0 +1,352 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/joyent/containerpilot/commands"
+	"github.com/joyent/containerpilot/config/timing"
+	"github.com/joyent/containerpilot/discovery"
+	"github.com/joyent/containerpilot/events"
+	log "github.com/sirupsen/logrus"
+)
+
+// rules for aggregating the statuses of a Job's named health checks
+const (
+	aggregateAll = "all" // healthy when all required checks pass
+	aggregateAny = "any" // healthy when any check passes
+)
+
+// how a failed health check is reported to the discovery backend
+const (
+	failureModeImmediate = "immediate" // the check is failed right away
+	failureModeTTL       = "ttl"       // the check's TTL is left to expire
+)
+
+// checkState is the status of a health check after applying its
+// thresholds, so that the status only changes after a number of runs in a
+// row with the same result rather than flapping with each run
+type checkState struct {
+	successes int // runs in a row that passed before the status is passing
+	failures  int // runs in a row that failed before the status is failing
+	passed    int
+	failed    int
+	status    string // "" until the check has settled
+	output    string // the end of the output of the last run
+}
+
+func newCheckState(successes, failures int) checkState {
+	return checkState{successes: successes, failures: failures}
+}
+
+// update records the status and output of a run of the check, and returns
+// the status of the check, which only changes once the threshold for the
+// new status has been met
+func (state *checkState) update(status, output string) string {
+	state.output = output
+	if status == discovery.HealthPassing {
+		state.passed++
+		state.failed = 0
+		if state.passed >= state.successes {
+			state.status = status
+		}
+	} else {
+		state.failed++
+		state.passed = 0
+		if state.failed >= state.failures {
+			state.status = status
+		}
+	}
+	return state.status
+}
+
+func (state *checkState) reset() {
+	state.passed, state.failed = 0, 0
+	state.status, state.output = "", ""
+}
+
+// healthCheck is one of a Job's named health checks. Its state is only
+// touched from the Job's event loop, but is read under the Job's
+// statusLock so that it can be reported elsewhere.
+type healthCheck struct {
+	name       string
+	checkID    string // ID of the check in the discovery backend
+	exec       *commands.Command
+	interval   time.Duration
+	ttl        int
+	optional   bool
+	timerEvent events.Event
+	checkState
+}
+
+// CheckStatus is the status of one of a Job's named health checks and the
+// end of the output of its last run
+type CheckStatus struct {
+	Status string
+	Output string `json:",omitempty"`
+}
+
+func newHealthCheck(cfg *Config, checkCfg *CheckConfig) (*healthCheck, error) {
+	heartbeat := checkCfg.Heartbeat
+	if heartbeat == 0 {
+		heartbeat = cfg.Health.Heartbeat
+	}
+	if heartbeat < 1 {
+		return nil, fmt.Errorf("interval must be > 0")
+	}
+	ttl := checkCfg.TTL
+	if ttl == 0 {
+		ttl = cfg.Health.TTL
+	}
+	if ttl < 1 {
+		return nil, fmt.Errorf("ttl must be > 0")
+	}
+	successes := checkCfg.Successes
+	if successes == 0 {
+		successes = cfg.Health.Successes
+	}
+	if successes < 0 {
+		return nil, fmt.Errorf("successBeforePassing must be >= 0")
+	}
+	failures := checkCfg.Failures
+	if failures == 0 {
+		failures = cfg.Health.Failures
+	}
+	if failures < 0 {
+		return nil, fmt.Errorf("failuresBeforeCritical must be >= 0")
+	}
+	outputLimit := checkCfg.OutputLimit
+	if outputLimit == 0 {
+		outputLimit = cfg.Health.OutputLimit
+	}
+	if outputLimit < 0 {
+		return nil, fmt.Errorf("outputLimit must be >= 0")
+	}
+	interval := time.Duration(heartbeat) * time.Second
+	timeout := interval
+	if checkCfg.CheckTimeout != "" {
+		parsedTimeout, err := timing.GetTimeout(checkCfg.CheckTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse timeout '%s': %v",
+				checkCfg.CheckTimeout, err)
+		}
+		timeout = parsedTimeout
+	}
+
+	execName := fmt.Sprintf("check.%s.%s", cfg.Name, checkCfg.Name)
+	fields := log.Fields{"check": execName}
+	if checkCfg.Logging != nil && checkCfg.Logging.Raw {
+		fields = nil
+	}
+	cmd, err := commands.NewCommand(checkCfg.CheckExec, timeout, fields)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create exec: %v", err)
+	}
+	cmd.Name = execName
+	cmd.OutputLimit = outputLimit
+	return &healthCheck{
+		name:     checkCfg.Name,
+		exec:     cmd,
+		interval: interval,
+		ttl:      ttl,
+		optional: checkCfg.Optional,
+		timerEvent: events.Event{Code: events.TimerExpired,
+			Source: fmt.Sprintf("%s.check.%s", cfg.Name, checkCfg.Name)},
+		checkState: newCheckState(successes, failures),
+	}, nil
+}
+
+// registration is the check to register with the Job's service
+func (check *healthCheck) registration(service *discovery.ServiceDefinition) *discovery.CheckRegistration {
+	check.checkID = fmt.Sprintf("service:%s:%s", service.ID, check.name)
+	return &discovery.CheckRegistration{
+		ID:        check.checkID,
+		Name:      check.name,
+		Notes:     fmt.Sprintf("TTL for %s check %s set by containerpilot", service.Name, check.name),
+		ServiceID: service.ID,
+		ServiceCheck: discovery.ServiceCheck{
+			TTL: fmt.Sprintf("%ds", check.ttl),
+		},
+	}
+}
+
+// CheckStatuses returns the status of each of the Job's named health
+// checks: "passing", "warning", "critical", or "unknown" if it hasn't run
+// yet, along with its output
+func (job *Job) CheckStatuses() map[string]*CheckStatus {
+	job.statusLock.RLock()
+	defer job.statusLock.RUnlock()
+	if len(job.healthChecks) == 0 {
+		return nil
+	}
+	statuses := make(map[string]*CheckStatus, len(job.healthChecks))
+	for _, check := range job.healthChecks {
+		status := check.status
+		if status == "" {
+			status = "unknown"
+		}
+		statuses[check.name] = &CheckStatus{Status: status, Output: check.output}
+	}
+	return statuses
+}
+
+// CheckOutput returns the end of the output of the last run of the Job's
+// health.exec, if it has one
+func (job *Job) CheckOutput() string {
+	job.statusLock.RLock()
+	defer job.statusLock.RUnlock()
+	return job.healthState.output
+}
+
+// checkResult returns the status of a health check exec that has just
+// exited and the end of its output. Any failure is critical, so that the
+// service is taken out of discovery rather than left in it as a warning.
+func checkResult(exec *commands.Command, passed bool) (string, string) {
+	status := discovery.HealthPassing
+	if !passed {
+		status = discovery.HealthCritical
+	}
+	return status, exec.Output()
+}
+
+// processCheckEvent handles the events for the Job's named health checks,
+// and returns false if the event isn't for one of them
+func (job *Job) processCheckEvent(ctx context.Context, event events.Event) bool {
+	for _, check := range job.healthChecks {
+		switch event {
+		case check.timerEvent:
+			status := job.GetStatus()
+			if status != statusMaintenance && status != statusIdle {
+				check.exec.Run(ctx, job.Publisher.Bus)
+			}
+			return true
+		case events.Event{Code: events.ExitSuccess, Source: check.exec.Name}:
+			job.onCheckExit(check, true)
+			return true
+		case events.Event{Code: events.ExitFailed, Source: check.exec.Name}:
+			job.onCheckExit(check, false)
+			return true
+		}
+	}
+	return false
+}
+
+// onCheckExit records the result of one of the named health checks and
+// sends its status to the discovery backend
+func (job *Job) onCheckExit(check *healthCheck, passed bool) {
+	if job.GetStatus() == statusMaintenance {
+		return
+	}
+	status, output := job.updateCheckState(&check.checkState, check.exec, passed)
+	job.reportCheck(check.checkID, status, output)
+	job.updateAggregateStatus()
+}
+
+// updateCheckState records the result of a health check exec that has
+// just exited, and returns the status of the check and its output
+func (job *Job) updateCheckState(state *checkState, exec *commands.Command, passed bool) (string, string) {
+	status, output := checkResult(exec, passed)
+	job.statusLock.Lock()
+	defer job.statusLock.Unlock()
+	return state.update(status, output), output
+}
+
+// reportCheck sends the status of a check to the discovery backend along
+// with its output. A failing check is reported right away unless the Job's
+// failureMode is "ttl", in which case the check is left to expire. The
+// status is only sent once the check has settled.
+func (job *Job) reportCheck(checkID, status, output string) {
+	if job.Service == nil || status == "" {
+		return
+	}
+	if status != discovery.HealthPassing && job.failureMode == failureModeTTL {
+		return
+	}
+	repaired, _ := job.Service.UpdateCheck(checkID, output, status)
+	job.onServiceRepaired(repaired)
+}
+
+func (job *Job) resetCheckStatuses() {
+	job.statusLock.Lock()
+	defer job.statusLock.Unlock()
+	job.healthState.reset()
+	for _, check := range job.healthChecks {
+		check.reset()
+	}
+}
+
+// updateAggregateStatus sets the status of the Job from the statuses of its
+// named health checks, and publishes an event when it changes. The Job's
+// service heartbeat is sent right away when it becomes healthy rather than
+// waiting for the next heartbeat interval.
+func (job *Job) updateAggregateStatus() {
+	status := job.aggregateStatus()
+	if status == statusUnknown || status == job.GetStatus() {
+		return
+	}
+	job.setStatus(status)
+	switch status {
+	case statusHealthy:
+		job.Publish(events.Event{Code: events.StatusHealthy, Source: job.Name})
+		job.SendHeartbeat()
+	case statusUnhealthy:
+		job.Publish(events.Event{Code: events.StatusUnhealthy, Source: job.Name})
+		job.reportCheck("", discovery.HealthCritical, job.failingChecksOutput())
+	}
+}
+
+// failingChecksOutput is the output sent with the Job's service check
+// when its named health checks make it unhealthy
+func (job *Job) failingChecksOutput() string {
+	job.statusLock.RLock()
+	defer job.statusLock.RUnlock()
+	names := []string{}
+	for _, check := range job.healthChecks {
+		if check.status == discovery.HealthCritical {
+			names = append(names, check.name)
+		}
+	}
+	return "failing health checks: " + strings.Join(names, ", ")
+}
+
+// aggregateStatus combines the statuses of the named health checks
+// according to the Job's aggregate rule. With the "all" rule the Job is
+// healthy once every required check has passed and unhealthy as soon as
+// any of them fails; failing optional checks are only reported to the
+// discovery backend. With the "any" rule the Job is healthy while any
+// check passes and unhealthy once they've all failed.
+func (job *Job) aggregateStatus() JobStatus {
+	job.statusLock.RLock()
+	defer job.statusLock.RUnlock()
+	passing, failing, counted := 0, 0, 0
+	for _, check := range job.healthChecks {
+		if check.optional && job.aggregate != aggregateAny {
+			continue
+		}
+		counted++
+		switch check.status {
+		case discovery.HealthPassing:
+			passing++
+		case discovery.HealthCritical:
+			failing++
+		}
+	}
+	if job.aggregate == aggregateAny {
+		switch {
+		case passing > 0:
+			return statusHealthy
+		case failing == counted:
+			return statusUnhealthy
+		}
+		return statusUnknown
+	}
+	switch {
+	case failing > 0:
+		return statusUnhealthy
+	case passing == counted:
+		return statusHealthy
+	}
+	return statusUnknown
+}
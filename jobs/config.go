@@ -17,29 +17,41 @@ import (
 
 const taskMinDuration = time.Millisecond
 
+// the number of bytes at the end of a health check's output that are sent
+// to the discovery backend if health.outputLimit isn't set
+const defaultOutputLimit = 4096
+
+// the interval between checks that a job's service is still registered if
+// consul.reconcileInterval isn't set
+const defaultReconcileInterval = time.Minute
+
 // Config holds the configuration for service discovery data
 type Config struct {
-	Name string      `mapstructure:"name"`
-	Exec interface{} `mapstructure:"exec"`
+	Name string      `mapstructure:"name" schema:"required"`
+	Exec interface{} `mapstructure:"exec" schema:"command"`
 
 	// service discovery
-	Port              int           `mapstructure:"port"`
-	InitialStatus     string        `mapstructure:"initial_status"`
-	Interfaces        interface{}   `mapstructure:"interfaces"`
-	Tags              []string      `mapstructure:"tags"`
-	ConsulExtras      *ConsulExtras `mapstructure:"consul"`
+	Port              int               `mapstructure:"port"`
+	InitialStatus     string            `mapstructure:"initial_status" schema:"enum=passing|warning|critical"`
+	Interfaces        interface{}       `mapstructure:"interfaces" schema:"strings"`
+	Tags              []string          `mapstructure:"tags"`
+	Meta              map[string]string `mapstructure:"meta"`
+	Ports             map[string]int    `mapstructure:"ports"`
+	ConsulExtras      *ConsulExtras     `mapstructure:"consul"`
 	serviceDefinition *discovery.ServiceDefinition
+	reconcileInterval time.Duration
 
 	// health checking
 	Health            *HealthConfig `mapstructure:"health"`
 	healthCheckExec   *commands.Command
+	healthChecks      []*healthCheck
 	heartbeatInterval time.Duration
 	ttl               int
 
 	// timeouts and restarts
-	ExecTimeout     string      `mapstructure:"timeout"`
-	Restarts        interface{} `mapstructure:"restarts"`
-	StopTimeout     string      `mapstructure:"stopTimeout"`
+	ExecTimeout     string      `mapstructure:"timeout" schema:"duration"`
+	Restarts        interface{} `mapstructure:"restarts" schema:"count,enum=unlimited|never"`
+	StopTimeout     string      `mapstructure:"stopTimeout" schema:"duration"`
 	execTimeout     time.Duration
 	exec            *commands.Command
 	stoppingTimeout time.Duration
@@ -60,26 +72,57 @@ type Config struct {
 // WhenConfig determines when a Job runs (dependencies on other Jobs,
 // Watches, or frequency timers)
 type WhenConfig struct {
-	Frequency string `mapstructure:"interval"`
+	Frequency string `mapstructure:"interval" schema:"duration"`
 	Source    string `mapstructure:"source"`
-	Once      string `mapstructure:"once"`
-	Each      string `mapstructure:"each"`
-	Timeout   string `mapstructure:"timeout"`
+	Once      string `mapstructure:"once" schema:"event"`
+	Each      string `mapstructure:"each" schema:"event"`
+	Timeout   string `mapstructure:"timeout" schema:"duration"`
 }
 
 // HealthConfig configures the Job's health checks
 type HealthConfig struct {
-	CheckExec    interface{}    `mapstructure:"exec"`
-	CheckTimeout string         `mapstructure:"timeout"`
+	CheckExec    interface{}    `mapstructure:"exec" schema:"command"`
+	CheckTimeout string         `mapstructure:"timeout" schema:"duration"`
 	Heartbeat    int            `mapstructure:"interval"` // time in seconds
 	TTL          int            `mapstructure:"ttl"`      // time in seconds
+	OutputLimit  int            `mapstructure:"outputLimit"`
+	FailureMode  string         `mapstructure:"failureMode" schema:"enum=immediate|ttl"`
+	Successes    int            `mapstructure:"successBeforePassing"`
+	Failures     int            `mapstructure:"failuresBeforeCritical"`
+	Checks       []*CheckConfig `mapstructure:"checks"`
+	Aggregate    string         `mapstructure:"aggregate" schema:"enum=all|any"`
+	Logging      *LoggingConfig `mapstructure:"logging"`
+}
+
+// CheckConfig configures one of a Job's named health checks, each of which
+// is registered as a separate check of the Job's service
+type CheckConfig struct {
+	Name         string         `mapstructure:"name" schema:"required"`
+	CheckExec    interface{}    `mapstructure:"exec" schema:"required,command"`
+	CheckTimeout string         `mapstructure:"timeout" schema:"duration"`
+	Heartbeat    int            `mapstructure:"interval"` // time in seconds
+	TTL          int            `mapstructure:"ttl"`      // time in seconds
+	OutputLimit  int            `mapstructure:"outputLimit"`
+	Successes    int            `mapstructure:"successBeforePassing"`
+	Failures     int            `mapstructure:"failuresBeforeCritical"`
+	Optional     bool           `mapstructure:"optional"`
 	Logging      *LoggingConfig `mapstructure:"logging"`
 }
 
 // ConsulExtras handles additional Consul configuration.
 type ConsulExtras struct {
-	EnableTagOverride              bool   `mapstructure:"enableTagOverride"`
-	DeregisterCriticalServiceAfter string `mapstructure:"deregisterCriticalServiceAfter"`
+	EnableTagOverride              bool           `mapstructure:"enableTagOverride"`
+	DeregisterCriticalServiceAfter string         `mapstructure:"deregisterCriticalServiceAfter" schema:"duration"`
+	DeregisterOnMaintenance        bool           `mapstructure:"deregisterOnMaintenance"`
+	Weights                        *WeightsConfig `mapstructure:"weights"`
+	ReconcileInterval              string         `mapstructure:"reconcileInterval" schema:"duration"`
+}
+
+// WeightsConfig sets the weights of the service in Consul DNS SRV
+// responses while it's passing or warning
+type WeightsConfig struct {
+	Passing int `mapstructure:"passing"`
+	Warning int `mapstructure:"warning"`
 }
 
 // LoggingConfig handles job-specific logging fields
@@ -87,20 +130,33 @@ type LoggingConfig struct {
 	Raw bool `mapstructure:"raw"`
 }
 
-// NewConfigs parses json config into a validated slice of Configs
+// NewConfigs parses json config into a validated slice of Configs. All
+// jobs are decoded and validated even if an earlier job is invalid, so
+// that every problem is reported at once in a decode.Errors.
 func NewConfigs(raw []interface{}, disc discovery.Backend) ([]*Config, error) {
 	var jobs []*Config
 	if raw == nil {
 		return jobs, nil
 	}
-	if err := decode.ToStruct(raw, &jobs); err != nil {
-		return nil, fmt.Errorf("job configuration error: %v", err)
+	var errs decode.Errors
+	for i, rawJob := range raw {
+		path := fmt.Sprintf("jobs[%d]", i)
+		job := &Config{}
+		if err := decode.ToStruct(rawJob, job); err != nil {
+			errs.Add(path, err)
+			continue
+		}
+		if err := job.Validate(disc); err != nil {
+			errs.Add(path, err)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	if err := errs.ErrorOrNil(); err != nil {
+		return nil, err
 	}
 	stopDependencies := make(map[string]string)
 	for _, job := range jobs {
-		if err := job.Validate(disc); err != nil {
-			return nil, err
-		}
 		if job.whenEvent.Code == events.Stopping {
 			stopDependencies[job.whenEvent.Source] = job.Name
 		}
@@ -307,6 +363,32 @@ func (cfg *Config) validateHealthCheck() error {
 	if cfg.Health.TTL < 1 {
 		return fmt.Errorf("job[%s].health.ttl must be > 0", cfg.Name)
 	}
+	if cfg.Health.OutputLimit < 0 {
+		return fmt.Errorf("job[%s].health.outputLimit must be >= 0", cfg.Name)
+	}
+	if cfg.Health.OutputLimit == 0 {
+		cfg.Health.OutputLimit = defaultOutputLimit
+	}
+	switch cfg.Health.FailureMode {
+	case "":
+		cfg.Health.FailureMode = failureModeImmediate
+	case failureModeImmediate, failureModeTTL:
+	default:
+		return fmt.Errorf("job[%s].health.failureMode must be one of 'immediate' or 'ttl'",
+			cfg.Name)
+	}
+	if cfg.Health.Successes < 0 {
+		return fmt.Errorf("job[%s].health.successBeforePassing must be >= 0", cfg.Name)
+	}
+	if cfg.Health.Failures < 0 {
+		return fmt.Errorf("job[%s].health.failuresBeforeCritical must be >= 0", cfg.Name)
+	}
+	if cfg.Health.Successes == 0 {
+		cfg.Health.Successes = 1
+	}
+	if cfg.Health.Failures == 0 {
+		cfg.Health.Failures = 1
+	}
 
 	cfg.ttl = cfg.Health.TTL
 	cfg.heartbeatInterval = time.Duration(cfg.Health.Heartbeat) * time.Second
@@ -338,8 +420,53 @@ func (cfg *Config) validateHealthCheck() error {
 				cfg.Name, err)
 		}
 		cmd.Name = checkName
+		cmd.OutputLimit = cfg.Health.OutputLimit
 		cfg.healthCheckExec = cmd
 	}
+	return cfg.validateChecks()
+}
+
+// validateChecks validates the named health checks and the rule for
+// aggregating their statuses into the status of the Job
+func (cfg *Config) validateChecks() error {
+	switch cfg.Health.Aggregate {
+	case "", aggregateAll, aggregateAny:
+	default:
+		return fmt.Errorf("job[%s].health.aggregate must be one of 'all' or 'any'",
+			cfg.Name)
+	}
+	if len(cfg.Health.Checks) == 0 {
+		return nil
+	}
+	if cfg.Health.CheckExec != nil {
+		return fmt.Errorf("job[%s].health.exec can't be used along with 'checks'",
+			cfg.Name)
+	}
+	seen := map[string]bool{}
+	required := 0
+	for i, checkCfg := range cfg.Health.Checks {
+		path := fmt.Sprintf("job[%s].health.checks[%d]", cfg.Name, i)
+		if err := services.ValidateName(checkCfg.Name); err != nil {
+			return fmt.Errorf("%s.name: %v", path, err)
+		}
+		if seen[checkCfg.Name] {
+			return fmt.Errorf("%s.name '%s' is used by another check",
+				path, checkCfg.Name)
+		}
+		seen[checkCfg.Name] = true
+		if !checkCfg.Optional {
+			required++
+		}
+		check, err := newHealthCheck(cfg, checkCfg)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		cfg.healthChecks = append(cfg.healthChecks, check)
+	}
+	if required == 0 && cfg.Health.Aggregate != aggregateAny {
+		return fmt.Errorf("job[%s].health.checks must include a check that isn't optional",
+			cfg.Name)
+	}
 	return nil
 }
 
@@ -410,19 +537,48 @@ func (cfg *Config) addDiscoveryConfig(disc discovery.Backend) error {
 	id := fmt.Sprintf("%s-%s", cfg.Name, hostname)
 
 	var (
-		enableTagOverride bool
-		deregAfter        string
+		enableTagOverride  bool
+		deregAfter         string
+		deregOnMaintenance bool
+		weights            *discovery.ServiceWeights
 	)
+	cfg.reconcileInterval = defaultReconcileInterval
 
 	if cfg.ConsulExtras != nil {
 		deregAfter = cfg.ConsulExtras.DeregisterCriticalServiceAfter
-		_, err := time.ParseDuration(deregAfter)
-		if err != nil {
-			return fmt.Errorf(
-				"unable to parse job[%s].consul.deregisterCriticalServiceAfter: %s",
-				cfg.Name, err)
+		if deregAfter != "" {
+			_, err := time.ParseDuration(deregAfter)
+			if err != nil {
+				return fmt.Errorf(
+					"unable to parse job[%s].consul.deregisterCriticalServiceAfter: %s",
+					cfg.Name, err)
+			}
 		}
 		enableTagOverride = cfg.ConsulExtras.EnableTagOverride
+		deregOnMaintenance = cfg.ConsulExtras.DeregisterOnMaintenance
+		if cfg.ConsulExtras.Weights != nil {
+			weights, err = cfg.ConsulExtras.Weights.toServiceWeights(cfg.Name)
+			if err != nil {
+				return err
+			}
+		}
+		if cfg.ConsulExtras.ReconcileInterval != "" {
+			interval, err := timing.GetTimeout(cfg.ConsulExtras.ReconcileInterval)
+			if err != nil {
+				return fmt.Errorf(
+					"unable to parse job[%s].consul.reconcileInterval: %s",
+					cfg.Name, err)
+			}
+			if interval < 0 {
+				return fmt.Errorf("job[%s].consul.reconcileInterval must be >= 0",
+					cfg.Name)
+			}
+			cfg.reconcileInterval = interval
+		}
+	}
+	meta, err := cfg.serviceMeta()
+	if err != nil {
+		return err
 	}
 	cfg.serviceDefinition = &discovery.ServiceDefinition{
 		ID:                             id,
@@ -430,15 +586,60 @@ func (cfg *Config) addDiscoveryConfig(disc discovery.Backend) error {
 		Port:                           cfg.Port,
 		TTL:                            cfg.ttl,
 		Tags:                           cfg.Tags,
+		Meta:                           meta,
+		Weights:                        weights,
 		InitialStatus:                  cfg.InitialStatus,
 		IPAddress:                      ipAddress,
 		DeregisterCriticalServiceAfter: deregAfter,
 		EnableTagOverride:              enableTagOverride,
-		Consul:                         disc,
+		DeregisterOnMaintenance:        deregOnMaintenance,
+		Discovery:                      disc,
+	}
+	for _, check := range cfg.healthChecks {
+		cfg.serviceDefinition.Checks = append(cfg.serviceDefinition.Checks,
+			check.registration(cfg.serviceDefinition))
 	}
 	return nil
 }
 
+// serviceMeta validates the service metadata and adds the named ports to
+// it as "<name>_port" keys
+func (cfg *Config) serviceMeta() (map[string]string, error) {
+	if len(cfg.Meta) == 0 && len(cfg.Ports) == 0 {
+		return nil, nil
+	}
+	meta := make(map[string]string, len(cfg.Meta)+len(cfg.Ports))
+	for key, value := range cfg.Meta {
+		meta[key] = value
+	}
+	for name, port := range cfg.Ports {
+		if port < 1 || port > 65535 {
+			return nil, fmt.Errorf("job[%s].ports.%s must be between 1 and 65535",
+				cfg.Name, name)
+		}
+		key := name + "_port"
+		if _, ok := meta[key]; ok {
+			return nil, fmt.Errorf("job[%s].ports.%s conflicts with job[%s].meta.%s",
+				cfg.Name, name, cfg.Name, key)
+		}
+		meta[key] = strconv.Itoa(port)
+	}
+	if err := services.ValidateMeta(meta); err != nil {
+		return nil, fmt.Errorf("job[%s].meta: %v", cfg.Name, err)
+	}
+	return meta, nil
+}
+
+func (w *WeightsConfig) toServiceWeights(name string) (*discovery.ServiceWeights, error) {
+	if w.Passing < 1 {
+		return nil, fmt.Errorf("job[%s].consul.weights.passing must be at least 1", name)
+	}
+	if w.Warning < 0 {
+		return nil, fmt.Errorf("job[%s].consul.weights.warning must not be negative", name)
+	}
+	return &discovery.ServiceWeights{Passing: w.Passing, Warning: w.Warning}, nil
+}
+
 // String implements the stdlib fmt.Stringer interface for pretty-printing
 func (cfg *Config) String() string {
 	return "jobs.Config[" + cfg.Name + "]"
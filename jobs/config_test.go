@@ -8,6 +8,8 @@ import (
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/joyent/containerpilot/config/decode"
+	"github.com/joyent/containerpilot/discovery"
 	"github.com/joyent/containerpilot/events"
 	"github.com/joyent/containerpilot/tests"
 	"github.com/joyent/containerpilot/tests/mocks"
@@ -160,6 +162,17 @@ func TestJobConfigConsulExtras(t *testing.T) {
 		"10m", "config for job.ConsulExtras.DeregisterCriticalServiceAfter")
 	assert.True(job.ConsulExtras.EnableTagOverride,
 		"config for job.ConsulExtras.EnableTagOverride")
+	assert.True(job.serviceDefinition.DeregisterOnMaintenance,
+		"config for job.ConsulExtras.DeregisterOnMaintenance")
+	assert.Equal(30*time.Second, job.reconcileInterval,
+		"config for job.ConsulExtras.ReconcileInterval")
+	assert.Equal(&discovery.ServiceWeights{Passing: 10, Warning: 1},
+		job.serviceDefinition.Weights, "config for job.ConsulExtras.Weights")
+	assert.Equal(map[string]string{
+		"version":      "1.2.3",
+		"zone":         "us-east-1a",
+		"metrics_port": "9090",
+	}, job.serviceDefinition.Meta, "config for job.Meta and job.Ports")
 	assert.Nil(job.Restarts, "config for job.Restarts") // this the parsed value only
 	assert.Equal(job.restartLimit, 0, "config.for job.restartLimit")
 }
@@ -300,6 +313,121 @@ func TestErrJobConfigConsulDeregisterCriticalServiceAfter(t *testing.T) {
 	}
 }
 
+func TestErrJobConfigConsulReconcileInterval(t *testing.T) {
+	expectErr := func(interval, errMsg string) {
+		testCfg := tests.DecodeRawToSlice(fmt.Sprintf(`[{name: "svc", port: 80,
+			health: {exec: "true", interval: 1, ttl: 5},
+			consul: {reconcileInterval: "%s"}}]`, interval))
+		_, err := NewConfigs(testCfg, noop)
+		if err == nil {
+			t.Fatalf("expected error %q but got nil", errMsg)
+		}
+		assert.Contains(t, err.Error(), errMsg)
+	}
+	expectErr("nope", "unable to parse job[svc].consul.reconcileInterval")
+	expectErr("-1s", "job[svc].consul.reconcileInterval must be >= 0")
+
+	cfgs, err := NewConfigs(tests.DecodeRawToSlice(`[{name: "svc", port: 80,
+		health: {exec: "true", interval: 1, ttl: 5}}]`), noop)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, defaultReconcileInterval, cfgs[0].reconcileInterval)
+}
+
+func TestErrJobConfigServiceMeta(t *testing.T) {
+	expectErr := func(fields, errMsg string) {
+		testCfg := tests.DecodeRawToSlice(fmt.Sprintf(`[{name: "svc", port: 80,
+			health: {exec: "true", interval: 1, ttl: 5}, %s}]`, fields))
+		_, err := NewConfigs(testCfg, noop)
+		if err == nil {
+			t.Fatalf("expected error %q but got nil", errMsg)
+		}
+		assert.Contains(t, err.Error(), errMsg)
+	}
+	expectErr(`meta: {"a.b": "x"}`,
+		"job[svc].meta: service meta key 'a.b' must be alphanumeric")
+	expectErr(`ports: {admin: 0}`,
+		"job[svc].ports.admin must be between 1 and 65535")
+	expectErr(`meta: {admin_port: "1"}, ports: {admin: 81}`,
+		"job[svc].ports.admin conflicts with job[svc].meta.admin_port")
+	expectErr(`consul: {weights: {warning: 1}}`,
+		"job[svc].consul.weights.passing must be at least 1")
+}
+
+func TestJobConfigHealthChecks(t *testing.T) {
+	testCfg := tests.DecodeRawToSlice(`[{
+		name: "svc", port: 80,
+		health: {
+			interval: 5, ttl: 10,
+			checks: [
+				{name: "web", exec: "curl localhost"},
+				{name: "disk", exec: "df", interval: 30, ttl: 60, timeout: "10s", optional: true}
+			]
+		}
+	}]`)
+	jobs, err := NewConfigs(testCfg, noop)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	job := jobs[0]
+	assert.Equal(t, 2, len(job.healthChecks))
+	disk := job.healthChecks[1]
+	assert.Equal(t, "check.svc.disk", disk.exec.Name)
+	assert.Equal(t, 30*time.Second, disk.interval)
+	assert.Equal(t, 10*time.Second, disk.exec.Timeout)
+	assert.True(t, disk.optional)
+	assert.Equal(t, events.Event{events.TimerExpired, "svc.check.disk"}, disk.timerEvent)
+
+	service := job.serviceDefinition
+	assert.Equal(t, 2, len(service.Checks))
+	assert.Equal(t, &discovery.CheckRegistration{
+		ID:           "service:" + service.ID + ":disk",
+		Name:         "disk",
+		Notes:        "TTL for svc check disk set by containerpilot",
+		ServiceID:    service.ID,
+		ServiceCheck: discovery.ServiceCheck{TTL: "60s"},
+	}, service.Checks[1])
+	assert.Equal(t, "10s", service.Checks[0].TTL)
+	assert.Equal(t, service.Checks[1].ID, disk.checkID)
+}
+
+func TestErrJobConfigHealthChecks(t *testing.T) {
+	expectErr := func(health, errMsg string) {
+		testCfg := tests.DecodeRawToSlice(fmt.Sprintf(
+			`[{name: "svc", port: 80, health: {interval: 1, ttl: 5, %s}}]`, health))
+		_, err := NewConfigs(testCfg, noop)
+		if err == nil {
+			t.Fatalf("expected error %q but got nil", errMsg)
+		}
+		assert.Contains(t, err.Error(), errMsg)
+	}
+	expectErr(`exec: "true", checks: [{name: "web", exec: "true"}]`,
+		"job[svc].health.exec can't be used along with 'checks'")
+	expectErr(`checks: [{name: "web", exec: "true"}, {name: "web", exec: "true"}]`,
+		"job[svc].health.checks[1].name 'web' is used by another check")
+	expectErr(`checks: [{name: "Web", exec: "true"}]`,
+		"job[svc].health.checks[0].name: service names must be alphanumeric")
+	expectErr(`checks: [{name: "web", exec: "true", timeout: "x"}]`,
+		"job[svc].health.checks[0]: could not parse timeout 'x'")
+	expectErr(`checks: [{name: "web", exec: "true", optional: true}]`,
+		"job[svc].health.checks must include a check that isn't optional")
+	expectErr(`aggregate: "most", checks: [{name: "web", exec: "true"}]`,
+		"job[svc].health.aggregate must be one of 'all' or 'any'")
+	expectErr(`outputLimit: -1, exec: "true"`,
+		"job[svc].health.outputLimit must be >= 0")
+	expectErr(`checks: [{name: "web", exec: "true", outputLimit: -1}]`,
+		"job[svc].health.checks[0]: outputLimit must be >= 0")
+	expectErr(`failureMode: "never", exec: "true"`,
+		"job[svc].health.failureMode must be one of 'immediate' or 'ttl'")
+	expectErr(`successBeforePassing: -1, exec: "true"`,
+		"job[svc].health.successBeforePassing must be >= 0")
+	expectErr(`failuresBeforeCritical: -1, exec: "true"`,
+		"job[svc].health.failuresBeforeCritical must be >= 0")
+	expectErr(`checks: [{name: "web", exec: "true", failuresBeforeCritical: -1}]`,
+		"job[svc].health.checks[0]: failuresBeforeCritical must be >= 0")
+}
+
 func TestJobConfigValidateFrequency(t *testing.T) {
 	expectErr := func(test, errMsg string) {
 		testCfg := tests.DecodeRawToSlice(test)
@@ -379,7 +507,7 @@ func TestJobConfigValidateExec(t *testing.T) {
 		timeout: "xx"
 	}]`)
 	_, err = NewConfigs(testCfg, noop)
-	expected := "unable to parse job[serviceC].timeout 'xx': time: invalid duration xx"
+	expected := "jobs[0]: unable to parse job[serviceC].timeout 'xx': time: invalid duration xx"
 	if err == nil || err.Error() != expected {
 		t.Fatalf("expected '%s', got '%v'", expected, err)
 	}
@@ -390,17 +518,37 @@ func TestJobConfigValidateExec(t *testing.T) {
 		exec: ""
 	}]`)
 	_, err = NewConfigs(testCfg, noop)
-	expected = "unable to create job[serviceD].exec: received zero-length argument"
+	expected = "jobs[0]: unable to create job[serviceD].exec: received zero-length argument"
 	if err == nil || err.Error() != expected {
 		t.Fatalf("expected '%s', got '%v'", expected, err)
 	}
 
 }
 
+func TestJobConfigAllErrors(t *testing.T) {
+	// every invalid job should be reported, including unknown nested keys
+	testCfg := tests.DecodeRawToSlice(`[
+	{name: "A", exec: "/bin/A", helath: {exec: "/bin/true"}},
+	{name: "B", exec: "/bin/B"},
+	{name: "C", exec: "/bin/C", when: {interval: "1s", intervall: "2s"}},
+	{name: "D", exec: "/bin/D", restarts: "invalid"}]`)
+	_, err := NewConfigs(testCfg, nil)
+	errs, ok := err.(decode.Errors)
+	if !ok {
+		t.Fatalf("expected decode.Errors but got %T: %v", err, err)
+	}
+	assert.Equal(t, len(errs), 3, "number of errors")
+	assert.Equal(t, errs[0].Path, "jobs[0].helath")
+	assert.Equal(t, errs[1].Path, "jobs[2].when.intervall")
+	assert.Equal(t, errs[2].Path, "jobs[3]")
+	assert.Equal(t, errs[2].Message,
+		`job[D].restarts field 'invalid' invalid: accepts positive integers, "unlimited", or "never"`)
+}
+
 func TestJobConfigValidateRestarts(t *testing.T) {
 
 	expectErr := func(test, name, val, msg string) {
-		errMsg := fmt.Sprintf(`job[%s].restarts field '%s' invalid: %s`, name, val, msg)
+		errMsg := fmt.Sprintf(`jobs[0]: job[%s].restarts field '%s' invalid: %s`, name, val, msg)
 		testCfg := tests.DecodeRawToSlice(test)
 		_, err := NewConfigs(testCfg, nil)
 		assert.Equal(t, err.Error(), errMsg)
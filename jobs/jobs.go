@@ -23,6 +23,10 @@ const (
 	eventBufferSize                    = 1000
 )
 
+// maintenanceReasonEnv is the environment variable that passes the reason
+// for maintenance mode to jobs started by entering maintenance mode
+const maintenanceReasonEnv = "CONTAINERPILOT_MAINTENANCE_REASON"
+
 // Job manages the state of a job and its start/stop conditions
 type Job struct {
 	Name string
@@ -34,6 +38,10 @@ type Job struct {
 	Service         *discovery.ServiceDefinition
 	healthCheckExec *commands.Command
 	healthCheckName string
+	healthState     checkState
+	healthChecks    []*healthCheck
+	aggregate       string
+	failureMode     string
 
 	// starting events
 	startEvent        events.Event
@@ -47,10 +55,17 @@ type Job struct {
 
 	// timing and restarts
 	heartbeat      time.Duration
+	reconcile      time.Duration
 	restartLimit   int
 	restartsRemain int
 	frequency      time.Duration
 
+	// MaintenanceReason returns the reason given when maintenance mode
+	// was enabled, which is passed to the discovery backend and to the
+	// job's exec if it's started by entering maintenance mode
+	MaintenanceReason func() string
+	maintenanceEnv    []string
+
 	// completed
 	IsComplete   bool
 	completeLock *sync.RWMutex
@@ -67,6 +82,7 @@ func NewJob(cfg *Config) *Job {
 		heartbeat:         cfg.heartbeatInterval,
 		Service:           cfg.serviceDefinition,
 		healthCheckExec:   cfg.healthCheckExec,
+		healthChecks:      cfg.healthChecks,
 		startEvent:        cfg.whenEvent,
 		startTimeout:      cfg.whenTimeout,
 		startsRemain:      cfg.whenStartsLimit,
@@ -75,10 +91,17 @@ func NewJob(cfg *Config) *Job {
 		restartLimit:      cfg.restartLimit,
 		restartsRemain:    cfg.restartLimit,
 		frequency:         cfg.freqInterval,
+		reconcile:         cfg.reconcileInterval,
 	}
 	job.statusLock = &sync.RWMutex{}
 	job.completeLock = &sync.RWMutex{}
 	job.Rx = make(chan events.Event, eventBufferSize)
+	if cfg.Health != nil {
+		job.aggregate = cfg.Health.Aggregate
+		job.failureMode = cfg.Health.FailureMode
+		job.healthState = newCheckState(
+			cfg.Health.Successes, cfg.Health.Failures)
+	}
 	if job.Name == "containerpilot" {
 		// right now this hardcodes the telemetry service to
 		// be always "healthy", but maybe we want to have it verify itself
@@ -101,7 +124,16 @@ func FromConfigs(cfgs []*Config) []*Job {
 // SendHeartbeat sends a heartbeat for this Job's service
 func (job *Job) SendHeartbeat() {
 	if job.Service != nil {
-		job.Service.SendHeartbeat()
+		repaired, _ := job.Service.SendHeartbeat()
+		job.onServiceRepaired(repaired)
+	}
+}
+
+// onServiceRepaired publishes an event when the Job's service had to be
+// registered again because the discovery backend lost it
+func (job *Job) onServiceRepaired(repaired bool) {
+	if repaired {
+		job.Publish(events.Event{Code: events.Reregistered, Source: job.Name})
 	}
 }
 
@@ -152,6 +184,13 @@ func (job *Job) Run(pctx context.Context, completedCh chan struct{}) {
 		events.NewEventTimer(ctx, job.Rx, job.heartbeat,
 			fmt.Sprintf("%s.heartbeat", job.Name))
 	}
+	if job.Service != nil && job.reconcile > 0 {
+		events.NewEventTimer(ctx, job.Rx, job.reconcile,
+			fmt.Sprintf("%s.reconcile", job.Name))
+	}
+	for _, check := range job.healthChecks {
+		events.NewEventTimer(ctx, job.Rx, check.interval, check.timerEvent.Source)
+	}
 	if job.startTimeout > 0 {
 		timeoutName := fmt.Sprintf("%s.wait-timeout", job.Name)
 		events.NewEventTimeout(ctx, job.Rx, job.startTimeout, timeoutName)
@@ -187,16 +226,23 @@ func (job *Job) Run(pctx context.Context, completedCh chan struct{}) {
 func (job *Job) processEvent(ctx context.Context, event events.Event) processEventStatus {
 	runEverySource := fmt.Sprintf("%s.run-every", job.Name)
 	heartbeatSource := fmt.Sprintf("%s.heartbeat", job.Name)
+	reconcileSource := fmt.Sprintf("%s.reconcile", job.Name)
 	healthCheckName := fmt.Sprintf("check.%s", job.Name)
 	if job.healthCheckExec != nil {
 		healthCheckName = job.healthCheckExec.Name
 	}
+	if job.processCheckEvent(ctx, event) {
+		return jobContinue
+	}
 
 	switch event {
 
 	case events.Event{Code: events.TimerExpired, Source: heartbeatSource}:
 		return job.onHeartbeatTimerExpired(ctx)
 
+	case events.Event{Code: events.TimerExpired, Source: reconcileSource}:
+		return job.onReconcileTimerExpired(ctx)
+
 	case job.startTimeoutEvent:
 		return job.onStartTimeoutExpired(ctx)
 
@@ -238,7 +284,7 @@ func (job *Job) startJobExec(ctx context.Context) {
 	job.startTimeoutEvent = events.NonEvent
 	job.setStatus(statusUnknown)
 	if job.exec != nil {
-		job.exec.Run(ctx, job.Publisher.Bus)
+		job.exec.RunWithEnv(ctx, job.Publisher.Bus, job.maintenanceEnv)
 	}
 }
 
@@ -247,6 +293,12 @@ func (job *Job) onHeartbeatTimerExpired(ctx context.Context) processEventStatus
 	if status != statusMaintenance && status != statusIdle {
 		if job.healthCheckExec != nil {
 			job.healthCheckExec.Run(ctx, job.Publisher.Bus)
+		} else if len(job.healthChecks) > 0 {
+			// the named checks run on their own intervals, and the
+			// service heartbeat follows their aggregate status
+			if status == statusHealthy {
+				job.SendHeartbeat()
+			}
 		} else if job.Service != nil {
 			// this is the case for non-checked but advertised
 			// services like the telemetry endpoint
@@ -256,6 +308,27 @@ func (job *Job) onHeartbeatTimerExpired(ctx context.Context) processEventStatus
 	return jobContinue
 }
 
+// onReconcileTimerExpired checks that the discovery backend still has the
+// Job's service, and registers it again if the backend has lost it, as
+// when a Consul agent restarts without its state. A service that's
+// registered again while in maintenance stays in maintenance.
+func (job *Job) onReconcileTimerExpired(ctx context.Context) processEventStatus {
+	status := job.GetStatus()
+	if status == statusIdle {
+		return jobContinue
+	}
+	repaired, err := job.Service.Reconcile()
+	if err != nil {
+		log.Debugf("unable to reconcile service %s: %v", job.Name, err)
+		return jobContinue
+	}
+	job.onServiceRepaired(repaired)
+	if repaired && (status == statusHealthy || status == statusAlwaysHealthy) {
+		job.SendHeartbeat()
+	}
+	return jobContinue
+}
+
 func (job *Job) onStartTimeoutExpired(ctx context.Context) processEventStatus {
 	job.Publish(events.Event{
 		Code: events.TimerExpired, Source: job.Name})
@@ -276,19 +349,33 @@ func (job *Job) onRunEveryTimerExpired(ctx context.Context) processEventStatus {
 }
 
 func (job *Job) onHealthCheckFailed(ctx context.Context) processEventStatus {
-	if job.GetStatus() != statusMaintenance {
-		job.setStatus(statusUnhealthy)
-		job.Publish(events.Event{events.StatusUnhealthy, job.Name})
-	}
-	return jobContinue
+	return job.onHealthCheckExit(false)
 }
 
 func (job *Job) onHealthCheckPassed(ctx context.Context) processEventStatus {
-	if job.GetStatus() != statusMaintenance {
+	return job.onHealthCheckExit(true)
+}
+
+// onHealthCheckExit records the result of the Job's health.exec and sends
+// its status to the discovery backend. The Job's status follows the status
+// of the check once it has settled.
+func (job *Job) onHealthCheckExit(passed bool) processEventStatus {
+	if job.GetStatus() == statusMaintenance {
+		return jobContinue
+	}
+	status, output := job.updateCheckState(
+		&job.healthState, job.healthCheckExec, passed)
+	switch status {
+	case "":
+		// waiting for the check to settle
+	case discovery.HealthPassing:
 		job.setStatus(statusHealthy)
 		job.Publish(events.Event{events.StatusHealthy, job.Name})
-		job.SendHeartbeat()
+	default:
+		job.setStatus(statusUnhealthy)
+		job.Publish(events.Event{events.StatusUnhealthy, job.Name})
 	}
+	job.reportCheck("", status, output)
 	return jobContinue
 }
 
@@ -313,8 +400,13 @@ func (job *Job) onQuit(ctx context.Context) processEventStatus {
 
 func (job *Job) onEnterMaintenance(ctx context.Context) processEventStatus {
 	job.setStatus(statusMaintenance)
+	reason := ""
+	if job.MaintenanceReason != nil {
+		reason = job.MaintenanceReason()
+	}
+	job.maintenanceEnv = []string{maintenanceReasonEnv + "=" + reason}
 	if job.Service != nil {
-		job.Service.MarkForMaintenance()
+		job.Service.MarkForMaintenance(reason)
 	}
 	if job.startEvent == events.GlobalEnterMaintenance {
 		return job.onStartEvent(ctx)
@@ -324,6 +416,11 @@ func (job *Job) onEnterMaintenance(ctx context.Context) processEventStatus {
 
 func (job *Job) onExitMaintenance(ctx context.Context) processEventStatus {
 	job.setStatus(statusUnknown)
+	job.maintenanceEnv = nil
+	job.resetCheckStatuses()
+	if job.Service != nil {
+		job.Service.ClearMaintenance()
+	}
 	if job.startEvent == events.GlobalExitMaintenance {
 		return job.onStartEvent(ctx)
 	}
@@ -2,6 +2,7 @@ package jobs
 
 import (
 	"context"
+	"fmt"
 	"reflect"
 	"sync"
 	"testing"
@@ -9,7 +10,10 @@ import (
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/joyent/containerpilot/commands"
+	"github.com/joyent/containerpilot/discovery"
 	"github.com/joyent/containerpilot/events"
+	"github.com/joyent/containerpilot/tests/mocks"
 )
 
 func TestJobRunSafeClose(t *testing.T) {
@@ -268,6 +272,37 @@ func TestJobMaintenance(t *testing.T) {
 	})
 }
 
+func TestJobMaintenanceReason(t *testing.T) {
+	bus := events.NewEventBus()
+	stopCh := make(chan struct{}, 1)
+	cfg := &Config{
+		Name: "myjob",
+		Exec: []string{"sh", "-c",
+			`test "$CONTAINERPILOT_MAINTENANCE_REASON" = upgrading`},
+		When: &WhenConfig{Source: "global", Once: "enterMaintenance"},
+	}
+	cfg.Validate(noop)
+	job := NewJob(cfg)
+	job.MaintenanceReason = func() string { return "upgrading" }
+	job.Subscribe(bus)
+	job.Register(bus)
+	ctx, cancel := context.WithCancel(context.Background())
+	job.Run(ctx, stopCh)
+	bus.Publish(events.GlobalEnterMaintenance)
+	time.Sleep(500 * time.Millisecond)
+	cancel()
+	bus.Wait()
+
+	got := map[events.Event]int{}
+	for _, event := range bus.DebugEvents() {
+		got[event]++
+	}
+	exitOk := events.Event{Code: events.ExitSuccess, Source: "myjob"}
+	if got[exitOk] != 1 {
+		t.Fatalf("expected job to see the maintenance reason but got %v", got)
+	}
+}
+
 func TestJobProcessEvent(t *testing.T) {
 
 	t.Run("start once with no restarts", func(t *testing.T) {
@@ -468,3 +503,325 @@ func TestJobProcessEvent(t *testing.T) {
 	})
 
 }
+
+func TestJobNamedHealthChecks(t *testing.T) {
+	newTestJob := func(aggregate string) (*Job, *events.EventBus) {
+		bus := events.NewEventBus()
+		cfg := &Config{
+			Name: "myjob",
+			Exec: "true",
+			Health: &HealthConfig{
+				Heartbeat: 10,
+				TTL:       50,
+				Aggregate: aggregate,
+				Checks: []*CheckConfig{
+					{Name: "web", CheckExec: "true"},
+					{Name: "db", CheckExec: "true", Heartbeat: 5},
+					{Name: "disk", CheckExec: "true", Optional: true},
+				},
+			},
+		}
+		if err := cfg.Validate(noop); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		job := NewJob(cfg)
+		job.setStatus(statusUnknown)
+		job.Subscribe(bus)
+		job.Register(bus)
+		return job, bus
+	}
+	checkEvent := func(code events.EventCode, check string) events.Event {
+		return events.Event{Code: code, Source: "check.myjob." + check}
+	}
+	ctx := context.Background()
+
+	t.Run("all", func(t *testing.T) {
+		job, bus := newTestJob("")
+		assert.Equal(t, 5*time.Second, job.healthChecks[1].interval)
+		job.processEvent(ctx, checkEvent(events.ExitSuccess, "web"))
+		assert.Equal(t, statusUnknown, job.GetStatus(), "status before all checks pass")
+		job.processEvent(ctx, checkEvent(events.ExitFailed, "disk"))
+		job.processEvent(ctx, checkEvent(events.ExitSuccess, "db"))
+		assert.Equal(t, statusHealthy, job.GetStatus(), "status with failed optional check")
+		job.processEvent(ctx, checkEvent(events.ExitSuccess, "db"))
+		job.processEvent(ctx, checkEvent(events.ExitFailed, "web"))
+		assert.Equal(t, statusUnhealthy, job.GetStatus(), "status with failed check")
+		assert.Equal(t, map[string]*CheckStatus{
+			"web":  {Status: "critical"},
+			"db":   {Status: "passing"},
+			"disk": {Status: "critical"}},
+			job.CheckStatuses())
+
+		// status events are only published when the status changes
+		assert.Equal(t, []events.Event{
+			{Code: events.StatusHealthy, Source: "myjob"},
+			{Code: events.StatusUnhealthy, Source: "myjob"},
+		}, bus.DebugEvents())
+	})
+
+	t.Run("any", func(t *testing.T) {
+		job, _ := newTestJob("any")
+		job.processEvent(ctx, checkEvent(events.ExitFailed, "web"))
+		job.processEvent(ctx, checkEvent(events.ExitSuccess, "disk"))
+		assert.Equal(t, statusHealthy, job.GetStatus(), "status with one passing check")
+		job.processEvent(ctx, checkEvent(events.ExitFailed, "disk"))
+		assert.Equal(t, statusHealthy, job.GetStatus(), "status with unknown check")
+		job.processEvent(ctx, checkEvent(events.ExitFailed, "db"))
+		assert.Equal(t, statusUnhealthy, job.GetStatus(), "status with all checks failed")
+	})
+
+	t.Run("maintenance", func(t *testing.T) {
+		job, _ := newTestJob("")
+		job.processEvent(ctx, events.GlobalEnterMaintenance)
+		job.processEvent(ctx, checkEvent(events.ExitFailed, "web"))
+		assert.Equal(t, statusMaintenance, job.GetStatus())
+		assert.Equal(t, "unknown", job.CheckStatuses()["web"].Status)
+	})
+}
+
+// ttlRecorder is a discovery backend that records the TTL check updates
+type ttlRecorder struct {
+	mocks.NoopDiscoveryBackend
+	updates []string
+}
+
+func (r *ttlRecorder) UpdateTTL(checkID, output, status string) error {
+	r.updates = append(r.updates, fmt.Sprintf("%s %s: %s", checkID, status, output))
+	return nil
+}
+
+func TestJobHealthCheckOutput(t *testing.T) {
+	// runs the check exec and passes its exit event to the job
+	runCheck := func(job *Job, exec *commands.Command) {
+		bus := events.NewEventBus()
+		sub := &events.Subscriber{Rx: make(chan events.Event, 10)}
+		sub.Subscribe(bus)
+		defer sub.Unsubscribe()
+		exec.Run(context.Background(), bus)
+		timeout := time.After(time.Second)
+		for {
+			select {
+			case event := <-sub.Rx:
+				if event.Source == exec.Name {
+					job.processEvent(context.Background(), event)
+					return
+				}
+			case <-timeout:
+				t.Fatalf("timed out waiting for %s", exec.Name)
+			}
+		}
+	}
+	newTestJob := func(health *HealthConfig) (*Job, *ttlRecorder) {
+		cfg := &Config{Name: "myjob", Exec: "true", Health: health}
+		if err := cfg.Validate(noop); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		job := NewJob(cfg)
+		job.setStatus(statusUnknown)
+		job.Register(events.NewEventBus())
+		backend := &ttlRecorder{}
+		job.Service = &discovery.ServiceDefinition{
+			ID: "myjob-1", Name: "myjob", TTL: 50, Discovery: backend}
+		return job, backend
+	}
+
+	t.Run("exec", func(t *testing.T) {
+		job, backend := newTestJob(&HealthConfig{
+			CheckExec: []string{"sh", "-c", "echo slow; exit 1"},
+			Heartbeat: 10,
+			TTL:       50,
+			Logging:   &LoggingConfig{Raw: true},
+		})
+		runCheck(job, job.healthCheckExec)
+		assert.Equal(t, statusUnhealthy, job.GetStatus(), "status after exit 1")
+		job.healthCheckExec.Args = []string{"-c", "echo down; exit 2"}
+		runCheck(job, job.healthCheckExec)
+		job.healthCheckExec.Args = []string{"-c", "echo up"}
+		runCheck(job, job.healthCheckExec)
+		assert.Equal(t, []string{
+			"service:myjob-1 critical: slow",
+			"service:myjob-1 critical: down",
+			"service:myjob-1 passing: up",
+		}, backend.updates)
+		assert.Equal(t, "up", job.CheckOutput())
+	})
+
+	t.Run("named checks", func(t *testing.T) {
+		job, backend := newTestJob(&HealthConfig{
+			Heartbeat:   10,
+			TTL:         50,
+			OutputLimit: 4,
+			Checks: []*CheckConfig{
+				{Name: "db", CheckExec: []string{"sh", "-c", "echo ok; exit 0"},
+					Logging: &LoggingConfig{Raw: true}},
+				{Name: "web", CheckExec: []string{"sh", "-c", "echo refused; exit 2"},
+					OutputLimit: 100, Logging: &LoggingConfig{Raw: true}},
+			},
+		})
+		job.Service.Checks = []*discovery.CheckRegistration{
+			job.healthChecks[0].registration(job.Service),
+			job.healthChecks[1].registration(job.Service),
+		}
+		runCheck(job, job.healthChecks[0].exec)
+		runCheck(job, job.healthChecks[1].exec)
+		assert.Equal(t, []string{
+			"service:myjob-1:db passing: ok",
+			"service:myjob-1:web critical: refused",
+			"service:myjob-1 critical: failing health checks: web",
+		}, backend.updates)
+		assert.Equal(t, map[string]*CheckStatus{
+			"db":  {Status: "passing", Output: "ok"},
+			"web": {Status: "critical", Output: "refused"}},
+			job.CheckStatuses())
+	})
+
+	t.Run("named checks exit 1", func(t *testing.T) {
+		job, backend := newTestJob(&HealthConfig{
+			Heartbeat: 10,
+			TTL:       50,
+			Aggregate: "any",
+			Checks: []*CheckConfig{
+				{Name: "db", CheckExec: []string{"sh", "-c", "echo slow; exit 1"},
+					Logging: &LoggingConfig{Raw: true}},
+				{Name: "web", CheckExec: []string{"sh", "-c", "echo slow; exit 1"},
+					Logging: &LoggingConfig{Raw: true}},
+			},
+		})
+		job.Service.Checks = []*discovery.CheckRegistration{
+			job.healthChecks[0].registration(job.Service),
+			job.healthChecks[1].registration(job.Service),
+		}
+		runCheck(job, job.healthChecks[0].exec)
+		runCheck(job, job.healthChecks[1].exec)
+		assert.Equal(t, statusUnhealthy, job.GetStatus(),
+			"status after all checks exit 1")
+		assert.Equal(t, []string{
+			"service:myjob-1:db critical: slow",
+			"service:myjob-1:web critical: slow",
+			"service:myjob-1 critical: failing health checks: db, web",
+		}, backend.updates)
+	})
+}
+
+func TestJobHealthCheckThresholds(t *testing.T) {
+	newTestJob := func(health *HealthConfig) (*Job, *ttlRecorder) {
+		cfg := &Config{Name: "myjob", Exec: "true", Health: health}
+		if err := cfg.Validate(noop); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		job := NewJob(cfg)
+		job.setStatus(statusUnknown)
+		job.Register(events.NewEventBus())
+		backend := &ttlRecorder{}
+		job.Service = &discovery.ServiceDefinition{
+			ID: "myjob-1", Name: "myjob", TTL: 50, Discovery: backend}
+		return job, backend
+	}
+	ctx := context.Background()
+	passed := events.Event{Code: events.ExitSuccess, Source: "check.myjob"}
+	failed := events.Event{Code: events.ExitFailed, Source: "check.myjob"}
+
+	t.Run("exec", func(t *testing.T) {
+		job, backend := newTestJob(&HealthConfig{CheckExec: "true",
+			Heartbeat: 10, TTL: 50, Successes: 2, Failures: 3})
+		job.processEvent(ctx, passed)
+		assert.Equal(t, statusUnknown, job.GetStatus(), "status after one pass")
+		job.processEvent(ctx, passed)
+		assert.Equal(t, statusHealthy, job.GetStatus(), "status after two passes")
+		job.processEvent(ctx, failed)
+		job.processEvent(ctx, failed)
+		job.processEvent(ctx, passed)
+		job.processEvent(ctx, failed)
+		job.processEvent(ctx, failed)
+		assert.Equal(t, statusHealthy, job.GetStatus(), "status after flapping")
+		job.processEvent(ctx, failed)
+		assert.Equal(t, statusUnhealthy, job.GetStatus(), "status after three failures")
+		job.processEvent(ctx, passed)
+		assert.Equal(t, statusUnhealthy, job.GetStatus(), "status after one pass")
+
+		// the last settled status is sent while the check is flapping
+		assert.Equal(t, []string{
+			"service:myjob-1 passing: ", // 2 passes
+			"service:myjob-1 passing: ", // 1 failure
+			"service:myjob-1 passing: ", // 2 failures
+			"service:myjob-1 passing: ", // 1 pass
+			"service:myjob-1 passing: ", // 1 failure
+			"service:myjob-1 passing: ", // 2 failures
+			"service:myjob-1 critical: ",
+			"service:myjob-1 critical: ",
+		}, backend.updates)
+	})
+
+	t.Run("ttl failure mode", func(t *testing.T) {
+		job, backend := newTestJob(&HealthConfig{CheckExec: "true",
+			Heartbeat: 10, TTL: 50, FailureMode: "ttl"})
+		job.processEvent(ctx, passed)
+		job.processEvent(ctx, failed)
+		assert.Equal(t, statusUnhealthy, job.GetStatus())
+		assert.Equal(t, []string{"service:myjob-1 passing: "}, backend.updates)
+	})
+
+	t.Run("named checks", func(t *testing.T) {
+		job, _ := newTestJob(&HealthConfig{Heartbeat: 10, TTL: 50, Failures: 2,
+			Checks: []*CheckConfig{
+				{Name: "web", CheckExec: "true"},
+				{Name: "db", CheckExec: "true", Failures: 1},
+			}})
+		checkEvent := func(code events.EventCode, check string) events.Event {
+			return events.Event{Code: code, Source: "check.myjob." + check}
+		}
+		job.processEvent(ctx, checkEvent(events.ExitSuccess, "web"))
+		job.processEvent(ctx, checkEvent(events.ExitSuccess, "db"))
+		assert.Equal(t, statusHealthy, job.GetStatus())
+		job.processEvent(ctx, checkEvent(events.ExitFailed, "web"))
+		assert.Equal(t, statusHealthy, job.GetStatus(), "status after web fails once")
+		assert.Equal(t, "passing", job.CheckStatuses()["web"].Status)
+		job.processEvent(ctx, checkEvent(events.ExitFailed, "db"))
+		assert.Equal(t, statusUnhealthy, job.GetStatus(), "status after db fails once")
+	})
+}
+
+func TestJobReconcile(t *testing.T) {
+	cfg := &Config{Name: "myjob", Exec: "true",
+		Health: &HealthConfig{CheckExec: "true", Heartbeat: 10, TTL: 50}}
+	if err := cfg.Validate(noop); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	job := NewJob(cfg)
+	bus := events.NewEventBus()
+	job.Register(bus)
+	sub := &events.Subscriber{Rx: make(chan events.Event, 10)}
+	sub.Subscribe(bus)
+	defer sub.Unsubscribe()
+	// the recorder has no registered services, as though the backend
+	// lost them
+	backend := &ttlRecorder{}
+	job.Service = &discovery.ServiceDefinition{
+		ID: "myjob-1", Name: "myjob", TTL: 50, Discovery: backend}
+	ctx := context.Background()
+	reconcile := events.Event{Code: events.TimerExpired, Source: "myjob.reconcile"}
+
+	// nothing to reconcile until the service has been registered
+	job.setStatus(statusUnknown)
+	job.processEvent(ctx, reconcile)
+	assert.Empty(t, backend.updates)
+
+	job.processEvent(ctx, events.Event{Code: events.ExitSuccess, Source: "check.myjob"})
+	job.processEvent(ctx, reconcile)
+	assert.Equal(t, []string{
+		"service:myjob-1 passing: ",
+		"service:myjob-1 pass: ok",
+	}, backend.updates, "heartbeat sent after the service is registered again")
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case event := <-sub.Rx:
+			if event.Code == events.Reregistered {
+				assert.Equal(t, "myjob", event.Source)
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for reregistered event")
+		}
+	}
+}
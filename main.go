@@ -36,7 +36,7 @@ func main() {
 		return
 	}
 
-	app, configErr := core.NewApp(params.ConfigPath)
+	app, configErr := core.NewApp(params.ConfigPath, params.ConfigFormat)
 	if configErr != nil {
 		log.Fatal(configErr)
 	}
@@ -5,9 +5,13 @@ package subcommands
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
 
 	"github.com/joyent/containerpilot/client"
 	"github.com/joyent/containerpilot/config"
+	"github.com/joyent/containerpilot/config/decode"
+	log "github.com/sirupsen/logrus"
 )
 
 // Params ...
@@ -15,9 +19,15 @@ type Params struct {
 	Version string
 	GitHash string
 
-	ConfigPath      string
-	RenderFlag      string
-	MaintenanceFlag string
+	ConfigPath        string
+	ConfigFormat      string
+	RenderFlag        string
+	MaintenanceFlag   string
+	MaintenanceReason string
+	ValidateFormat    string
+
+	// the trailing arguments, which were the application command in v2
+	Command []string
 
 	Metrics map[string]string
 	Env     map[string]string
@@ -35,12 +45,79 @@ func VersionHandler(params Params) error {
 // RenderHandler asks the configuration package to render the
 // configuration to the path provided
 func RenderHandler(params Params) error {
-	return config.RenderConfig(params.ConfigPath, params.RenderFlag)
+	return config.RenderConfig(params.ConfigPath, params.ConfigFormat, params.RenderFlag)
+}
+
+// ConvertHandler converts a v2 configuration file to the v3 format and
+// writes it to the path provided, logging a warning for anything that
+// couldn't be converted
+func ConvertHandler(params Params) error {
+	warnings, err := config.ConvertConfig(
+		params.ConfigPath, params.Command, params.RenderFlag)
+	for _, warning := range warnings {
+		log.Warnf("-convert: %s", warning)
+	}
+	return err
+}
+
+// SchemaHandler prints the JSON Schema for the configuration file
+func SchemaHandler(params Params) error {
+	body, err := json.MarshalIndent(config.Schema(), "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(body))
+	return nil
+}
+
+// ValidateHandler loads and validates the configuration without running
+// anything or contacting the discovery backend, and prints every problem
+// found as either text or JSON (per the ValidateFormat param). Returns an
+// error if the configuration is invalid so that we exit non-zero.
+func ValidateHandler(params Params) error {
+	return validate(os.Stdout, params)
+}
+
+func validate(out io.Writer, params Params) error {
+	var errs decode.Errors
+	if _, err := config.LoadConfig(params.ConfigPath, params.ConfigFormat); err != nil {
+		errs.Add("", err)
+	}
+	switch params.ValidateFormat {
+	case "json":
+		result := struct {
+			Valid  bool          `json:"valid"`
+			Errors decode.Errors `json:"errors"`
+		}{len(errs) == 0, errs}
+		if result.Errors == nil {
+			result.Errors = decode.Errors{}
+		}
+		body, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, string(body))
+	case "text", "":
+		if len(errs) == 0 {
+			fmt.Fprintf(out, "%s: configuration is valid\n", params.ConfigPath)
+		}
+		for _, err := range errs {
+			fmt.Fprintln(out, err.Error())
+		}
+	default:
+		return fmt.Errorf(
+			"-validate-format must be one of 'text' or 'json', got '%s'",
+			params.ValidateFormat)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("-validate: found %d configuration error(s)", len(errs))
+	}
+	return nil
 }
 
 // ReloadHandler fires a Reload request through the HTTPClient.
 func ReloadHandler(params Params) error {
-	client, err := initClient(params.ConfigPath)
+	client, err := initClient(params.ConfigPath, params.ConfigFormat)
 	if err != nil {
 		return err
 	}
@@ -53,7 +130,7 @@ func ReloadHandler(params Params) error {
 // MaintenanceHandler fires either an enable or disable SetMaintenance
 // request through the HTTPClient.
 func MaintenanceHandler(params Params) error {
-	client, err := initClient(params.ConfigPath)
+	client, err := initClient(params.ConfigPath, params.ConfigFormat)
 	if err != nil {
 		return err
 	}
@@ -61,7 +138,7 @@ func MaintenanceHandler(params Params) error {
 	if params.MaintenanceFlag == "enable" {
 		flag = true
 	}
-	if err := client.SetMaintenance(flag); err != nil {
+	if err := client.SetMaintenance(flag, params.MaintenanceReason); err != nil {
 		return fmt.Errorf("-maintenance: failed to run subcommand: %v", err)
 	}
 	return nil
@@ -69,7 +146,7 @@ func MaintenanceHandler(params Params) error {
 
 // PutEnvHandler fires a PutEnv request through the HTTPClient.
 func PutEnvHandler(params Params) error {
-	client, err := initClient(params.ConfigPath)
+	client, err := initClient(params.ConfigPath, params.ConfigFormat)
 	if err != nil {
 		return err
 	}
@@ -85,7 +162,7 @@ func PutEnvHandler(params Params) error {
 
 // PutMetricsHandler fires a PutMetric request through the HTTPClient.
 func PutMetricsHandler(params Params) error {
-	client, err := initClient(params.ConfigPath)
+	client, err := initClient(params.ConfigPath, params.ConfigFormat)
 	if err != nil {
 		return err
 	}
@@ -101,7 +178,7 @@ func PutMetricsHandler(params Params) error {
 
 // GetPingHandler fires a ping check through the HTTPClient.
 func GetPingHandler(params Params) error {
-	client, err := initClient(params.ConfigPath)
+	client, err := initClient(params.ConfigPath, params.ConfigFormat)
 	if err != nil {
 		return err
 	}
@@ -115,8 +192,8 @@ func GetPingHandler(params Params) error {
 // loads the configuration so we can get the control socket and
 // initializes the HTTPClient which callers will use for sending
 // it commands
-func initClient(configPath string) (*client.HTTPClient, error) {
-	cfg, err := config.LoadConfig(configPath)
+func initClient(configPath, configFormat string) (*client.HTTPClient, error) {
+	cfg, err := config.LoadConfig(configPath, configFormat)
 	if err != nil {
 		return nil, err
 	}
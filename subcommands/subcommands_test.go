@@ -0,0 +1,90 @@
+package subcommands
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestValidateText(t *testing.T) {
+	f := testCfgToTempFile(t, `{consul: "consul:8500", jobs: [{name: "a", exec: "/bin/a"}]}`)
+	defer os.Remove(f.Name())
+
+	var out bytes.Buffer
+	if err := validate(&out, Params{ConfigPath: f.Name()}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := f.Name() + ": configuration is valid\n"
+	if out.String() != expected {
+		t.Fatalf("expected %q but got %q", expected, out.String())
+	}
+}
+
+func TestValidateInvalidJSON(t *testing.T) {
+	f := testCfgToTempFile(t, `{
+	consul: "consul:8500",
+	jobs: [
+		{name: "a", exec: "/bin/a", helath: {}},
+		{name: "b", exec: "/bin/b", health: {intervall: 1}},
+	],
+	watches: [{name: "c"}],
+	bogus: true
+}`)
+	defer os.Remove(f.Name())
+
+	var out bytes.Buffer
+	err := validate(&out, Params{ConfigPath: f.Name(), ValidateFormat: "json"})
+	if err == nil || err.Error() != "-validate: found 4 configuration error(s)" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var result struct {
+		Valid  bool
+		Errors []struct{ Path, Message string }
+	}
+	if err := json.Unmarshal(out.Bytes(), &result); err != nil {
+		t.Fatalf("could not parse JSON output %q: %v", out.String(), err)
+	}
+	if result.Valid {
+		t.Fatalf("expected invalid result but got: %s", out.String())
+	}
+	paths := []string{}
+	for _, e := range result.Errors {
+		paths = append(paths, e.Path)
+	}
+	expected := []string{
+		"bogus",
+		"jobs[0].helath",
+		"jobs[1].health.intervall",
+		"watches[0]",
+	}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("expected errors at %v but got %s", expected, out.String())
+	}
+}
+
+func TestValidateBadFormat(t *testing.T) {
+	var out bytes.Buffer
+	err := validate(&out, Params{ConfigPath: "/xxxx", ValidateFormat: "xml"})
+	expected := "-validate-format must be one of 'text' or 'json', got 'xml'"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected %q but got %v", expected, err)
+	}
+}
+
+func testCfgToTempFile(t *testing.T, text string) *os.File {
+	f, err := ioutil.TempFile(".", "test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte(text)); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
@@ -12,9 +12,9 @@ import (
 type MetricConfig struct {
 	Namespace string `mapstructure:"namespace"`
 	Subsystem string `mapstructure:"subsystem"`
-	Name      string `mapstructure:"name"`
+	Name      string `mapstructure:"name" schema:"required"`
 	Help      string `mapstructure:"help"` // help string returned by API
-	Type      string `mapstructure:"type"`
+	Type      string `mapstructure:"type" schema:"required,enum=counter|gauge|histogram|summary"`
 
 	fullName   string // combined name
 	metricType MetricType
@@ -24,15 +24,17 @@ type MetricConfig struct {
 // NewMetricConfigs creates new metrics from a raw config
 func NewMetricConfigs(raw []interface{}) ([]*MetricConfig, error) {
 	var metrics []*MetricConfig
+	var errs decode.Errors
 	if err := decode.ToStruct(raw, &metrics); err != nil {
-		return nil, fmt.Errorf("MetricConfig configuration error: %v", err)
+		errs.Add("metrics", err)
+		return nil, errs
 	}
-	for _, metric := range metrics {
+	for i, metric := range metrics {
 		if err := metric.Validate(); err != nil {
-			return metrics, err
+			errs.Add(fmt.Sprintf("metrics[%d]", i), err)
 		}
 	}
-	return metrics, nil
+	return metrics, errs.ErrorOrNil()
 }
 
 // Validate ensures Metric meets all requirements
@@ -23,6 +23,8 @@ type Status struct {
 type jobStatusResponse struct {
 	Name   string
 	Status string
+	Output string                       `json:",omitempty"`
+	Checks map[string]*jobs.CheckStatus `json:",omitempty"`
 }
 
 type serviceStatusResponse struct {
@@ -30,6 +32,8 @@ type serviceStatusResponse struct {
 	Address string
 	Port    int
 	Status  string
+	Output  string                       `json:",omitempty"`
+	Checks  map[string]*jobs.CheckStatus `json:",omitempty"`
 }
 
 // StatusHandler implements http.Handler
@@ -52,14 +56,20 @@ func (sh StatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	for _, job := range sh.telem.Status.jobs {
 		status := fmt.Sprintf("%s", job.GetStatus())
+		output := job.CheckOutput()
+		checks := job.CheckStatuses()
 		for _, service := range sh.telem.Status.Services {
 			if service.Name == job.Name {
 				service.Status = status
+				service.Output = output
+				service.Checks = checks
 			}
 		}
 		for _, jobStatus := range sh.telem.Status.Jobs {
 			if jobStatus.Name == job.Name {
 				jobStatus.Status = status
+				jobStatus.Output = output
+				jobStatus.Checks = checks
 			}
 		}
 	}
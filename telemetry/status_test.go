@@ -64,6 +64,15 @@ func TestStatusServerGet(t *testing.T) {
 					  interval: 1,
 					  ttl: 10
 					}
+				},
+				{
+					name: "myjob4",
+					exec: "sleep 10",
+					health: {
+					  interval: 1,
+					  ttl: 10,
+					  checks: [{name: "db", exec: "true"}]
+					}
 				}
 			]`),
 		noop)
@@ -115,9 +124,15 @@ func TestStatusServerGet(t *testing.T) {
 	assert.Equal(t, 1, len(out.Services), "unexpected count of services")
 	assert.Equal(t, 80, out.Services[0].Port, "unexpected job port")
 	assert.Equal(t, "unknown", out.Services[0].Status, "unexpected job status")
-	assert.Equal(t, 2, len(out.Jobs), "unexpected count of services")
+	assert.Equal(t, 3, len(out.Jobs), "unexpected count of services")
 	assert.Equal(t, "myjob1", out.Jobs[0].Name)
 	assert.Equal(t, "unknown", out.Jobs[0].Status, "unexpected job status")
 	assert.Equal(t, "myjob3", out.Jobs[1].Name)
 	assert.Equal(t, "unknown", out.Jobs[1].Status, "unexpected job status")
+	assert.Nil(t, out.Jobs[1].Checks, "unexpected job check statuses")
+	assert.Equal(t, "myjob4", out.Jobs[2].Name)
+	assert.Equal(t, "unknown", out.Jobs[2].Status, "unexpected job status")
+	assert.Equal(t, 1, len(out.Jobs[2].Checks), "unexpected count of job checks")
+	assert.Equal(t, "unknown", out.Jobs[2].Checks["db"].Status,
+		"unexpected job check statuses")
 }
@@ -15,7 +15,7 @@ import (
 // endpoint, and the collection of Metrics.
 type Config struct {
 	Port       int           `mapstructure:"port"`
-	Interfaces []interface{} `mapstructure:"interfaces"` // optional override
+	Interfaces []interface{} `mapstructure:"interfaces" schema:"strings"` // optional override
 	Tags       []string      `mapstructure:"tags"`
 	Metrics    []interface{} `mapstructure:"metrics"`
 
@@ -33,7 +33,7 @@ func NewConfig(raw interface{}, disc discovery.Backend) (*Config, error) {
 	}
 	cfg := &Config{Port: 9090} // default values
 	if err := decode.ToStruct(raw, cfg); err != nil {
-		return nil, fmt.Errorf("telemetry configuration error: %v", err)
+		return nil, err
 	}
 	if err := cfg.Validate(disc); err != nil {
 		return nil, fmt.Errorf("telemetry validation error: %v", err)
@@ -0,0 +1,204 @@
+package templates
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/joyent/containerpilot/config/decode"
+	"github.com/joyent/containerpilot/config/services"
+	"github.com/joyent/containerpilot/config/template"
+	"github.com/joyent/containerpilot/discovery"
+	"github.com/joyent/containerpilot/events"
+)
+
+const defaultMode os.FileMode = 0644
+
+// Config configures a template that's rendered to a file on startup and
+// whenever one of the watches it depends on changes
+type Config struct {
+	Name        string   `mapstructure:"name" schema:"required"`
+	Source      string   `mapstructure:"source" schema:"required"`
+	Destination string   `mapstructure:"destination" schema:"required"`
+	Mode        string   `mapstructure:"mode"`  // octal, ex. "0644"
+	Owner       string   `mapstructure:"owner"` // "user" or "user:group"
+	Watches     []string `mapstructure:"watches"`
+
+	fileMode os.FileMode
+	uid      int
+	gid      int
+	tmpl     *template.Template
+	triggers []events.Event
+}
+
+// NewConfigs parses json config into a validated slice of Configs. All
+// templates are decoded and validated even if an earlier template is
+// invalid, so that every problem is reported at once in a decode.Errors.
+func NewConfigs(raw []interface{}, disc discovery.Backend) ([]*Config, error) {
+	var templates []*Config
+	if raw == nil {
+		return templates, nil
+	}
+	var errs decode.Errors
+	for i, rawTemplate := range raw {
+		path := fmt.Sprintf("templates[%d]", i)
+		tmpl := &Config{}
+		if err := decode.ToStruct(rawTemplate, tmpl); err != nil {
+			errs.Add(path, err)
+			continue
+		}
+		if err := tmpl.Validate(disc); err != nil {
+			errs.Add(path, err)
+			continue
+		}
+		templates = append(templates, tmpl)
+	}
+	return templates, errs.ErrorOrNil()
+}
+
+// Validate ensures Config meets all requirements and parses the source
+// template so that template errors are reported with the configuration
+func (cfg *Config) Validate(disc discovery.Backend) error {
+	if err := services.ValidateName(cfg.Name); err != nil {
+		return err
+	}
+	var errs decode.Errors
+	if cfg.Destination == "" {
+		errs.Add("destination", errors.New("destination is required"))
+	}
+	if err := cfg.validateMode(); err != nil {
+		errs.Add("mode", err)
+	}
+	if err := cfg.validateOwner(); err != nil {
+		errs.Add("owner", err)
+	}
+	for i, watch := range cfg.Watches {
+		if err := services.ValidateName(watch); err != nil {
+			errs.Add(fmt.Sprintf("watches[%d]", i),
+				fmt.Errorf("invalid watch name: %v", err))
+			continue
+		}
+		cfg.triggers = append(cfg.triggers,
+			events.Event{Code: events.StatusChanged, Source: "watch." + watch})
+	}
+	if err := cfg.parseSource(disc); err != nil {
+		errs.Add("source", err)
+	}
+	cfg.Name = "template." + cfg.Name
+	return errs.ErrorOrNil()
+}
+
+func (cfg *Config) validateMode() error {
+	cfg.fileMode = defaultMode
+	if cfg.Mode == "" {
+		return nil
+	}
+	mode, err := strconv.ParseUint(cfg.Mode, 8, 32)
+	if err != nil || mode > 07777 {
+		return fmt.Errorf("mode must be an octal file mode like \"0644\", got '%s'",
+			cfg.Mode)
+	}
+	cfg.fileMode = os.FileMode(mode)
+	return nil
+}
+
+// validateOwner looks up the uid and gid for the owner, which may be
+// names or numeric IDs. A missing user or group is left unchanged.
+func (cfg *Config) validateOwner() error {
+	cfg.uid, cfg.gid = -1, -1
+	if cfg.Owner == "" {
+		return nil
+	}
+	parts := strings.SplitN(cfg.Owner, ":", 2)
+	if parts[0] != "" {
+		uid, err := lookupID(parts[0], func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return err
+		}
+		cfg.uid = uid
+	}
+	if len(parts) == 2 && parts[1] != "" {
+		gid, err := lookupID(parts[1], func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return err
+		}
+		cfg.gid = gid
+	}
+	return nil
+}
+
+func lookupID(name string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	id, err := lookup(name)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(id)
+}
+
+// parseSource reads and parses the source template. In addition to the
+// functions available in the configuration file, templates can use the
+// 'service' function to get the healthy instances of a watched service,
+// and the 'key' and 'tree' functions to get the values of watched keys.
+func (cfg *Config) parseSource(disc discovery.Backend) error {
+	if cfg.Source == "" {
+		return errors.New("source is required")
+	}
+	text, err := ioutil.ReadFile(cfg.Source)
+	if err != nil {
+		return fmt.Errorf("could not read template: %v", err)
+	}
+	funcs := template.Funcs()
+	funcs["service"] = func(name string) []*discovery.ServiceInstance {
+		if disc == nil {
+			return []*discovery.ServiceInstance{}
+		}
+		return disc.ServiceInstances(name)
+	}
+	funcs["tree"] = func(prefix string) []*discovery.KVPair {
+		if disc == nil {
+			return []*discovery.KVPair{}
+		}
+		return disc.KVPairs(prefix)
+	}
+	funcs["key"] = func(key string) string {
+		if disc == nil {
+			return ""
+		}
+		for _, pair := range disc.KVPairs(key) {
+			if pair.Key == key {
+				return pair.Value
+			}
+		}
+		return ""
+	}
+	tmpl, err := template.NewNamedTemplate(cfg.Source, text, funcs)
+	if err != nil {
+		return err
+	}
+	cfg.tmpl = tmpl
+	return nil
+}
+
+// String implements the stdlib fmt.Stringer interface for pretty-printing
+func (cfg *Config) String() string {
+	return "templates.Config[" + cfg.Name + "]"
+}
@@ -0,0 +1,75 @@
+package templates
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/joyent/containerpilot/events"
+	"github.com/joyent/containerpilot/tests"
+)
+
+func TestTemplatesParse(t *testing.T) {
+	data, _ := ioutil.ReadFile(fmt.Sprintf("./testdata/%s.json5", t.Name()))
+	testCfg := tests.DecodeRawToSlice(string(data))
+	templates, err := NewConfigs(testCfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert := assert.New(t)
+	assert.Equal("template.nginx", templates[0].Name, "config for Name")
+	assert.Equal("/etc/nginx/conf.d/upstream.conf", templates[0].Destination)
+	assert.Equal(os.FileMode(0600), templates[0].fileMode, "config for mode")
+	assert.Equal(0, templates[0].uid, "config for owner uid")
+	assert.Equal(0, templates[0].gid, "config for owner gid")
+	assert.Equal([]events.Event{
+		{Code: events.StatusChanged, Source: "watch.app"},
+		{Code: events.StatusChanged, Source: "watch.api"},
+	}, templates[0].triggers, "config for watches")
+
+	assert.Equal("template.haproxy", templates[1].Name, "config for Name")
+	assert.Equal(defaultMode, templates[1].fileMode, "config for default mode")
+	assert.Equal(-1, templates[1].uid, "config for default owner uid")
+	assert.Equal(-1, templates[1].gid, "config for default owner gid")
+	assert.Nil(templates[1].triggers, "config for default watches")
+}
+
+func TestTemplatesConfigError(t *testing.T) {
+	_, err := NewConfigs(tests.DecodeRawToSlice(`[{"name": ""}]`), nil)
+	assert.Error(t, err, "'name' must not be blank")
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(`[{
+		"name": "nginx",
+		"source": "./testdata/upstream.conf.tmpl",
+		"mode": "999",
+		"watches": ["app", ""]}]`), nil)
+	assert.Equal(t, `3 configuration errors:
+  templates[0].destination: destination is required
+  templates[0].mode: mode must be an octal file mode like "0644", got '999'
+  templates[0].watches[1]: invalid watch name: 'name' must not be blank`, err.Error())
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(`[{
+		"name": "nginx",
+		"source": "./testdata/nonexistent.tmpl",
+		"destination": "/tmp/nginx.conf"}]`), nil)
+	assert.Equal(t, "templates[0].source: could not read template: "+
+		"open ./testdata/nonexistent.tmpl: no such file or directory", err.Error())
+}
+
+func TestTemplatesConfigSourceError(t *testing.T) {
+	f, _ := ioutil.TempFile("", "template-test")
+	defer os.Remove(f.Name())
+	f.WriteString("upstream app {\n  {{ range services }}{{ end }}\n}\n")
+	f.Close()
+	_, err := NewConfigs(tests.DecodeRawToSlice(fmt.Sprintf(`[{
+		"name": "nginx",
+		"source": "%s",
+		"destination": "/tmp/nginx.conf"}]`, f.Name())), nil)
+	assert.Equal(t, `templates[0].source: template error at line 2: function "services" not defined
+    1: upstream app {
+    2:   {{ range services }}{{ end }}
+---------^`, err.Error())
+}
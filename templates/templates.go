@@ -0,0 +1,144 @@
+// Package templates manages the configuration and rendering of templates
+// to files from the state of watched services
+package templates
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+
+	"github.com/joyent/containerpilot/config/files"
+	"github.com/joyent/containerpilot/config/template"
+	"github.com/joyent/containerpilot/events"
+	log "github.com/sirupsen/logrus"
+)
+
+const eventBufferSize = 1000
+
+// Template renders a file whenever one of the watches it depends on
+// changes, and emits a Rendered event when the file's content changes
+type Template struct {
+	Name        string
+	destination string
+	mode        os.FileMode
+	uid         int
+	gid         int
+	tmpl        *template.Template
+	triggers    []events.Event
+
+	events.Subscriber
+	events.Publisher
+}
+
+// NewTemplate creates a Template from a validated Config
+func NewTemplate(cfg *Config) *Template {
+	t := &Template{
+		Name:        cfg.Name,
+		destination: cfg.Destination,
+		mode:        cfg.fileMode,
+		uid:         cfg.uid,
+		gid:         cfg.gid,
+		tmpl:        cfg.tmpl,
+		triggers:    cfg.triggers,
+	}
+	t.Rx = make(chan events.Event, eventBufferSize)
+	return t
+}
+
+// FromConfigs creates Templates from a slice of validated Configs
+func FromConfigs(cfgs []*Config) []*Template {
+	templates := []*Template{}
+	for _, cfg := range cfgs {
+		templates = append(templates, NewTemplate(cfg))
+	}
+	return templates
+}
+
+// Run executes the event loop for the Template
+func (t *Template) Run(pctx context.Context, bus *events.EventBus) {
+	t.Subscribe(bus)
+	t.Register(bus)
+	ctx, cancel := context.WithCancel(pctx)
+	go func() {
+		defer func() {
+			cancel()
+			t.Unsubscribe()
+			t.Unregister()
+		}()
+		for {
+			select {
+			case event, ok := <-t.Rx:
+				if !ok {
+					return
+				}
+				switch event {
+				case events.GlobalShutdown, events.QuitByTest:
+					return
+				case events.GlobalStartup:
+					// the watches haven't checked their services yet,
+					// so jobs shouldn't act on this render
+					t.render(false)
+				default:
+					if t.isTrigger(event) {
+						t.render(true)
+					}
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (t *Template) isTrigger(event events.Event) bool {
+	for _, trigger := range t.triggers {
+		if event == trigger {
+			return true
+		}
+	}
+	return false
+}
+
+// render executes the template and writes the result to the destination
+// if it has changed, publishing a Rendered event if it did and publish
+// is set.
+func (t *Template) render(publish bool) {
+	changed, err := t.Render()
+	if err != nil {
+		log.Errorf("%s: %v", t.Name, err)
+		return
+	}
+	if changed {
+		log.Debugf("%s: rendered %s", t.Name, t.destination)
+		if publish {
+			t.Publish(events.Event{Code: events.Rendered, Source: t.Name})
+		}
+	}
+}
+
+// Render executes the template and writes the result to the destination
+// if it's different from what's already there. Returns true if the file
+// was written.
+func (t *Template) Render() (bool, error) {
+	// pick up any environment changes since the template was parsed,
+	// like the IP addresses ContainerPilot sets for jobs
+	t.tmpl.Env = template.CurrentEnvironment()
+	data, err := t.tmpl.Execute()
+	if err != nil {
+		return false, err
+	}
+	existing, err := ioutil.ReadFile(t.destination)
+	if err == nil && bytes.Equal(existing, data) {
+		return false, nil
+	}
+	if err := files.WriteAtomic(t.destination, data, t.mode, t.uid, t.gid); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// String implements the stdlib fmt.Stringer interface for pretty-printing
+func (t *Template) String() string {
+	return "templates.Template[" + t.Name + "]"
+}
@@ -0,0 +1,146 @@
+package templates
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/joyent/containerpilot/discovery"
+	"github.com/joyent/containerpilot/events"
+	"github.com/joyent/containerpilot/tests/mocks"
+)
+
+func TestTemplateRender(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "templates-test")
+	defer os.RemoveAll(dir)
+	disc := &mocks.NoopDiscoveryBackend{}
+	tmpl := newTestTemplate(t, filepath.Join(dir, "upstream.conf"), disc)
+
+	changed, err := tmpl.Render()
+	assert.Nil(t, err)
+	assert.True(t, changed, "expected first render to write the file")
+	assertFile(t, tmpl.destination, "upstream app {\n}\n")
+
+	changed, err = tmpl.Render()
+	assert.Nil(t, err)
+	assert.False(t, changed, "expected unchanged render to skip writing")
+
+	disc.Instances = []*discovery.ServiceInstance{
+		{ID: "app-1", Name: "app", Address: "10.0.0.1", Port: 80},
+		{ID: "app-2", Name: "app", Address: "10.0.0.2", Port: 80},
+	}
+	changed, err = tmpl.Render()
+	assert.Nil(t, err)
+	assert.True(t, changed, "expected render to write the changed file")
+	assertFile(t, tmpl.destination,
+		"upstream app {\n  server 10.0.0.1:80;\n  server 10.0.0.2:80;\n}\n")
+
+	info, _ := os.Stat(tmpl.destination)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	files, _ := ioutil.ReadDir(dir)
+	assert.Equal(t, 1, len(files), "expected temporary files to be cleaned up")
+}
+
+func TestTemplateRenderKV(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "templates-test")
+	defer os.RemoveAll(dir)
+	disc := &mocks.NoopDiscoveryBackend{KV: map[string][]*discovery.KVPair{
+		"app/mode": {{Key: "app/mode", Value: "blue"}},
+		"app/flags/": {
+			{Key: "app/flags/a", Value: "on"},
+			{Key: "app/flags/b", Value: "off"},
+		},
+	}}
+	cfg := &Config{
+		Name:        "flags",
+		Source:      "./testdata/flags.conf.tmpl",
+		Destination: filepath.Join(dir, "flags.conf"),
+		Watches:     []string{"mode", "flags"},
+	}
+	if err := cfg.Validate(disc); err != nil {
+		t.Fatalf("unexpected error validating template: %v", err)
+	}
+	tmpl := NewTemplate(cfg)
+	_, err := tmpl.Render()
+	assert.Nil(t, err)
+	assertFile(t, tmpl.destination, "mode=blue\napp/flags/a=on\napp/flags/b=off\n")
+}
+
+func TestTemplateRenderError(t *testing.T) {
+	tmpl := newTestTemplate(t, "/xxxx/upstream.conf", &mocks.NoopDiscoveryBackend{})
+	changed, err := tmpl.Render()
+	assert.False(t, changed)
+	assert.Contains(t, err.Error(), "/xxxx")
+}
+
+func TestTemplateRun(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "templates-test")
+	defer os.RemoveAll(dir)
+	disc := &mocks.NoopDiscoveryBackend{}
+	tmpl := newTestTemplate(t, filepath.Join(dir, "upstream.conf"), disc)
+
+	bus := events.NewEventBus()
+	tmpl.Run(context.Background(), bus)
+	// the file is rendered on startup without a Rendered event, because
+	// the watches haven't checked their services yet
+	tmpl.Receive(events.GlobalStartup)
+	// no change to the rendered file
+	tmpl.Receive(events.Event{Code: events.StatusChanged, Source: "watch.app"})
+	tmpl.Receive(events.QuitByTest)
+	bus.Wait()
+
+	rendered := events.Event{Code: events.Rendered, Source: "template.upstream"}
+	got := map[events.Event]int{}
+	for _, event := range bus.DebugEvents() {
+		got[event]++
+	}
+	if got[rendered] != 0 {
+		t.Fatalf("expected no Rendered events but got %v", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "upstream.conf")); err != nil {
+		t.Fatalf("expected file to be rendered on startup: %v", err)
+	}
+
+	// a change from a watch we don't depend on doesn't render
+	disc.Instances = []*discovery.ServiceInstance{
+		{ID: "app-1", Name: "app", Address: "10.0.0.1", Port: 80}}
+	bus = events.NewEventBus()
+	tmpl.Run(context.Background(), bus)
+	tmpl.Receive(events.Event{Code: events.StatusChanged, Source: "watch.other"})
+	tmpl.Receive(events.Event{Code: events.StatusChanged, Source: "watch.app"})
+	tmpl.Receive(events.QuitByTest)
+	bus.Wait()
+	got = map[events.Event]int{}
+	for _, event := range bus.DebugEvents() {
+		got[event]++
+	}
+	if got[rendered] != 1 {
+		t.Fatalf("expected 1 Rendered event but got %v", got)
+	}
+}
+
+func newTestTemplate(t *testing.T, dest string, disc discovery.Backend) *Template {
+	cfg := &Config{
+		Name:        "upstream",
+		Source:      "./testdata/upstream.conf.tmpl",
+		Destination: dest,
+		Mode:        "0600",
+		Watches:     []string{"app"},
+	}
+	if err := cfg.Validate(disc); err != nil {
+		t.Fatalf("unexpected error validating template: %v", err)
+	}
+	return NewTemplate(cfg)
+}
+
+func assertFile(t *testing.T, path, expected string) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("could not read rendered file: %v", err)
+	}
+	assert.Equal(t, expected, string(data))
+}
@@ -1,11 +1,20 @@
 package mocks
 
-import "github.com/hashicorp/consul/api"
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/joyent/containerpilot/discovery"
+)
 
 // NoopDiscoveryBackend is a mock discovery.Backend
 type NoopDiscoveryBackend struct {
-	Val     bool
-	lastVal bool
+	Val       bool
+	Instances []*discovery.ServiceInstance
+	KV        map[string][]*discovery.KVPair
+	lastVal   bool
+	lastKV    map[string][]*discovery.KVPair
 }
 
 // CheckForUpstreamChanges will return the public Val field to mock
@@ -20,8 +29,16 @@ func (noop *NoopDiscoveryBackend) CheckForUpstreamChanges(_, _, _ string) (didCh
 	return didChange, isHealthy
 }
 
+// WaitForUpstreamChanges will return immediately with the same results as
+// CheckForUpstreamChanges, and the next index
+func (noop *NoopDiscoveryBackend) WaitForUpstreamChanges(_ context.Context, _, _, _ string,
+	waitIndex uint64, _ time.Duration) (didChange, isHealthy bool, lastIndex uint64, err error) {
+	didChange, isHealthy = noop.CheckForUpstreamChanges("", "", "")
+	return didChange, isHealthy, waitIndex + 1, nil
+}
+
 // CheckRegister (required for mock interface)
-func (noop *NoopDiscoveryBackend) CheckRegister(check *api.AgentCheckRegistration) error {
+func (noop *NoopDiscoveryBackend) CheckRegister(check *discovery.CheckRegistration) error {
 	return nil
 }
 
@@ -36,6 +53,42 @@ func (noop *NoopDiscoveryBackend) ServiceDeregister(serviceID string) error {
 }
 
 // ServiceRegister (required for mock interface)
-func (noop *NoopDiscoveryBackend) ServiceRegister(service *api.AgentServiceRegistration) error {
+func (noop *NoopDiscoveryBackend) ServiceRegister(service *discovery.ServiceRegistration) error {
+	return nil
+}
+
+// RegisteredServices (required for mock interface)
+func (noop *NoopDiscoveryBackend) RegisteredServices() ([]string, error) {
+	return nil, nil
+}
+
+// EnableServiceMaintenance (required for mock interface)
+func (noop *NoopDiscoveryBackend) EnableServiceMaintenance(serviceID, reason string) error {
+	return nil
+}
+
+// DisableServiceMaintenance (required for mock interface)
+func (noop *NoopDiscoveryBackend) DisableServiceMaintenance(serviceID string) error {
 	return nil
 }
+
+// ServiceInstances will return the public Instances field for any service
+func (noop *NoopDiscoveryBackend) ServiceInstances(service string) []*discovery.ServiceInstance {
+	return noop.Instances
+}
+
+// CheckForKVChanges will report a change if the public KV field for the
+// key has been updated by the test rig since the last check
+func (noop *NoopDiscoveryBackend) CheckForKVChanges(key string, _ bool, _ string) (bool, error) {
+	if noop.lastKV == nil {
+		noop.lastKV = map[string][]*discovery.KVPair{}
+	}
+	didChange := !reflect.DeepEqual(noop.lastKV[key], noop.KV[key])
+	noop.lastKV[key] = noop.KV[key]
+	return didChange, nil
+}
+
+// KVPairs will return the public KV field for the key
+func (noop *NoopDiscoveryBackend) KVPairs(key string) []*discovery.KVPair {
+	return noop.KV[key]
+}
@@ -0,0 +1,84 @@
+package watches
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Consul blocking queries return as soon as the service changes, or after
+// the wait time if it doesn't. A service that changes very often could
+// make us query Consul in a tight loop, so we rate limit the queries, and
+// we back off exponentially if the queries fail.
+const (
+	blockingWaitTime    = 5 * time.Minute
+	blockingMinInterval = time.Second
+	blockingMaxBackoff  = time.Minute
+)
+
+// checkResult is the result of a check for upstream changes made outside
+// the watch's event loop
+type checkResult struct {
+	didChange bool
+	isHealthy bool
+}
+
+// runBlockingQueries makes blocking queries for the watched service until
+// the context is canceled, sending the result of each successful query
+func (watch *Watch) runBlockingQueries(ctx context.Context, results chan<- checkResult) {
+	var index uint64
+	var backoff time.Duration
+	var lastQuery time.Time
+	for {
+		wait := blockingMinInterval - time.Since(lastQuery)
+		if backoff > wait {
+			wait = backoff
+		}
+		if wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return
+			}
+		}
+		lastQuery = time.Now()
+		didChange, isHealthy, lastIndex, err := watch.discoveryService.WaitForUpstreamChanges(
+			ctx, watch.serviceName, watch.tag, watch.dc, index, blockingWaitTime)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			backoff = nextBackoff(backoff)
+			log.Warnf("%s: blocking query failed, retrying in %v: %v",
+				watch.Name, backoff, err)
+			// start over with a full query in case the index was the problem
+			index = 0
+			continue
+		}
+		backoff = 0
+		// per the Consul docs, the index can go backwards (ex. if the
+		// Consul servers lose their state) and we need to reset it
+		if lastIndex < index {
+			index = 0
+		} else {
+			index = lastIndex
+		}
+		select {
+		case results <- checkResult{didChange, isHealthy}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func nextBackoff(backoff time.Duration) time.Duration {
+	if backoff == 0 {
+		return blockingMinInterval
+	}
+	backoff *= 2
+	if backoff > blockingMaxBackoff {
+		return blockingMaxBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,123 @@
+package watches
+
+import (
+	"sort"
+
+	"github.com/joyent/containerpilot/discovery"
+)
+
+// fields of the instances of a service that can be compared to decide
+// whether the service has changed
+const (
+	compareAddress = "address"
+	comparePort    = "port"
+	compareTags    = "tags"
+	compareMeta    = "meta"
+	compareChecks  = "checks"
+)
+
+var (
+	compareFields  = []string{compareAddress, comparePort, compareTags, compareMeta, compareChecks}
+	defaultCompare = []string{compareAddress, comparePort}
+)
+
+// Changes are the differences between the instances of a watched service
+// found by two checks. Instances are matched by ID, and Updated has the
+// new version of any instance where one of the compared fields changed.
+type Changes struct {
+	Added   []*discovery.ServiceInstance
+	Removed []*discovery.ServiceInstance
+	Updated []*discovery.ServiceInstance
+}
+
+// IsEmpty returns true if there are no changes
+func (c *Changes) IsEmpty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0 && len(c.Updated) == 0
+}
+
+// diffInstances returns the Changes between the old and new instances,
+// comparing only the given fields
+func diffInstances(old, new []*discovery.ServiceInstance, compare []string) *Changes {
+	changes := &Changes{
+		Added:   []*discovery.ServiceInstance{},
+		Removed: []*discovery.ServiceInstance{},
+		Updated: []*discovery.ServiceInstance{},
+	}
+	oldByID := make(map[string]*discovery.ServiceInstance, len(old))
+	for _, instance := range old {
+		oldByID[instance.ID] = instance
+	}
+	for _, instance := range new {
+		prev, ok := oldByID[instance.ID]
+		switch {
+		case !ok:
+			changes.Added = append(changes.Added, instance)
+		case !instanceEqual(prev, instance, compare):
+			changes.Updated = append(changes.Updated, instance)
+		}
+		delete(oldByID, instance.ID)
+	}
+	for _, instance := range old {
+		if _, ok := oldByID[instance.ID]; ok {
+			changes.Removed = append(changes.Removed, instance)
+		}
+	}
+	return changes
+}
+
+func instanceEqual(a, b *discovery.ServiceInstance, compare []string) bool {
+	for _, field := range compare {
+		switch field {
+		case compareAddress:
+			if a.Address != b.Address {
+				return false
+			}
+		case comparePort:
+			if a.Port != b.Port {
+				return false
+			}
+		case compareTags:
+			if !stringSetsEqual(a.Tags, b.Tags) {
+				return false
+			}
+		case compareMeta:
+			if !stringMapsEqual(a.Meta, b.Meta) {
+				return false
+			}
+		case compareChecks:
+			if !stringMapsEqual(a.Checks, b.Checks) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// stringSetsEqual compares tags without regard to their order
+func stringSetsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string{}, a...)
+	b = append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func stringMapsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,128 @@
+package watches
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/joyent/containerpilot/discovery"
+	"github.com/joyent/containerpilot/events"
+	"github.com/joyent/containerpilot/tests/mocks"
+)
+
+func TestDiffInstances(t *testing.T) {
+	a1 := &discovery.ServiceInstance{ID: "a", Address: "10.0.0.1", Port: 80,
+		Tags: []string{"primary", "db"}, Meta: map[string]string{"version": "1.0"},
+		Checks: map[string]string{"serfHealth": "passing"}}
+	b1 := &discovery.ServiceInstance{ID: "b", Address: "10.0.0.2", Port: 80}
+	c1 := &discovery.ServiceInstance{ID: "c", Address: "10.0.0.3", Port: 80}
+	old := []*discovery.ServiceInstance{a1, b1}
+
+	// tags in a different order aren't a change
+	a2 := &discovery.ServiceInstance{ID: "a", Address: "10.0.0.1", Port: 80,
+		Tags: []string{"db", "primary"}, Meta: map[string]string{"version": "1.0"},
+		Checks: map[string]string{"serfHealth": "passing"}}
+	changes := diffInstances(old, []*discovery.ServiceInstance{a2, c1}, compareFields)
+	assert.Equal(t, []*discovery.ServiceInstance{c1}, changes.Added)
+	assert.Equal(t, []*discovery.ServiceInstance{b1}, changes.Removed)
+	assert.Equal(t, []*discovery.ServiceInstance{}, changes.Updated)
+
+	// retagging is only a change if we compare tags
+	a3 := &discovery.ServiceInstance{ID: "a", Address: "10.0.0.1", Port: 80,
+		Tags: []string{"replica", "db"}}
+	changes = diffInstances(old, []*discovery.ServiceInstance{a3, b1}, defaultCompare)
+	assert.True(t, changes.IsEmpty())
+	changes = diffInstances(old, []*discovery.ServiceInstance{a3, b1},
+		[]string{compareTags})
+	assert.Equal(t, []*discovery.ServiceInstance{a3}, changes.Updated)
+	changes = diffInstances(old, []*discovery.ServiceInstance{a3, b1},
+		[]string{compareMeta})
+	assert.Equal(t, []*discovery.ServiceInstance{a3}, changes.Updated)
+	changes = diffInstances(old, []*discovery.ServiceInstance{a3, b1},
+		[]string{compareChecks})
+	assert.Equal(t, []*discovery.ServiceInstance{a3}, changes.Updated)
+
+	// only the service's metadata changed
+	a4 := &discovery.ServiceInstance{ID: "a", Address: "10.0.0.1", Port: 80,
+		Tags: []string{"primary", "db"}, Meta: map[string]string{"version": "1.1"},
+		Checks: map[string]string{"serfHealth": "passing"}}
+	changes = diffInstances(old, []*discovery.ServiceInstance{a4, b1}, defaultCompare)
+	assert.True(t, changes.IsEmpty())
+	changes = diffInstances(old, []*discovery.ServiceInstance{a4, b1},
+		[]string{compareMeta})
+	assert.Equal(t, []*discovery.ServiceInstance{a4}, changes.Updated)
+
+	// the metadata of the node isn't the service's metadata
+	a5 := &discovery.ServiceInstance{ID: "a", Address: "10.0.0.1", Port: 80,
+		Tags: []string{"primary", "db"}, Meta: map[string]string{"version": "1.0"},
+		NodeMeta: map[string]string{"rack": "2"},
+		Checks:   map[string]string{"serfHealth": "passing"}}
+	changes = diffInstances(old, []*discovery.ServiceInstance{a5, b1}, compareFields)
+	assert.True(t, changes.IsEmpty())
+
+	b2 := &discovery.ServiceInstance{ID: "b", Address: "10.0.0.2", Port: 8080}
+	changes = diffInstances(old, []*discovery.ServiceInstance{a1, b2}, defaultCompare)
+	assert.Equal(t, []*discovery.ServiceInstance{b2}, changes.Updated)
+	assert.True(t, diffInstances(old, old, compareFields).IsEmpty())
+}
+
+func TestWatchCompareTags(t *testing.T) {
+	disc := &mocks.NoopDiscoveryBackend{Val: true,
+		Instances: []*discovery.ServiceInstance{
+			{ID: "db-1", Address: "10.0.0.1", Port: 5432, Tags: []string{"primary"}},
+		}}
+	cfg := &Config{Name: "db", Poll: 1, Compare: []string{"address", "port", "tags"}}
+	cfg.Validate(disc)
+	watch := NewWatch(cfg)
+	bus := events.NewEventBus()
+	watch.Register(bus)
+	defer watch.Unregister()
+	published := false
+	watch.onCheck(false, true, &published)
+	assert.Equal(t, 1, len(watch.Changes().Added))
+
+	// retagging doesn't change the address so the backend won't report it
+	disc.Instances = []*discovery.ServiceInstance{
+		{ID: "db-1", Address: "10.0.0.1", Port: 5432, Tags: []string{"replica"}},
+	}
+	watch.onCheck(false, true, &published)
+	assert.Equal(t, disc.Instances, watch.Changes().Updated)
+	changed := 0
+	for _, event := range bus.DebugEvents() {
+		if event == (events.Event{Code: events.StatusChanged, Source: "watch.db"}) {
+			changed++
+		}
+	}
+	assert.Equal(t, 2, changed, "expected changed events for add and retag")
+}
+
+func TestWatchCompareMeta(t *testing.T) {
+	disc := &mocks.NoopDiscoveryBackend{Val: true,
+		Instances: []*discovery.ServiceInstance{
+			{ID: "app-1", Address: "10.0.0.1", Port: 80,
+				Meta: map[string]string{"version": "1.0"}},
+		}}
+	cfg := &Config{Name: "app", Poll: 1, Compare: []string{"meta"}}
+	cfg.Validate(disc)
+	watch := NewWatch(cfg)
+	bus := events.NewEventBus()
+	watch.Register(bus)
+	defer watch.Unregister()
+	published := false
+	watch.onCheck(false, true, &published)
+
+	// a new version is deployed in place
+	disc.Instances = []*discovery.ServiceInstance{
+		{ID: "app-1", Address: "10.0.0.1", Port: 80,
+			Meta: map[string]string{"version": "1.1"}},
+	}
+	watch.onCheck(false, true, &published)
+	assert.Equal(t, disc.Instances, watch.Changes().Updated)
+	changed := 0
+	for _, event := range bus.DebugEvents() {
+		if event == (events.Event{Code: events.StatusChanged, Source: "watch.app"}) {
+			changed++
+		}
+	}
+	assert.Equal(t, 2, changed, "expected changed events for add and new meta")
+}
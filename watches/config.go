@@ -2,37 +2,93 @@ package watches
 
 import (
 	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/joyent/containerpilot/config/decode"
 	"github.com/joyent/containerpilot/config/services"
+	"github.com/joyent/containerpilot/config/timing"
 	"github.com/joyent/containerpilot/discovery"
 )
 
 // Config configures the watch
 type Config struct {
-	Name             string `mapstructure:"name"`
+	Name             string `mapstructure:"name" schema:"required"`
 	serviceName      string
-	Poll             int    `mapstructure:"interval"` // time in seconds
-	Tag              string `mapstructure:"tag"`
-	DC               string `mapstructure:"dc"` // Consul datacenter
+	Type             string            `mapstructure:"type" schema:"enum=service|kv|file|dns|http"`
+	Key              string            `mapstructure:"key"`     // kv watches only
+	Recurse          bool              `mapstructure:"recurse"` // kv watches only
+	Path             string            `mapstructure:"path"`    // file watches only
+	Record           string            `mapstructure:"record"`  // dns watches only
+	RecordType       string            `mapstructure:"recordType" schema:"enum=A|SRV"`
+	Resolver         string            `mapstructure:"resolver"` // dns watches only
+	Port             int               `mapstructure:"port"`     // dns watches only
+	URL              string            `mapstructure:"url"`      // http watches only
+	JSONPath         string            `mapstructure:"jsonPath"` // http watches only
+	Timeout          string            `mapstructure:"timeout" schema:"duration"`
+	Poll             int               `mapstructure:"interval"` // time in seconds
+	Tag              string            `mapstructure:"tag"`
+	DC               string            `mapstructure:"dc"` // Consul datacenter
+	File             string            `mapstructure:"file"`
+	FileFormat       string            `mapstructure:"fileFormat" schema:"enum=json|env"`
+	Blocking         bool              `mapstructure:"blocking"` // use Consul blocking queries
+	Compare          []string          `mapstructure:"compare" schema:"enum=address|port|tags|meta|checks"`
+	Debounce         string            `mapstructure:"debounce" schema:"duration"`
+	MaxWait          string            `mapstructure:"maxWait" schema:"duration"`
+	Thresholds       *ThresholdsConfig `mapstructure:"thresholds"`
+	debounce         time.Duration
+	maxWait          time.Duration
+	timeout          time.Duration
 	discoveryService discovery.Backend
 }
 
-// NewConfigs parses json config into a validated slice of Configs
+// types of watch
+const (
+	watchTypeService = "service"
+	watchTypeKV      = "kv"
+	watchTypeFile    = "file"
+	watchTypeDNS     = "dns"
+	watchTypeHTTP    = "http"
+)
+
+var watchTypes = []string{
+	watchTypeService, watchTypeKV, watchTypeFile, watchTypeDNS, watchTypeHTTP}
+
+// defaultHTTPTimeout is the timeout for the requests of http watches
+const defaultHTTPTimeout = 10 * time.Second
+
+// ThresholdsConfig configures the events a watch emits when the count of
+// healthy instances crosses a bound. A bound of 0 is unset.
+type ThresholdsConfig struct {
+	Min int `mapstructure:"min"`
+	Max int `mapstructure:"max"`
+}
+
+// NewConfigs parses json config into a validated slice of Configs. All
+// watches are decoded and validated even if an earlier watch is invalid,
+// so that every problem is reported at once in a decode.Errors.
 func NewConfigs(raw []interface{}, disc discovery.Backend) ([]*Config, error) {
 	var watches []*Config
 	if raw == nil {
 		return watches, nil
 	}
-	if err := decode.ToStruct(raw, &watches); err != nil {
-		return watches, fmt.Errorf("Watch configuration error: %v", err)
-	}
-	for _, watch := range watches {
+	var errs decode.Errors
+	for i, rawWatch := range raw {
+		path := fmt.Sprintf("watches[%d]", i)
+		watch := &Config{}
+		if err := decode.ToStruct(rawWatch, watch); err != nil {
+			errs.Add(path, err)
+			continue
+		}
 		if err := watch.Validate(disc); err != nil {
-			return watches, err
+			errs.Add(path, err)
+			continue
 		}
+		watches = append(watches, watch)
 	}
-	return watches, nil
+	return watches, errs.ErrorOrNil()
 }
 
 // Validate ensures Config meets all requirements
@@ -44,13 +100,215 @@ func (cfg *Config) Validate(disc discovery.Backend) error {
 	cfg.serviceName = cfg.Name
 	cfg.Name = "watch." + cfg.Name
 
-	if cfg.Poll < 1 {
+	if err := cfg.validateType(); err != nil {
+		return err
+	}
+	// file watches are told about changes by the filesystem,
+	// so polling is only an optional safety re-check
+	if cfg.Type == watchTypeFile && cfg.Poll < 0 {
+		return fmt.Errorf("watch[%s].interval must be >= 0", cfg.serviceName)
+	}
+	if cfg.Type != watchTypeFile && cfg.Poll < 1 {
 		return fmt.Errorf("watch[%s].interval must be > 0", cfg.serviceName)
 	}
+	switch cfg.FileFormat {
+	case "":
+		cfg.FileFormat = fileFormatJSON
+	case fileFormatJSON, fileFormatEnv:
+	default:
+		return fmt.Errorf("watch[%s].fileFormat must be one of '%s' or '%s'",
+			cfg.serviceName, fileFormatJSON, fileFormatEnv)
+	}
+	if err := cfg.validateCompare(); err != nil {
+		return err
+	}
+	if err := cfg.validateDebounce(); err != nil {
+		return err
+	}
+	if err := cfg.validateThresholds(); err != nil {
+		return err
+	}
 	cfg.discoveryService = disc
 	return nil
 }
 
+func (cfg *Config) validateType() error {
+	switch cfg.Type {
+	case "":
+		cfg.Type = watchTypeService
+	case watchTypeService, watchTypeKV, watchTypeFile, watchTypeDNS, watchTypeHTTP:
+	default:
+		return fmt.Errorf("watch[%s].type must be one of %s",
+			cfg.serviceName, strings.Join(watchTypes, ", "))
+	}
+	// fields that are only for some types of watch
+	fields := []struct {
+		name  string
+		isSet bool
+		types []string
+	}{
+		{"tag", cfg.Tag != "", []string{watchTypeService}},
+		{"blocking", cfg.Blocking, []string{watchTypeService}},
+		{"compare", len(cfg.Compare) > 0, []string{watchTypeService, watchTypeDNS}},
+		{"thresholds", cfg.Thresholds != nil, []string{watchTypeService, watchTypeDNS}},
+		{"dc", cfg.DC != "", []string{watchTypeService, watchTypeKV}},
+		{"file", cfg.File != "", []string{watchTypeService, watchTypeKV, watchTypeDNS}},
+		{"key", cfg.Key != "", []string{watchTypeKV}},
+		{"recurse", cfg.Recurse, []string{watchTypeKV}},
+		{"path", cfg.Path != "", []string{watchTypeFile}},
+		{"record", cfg.Record != "", []string{watchTypeDNS}},
+		{"recordType", cfg.RecordType != "", []string{watchTypeDNS}},
+		{"resolver", cfg.Resolver != "", []string{watchTypeDNS}},
+		{"port", cfg.Port != 0, []string{watchTypeDNS}},
+		{"url", cfg.URL != "", []string{watchTypeHTTP}},
+		{"jsonPath", cfg.JSONPath != "", []string{watchTypeHTTP}},
+		{"timeout", cfg.Timeout != "", []string{watchTypeHTTP}},
+	}
+	for _, field := range fields {
+		if !field.isSet {
+			continue
+		}
+		supported := false
+		for _, watchType := range field.types {
+			if watchType == cfg.Type {
+				supported = true
+			}
+		}
+		if !supported {
+			return fmt.Errorf("watch[%s].%s is not supported for %s watches",
+				cfg.serviceName, field.name, cfg.Type)
+		}
+	}
+	switch {
+	case cfg.Type == watchTypeKV && cfg.Key == "":
+		return fmt.Errorf("watch[%s].key is required for kv watches", cfg.serviceName)
+	case cfg.Type == watchTypeFile && cfg.Path == "":
+		return fmt.Errorf("watch[%s].path is required for file watches", cfg.serviceName)
+	}
+	switch cfg.Type {
+	case watchTypeDNS:
+		return cfg.validateDNS()
+	case watchTypeHTTP:
+		return cfg.validateHTTP()
+	}
+	return nil
+}
+
+func (cfg *Config) validateHTTP() error {
+	if cfg.URL == "" {
+		return fmt.Errorf("watch[%s].url is required for http watches", cfg.serviceName)
+	}
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("watch[%s].url '%s' must be an http or https URL",
+			cfg.serviceName, cfg.URL)
+	}
+	timeout, err := timing.GetTimeout(cfg.Timeout)
+	if err != nil {
+		return fmt.Errorf("unable to parse watch[%s].timeout '%s': %v",
+			cfg.serviceName, cfg.Timeout, err)
+	}
+	if timeout < 0 {
+		return fmt.Errorf("watch[%s].timeout '%s' cannot be negative",
+			cfg.serviceName, cfg.Timeout)
+	}
+	if timeout == 0 {
+		timeout = defaultHTTPTimeout
+	}
+	cfg.timeout = timeout
+	return nil
+}
+
+func (cfg *Config) validateDNS() error {
+	if cfg.Record == "" {
+		return fmt.Errorf("watch[%s].record is required for dns watches", cfg.serviceName)
+	}
+	switch cfg.RecordType {
+	case "":
+		cfg.RecordType = recordTypeA
+	case recordTypeA, recordTypeSRV:
+	default:
+		return fmt.Errorf("watch[%s].recordType must be one of '%s' or '%s'",
+			cfg.serviceName, recordTypeA, recordTypeSRV)
+	}
+	if cfg.Port < 0 || cfg.Port > 65535 {
+		return fmt.Errorf("watch[%s].port must be a valid port number", cfg.serviceName)
+	}
+	if cfg.Resolver != "" {
+		if _, _, err := net.SplitHostPort(cfg.Resolver); err != nil {
+			// default to the standard DNS port
+			cfg.Resolver = net.JoinHostPort(cfg.Resolver, "53")
+		}
+	}
+	return nil
+}
+
+func (cfg *Config) validateCompare() error {
+	if len(cfg.Compare) == 0 {
+		cfg.Compare = defaultCompare
+		return nil
+	}
+	for _, field := range cfg.Compare {
+		valid := false
+		for _, f := range compareFields {
+			if field == f {
+				valid = true
+			}
+		}
+		if !valid {
+			return fmt.Errorf("watch[%s].compare field '%s' must be one of %s",
+				cfg.serviceName, field, strings.Join(compareFields, ", "))
+		}
+	}
+	return nil
+}
+
+func (cfg *Config) validateDebounce() error {
+	debounce, err := timing.GetTimeout(cfg.Debounce)
+	if err != nil {
+		return fmt.Errorf("unable to parse watch[%s].debounce '%s': %v",
+			cfg.serviceName, cfg.Debounce, err)
+	}
+	if debounce < 0 {
+		return fmt.Errorf("watch[%s].debounce '%s' cannot be negative",
+			cfg.serviceName, cfg.Debounce)
+	}
+	maxWait, err := timing.GetTimeout(cfg.MaxWait)
+	if err != nil {
+		return fmt.Errorf("unable to parse watch[%s].maxWait '%s': %v",
+			cfg.serviceName, cfg.MaxWait, err)
+	}
+	if maxWait != 0 {
+		if debounce == 0 {
+			return fmt.Errorf("watch[%s].maxWait requires a debounce", cfg.serviceName)
+		}
+		if maxWait < debounce {
+			return fmt.Errorf("watch[%s].maxWait '%s' cannot be less than debounce '%s'",
+				cfg.serviceName, cfg.MaxWait, cfg.Debounce)
+		}
+	}
+	cfg.debounce = debounce
+	cfg.maxWait = maxWait
+	return nil
+}
+
+func (cfg *Config) validateThresholds() error {
+	if cfg.Thresholds == nil {
+		return nil
+	}
+	min, max := cfg.Thresholds.Min, cfg.Thresholds.Max
+	switch {
+	case min < 0 || max < 0:
+		return fmt.Errorf("watch[%s].thresholds cannot be negative", cfg.serviceName)
+	case min == 0 && max == 0:
+		return fmt.Errorf("watch[%s].thresholds must have a min or a max", cfg.serviceName)
+	case max != 0 && max < min:
+		return fmt.Errorf("watch[%s].thresholds.max %d cannot be less than min %d",
+			cfg.serviceName, max, min)
+	}
+	return nil
+}
+
 // String implements the stdlib fmt.Stringer interface for pretty-printing
 func (cfg *Config) String() string {
 	return "watches.Config[" + cfg.Name + "]"
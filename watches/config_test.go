@@ -38,4 +38,61 @@ func TestWatchesConfigError(t *testing.T) {
 	_, err = NewConfigs(tests.DecodeRawToSlice(
 		`[{"name": "myName"}]`), nil)
 	assert.Error(t, err, "watch[myName].interval must be > 0")
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "myName", "interval": 1, "fileFormat": "yaml"}]`), nil)
+	assert.Error(t, err, "watch[myName].fileFormat must be one of 'json' or 'env'")
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "myName", "interval": 1, "compare": ["tags", "color"]}]`), nil)
+	assert.Equal(t, "watches[0]: watch[myName].compare field 'color' must be "+
+		"one of address, port, tags, meta, checks", err.Error())
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "myName", "interval": 1, "maxWait": "30s"}]`), nil)
+	assert.Equal(t, "watches[0]: watch[myName].maxWait requires a debounce", err.Error())
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "myName", "interval": 1, "debounce": "10s", "maxWait": "5s"}]`), nil)
+	assert.Equal(t, "watches[0]: watch[myName].maxWait '5s' cannot be "+
+		"less than debounce '10s'", err.Error())
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "myName", "interval": 1, "thresholds": {"min": 3, "max": 2}}]`), nil)
+	assert.Equal(t, "watches[0]: watch[myName].thresholds.max 2 cannot be "+
+		"less than min 3", err.Error())
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "myName", "interval": 1, "type": "kv"}]`), nil)
+	assert.Equal(t, "watches[0]: watch[myName].key is required for kv watches",
+		err.Error())
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "myName", "interval": 1, "type": "kv", "key": "a", "tag": "b"}]`), nil)
+	assert.Equal(t, "watches[0]: watch[myName].tag is not supported for kv watches",
+		err.Error())
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "myName", "type": "file"}]`), nil)
+	assert.Equal(t, "watches[0]: watch[myName].path is required for file watches",
+		err.Error())
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "myName", "interval": 1, "type": "dns", "record": "a.example.com", "recordType": "MX"}]`), nil)
+	assert.Equal(t, "watches[0]: watch[myName].recordType must be one of 'A' or 'SRV'",
+		err.Error())
+
+	_, err = NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "myName", "interval": 1, "type": "http", "url": "localhost:8080"}]`), nil)
+	assert.Equal(t, "watches[0]: watch[myName].url 'localhost:8080' must be an "+
+		"http or https URL", err.Error())
+}
+
+func TestWatchesConfigDNS(t *testing.T) {
+	watches, err := NewConfigs(tests.DecodeRawToSlice(
+		`[{"name": "db", "interval": 1, "type": "dns", "record": "db.example.com",
+		"resolver": "10.0.0.2"}]`), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "A", watches[0].RecordType, "default for recordType")
+	assert.Equal(t, "10.0.0.2:53", watches[0].Resolver, "default port for resolver")
 }
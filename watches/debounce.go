@@ -0,0 +1,75 @@
+package watches
+
+import "time"
+
+// debouncer coalesces a burst of changes to the watched service, such as
+// a rolling deploy, so that the watch publishes a single change after the
+// service has been quiet for the debounce period. If the service never
+// goes quiet, the change is published once maxWait has passed since the
+// first change of the burst. A debouncer is only used by the event loop
+// of its watch, so it doesn't need to be locked.
+type debouncer struct {
+	quiet   time.Duration
+	maxWait time.Duration
+	timer   *time.Timer
+	first   time.Time
+}
+
+// newDebouncer returns nil if there's no debounce period, and the methods
+// of a nil debouncer are safe to call
+func newDebouncer(quiet, maxWait time.Duration) *debouncer {
+	if quiet <= 0 {
+		return nil
+	}
+	return &debouncer{quiet: quiet, maxWait: maxWait}
+}
+
+// C returns the channel that receives when a pending change should be
+// published, or nil if there's no pending change
+func (d *debouncer) C() <-chan time.Time {
+	if d == nil || d.timer == nil {
+		return nil
+	}
+	return d.timer.C
+}
+
+// change records a change at the given time and pushes back the time the
+// change will be published, without going past the maxWait
+func (d *debouncer) change(now time.Time) {
+	if d.timer == nil {
+		d.first = now
+	}
+	wait := d.quiet
+	if d.maxWait > 0 {
+		if remaining := d.first.Add(d.maxWait).Sub(now); remaining < wait {
+			wait = remaining
+		}
+	}
+	if d.timer == nil {
+		d.timer = time.NewTimer(wait)
+		return
+	}
+	// drain the channel if the timer already fired so that
+	// we don't publish early after the reset
+	if !d.timer.Stop() {
+		select {
+		case <-d.timer.C:
+		default:
+		}
+	}
+	d.timer.Reset(wait)
+}
+
+// fired is called after receiving from C, once the change is published
+func (d *debouncer) fired() {
+	d.timer = nil
+}
+
+// stop drops any pending change
+func (d *debouncer) stop() {
+	if d == nil || d.timer == nil {
+		return
+	}
+	d.timer.Stop()
+	d.timer = nil
+}
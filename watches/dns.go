@@ -0,0 +1,99 @@
+package watches
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/joyent/containerpilot/discovery"
+	log "github.com/sirupsen/logrus"
+)
+
+// types of DNS record a dns watch can resolve
+const (
+	recordTypeA   = "A"
+	recordTypeSRV = "SRV"
+)
+
+// dnsTimeout is how long we wait for the resolver before giving up
+// on a check and trying again on the next one
+const dnsTimeout = 5 * time.Second
+
+// checkDNS resolves the watched record and compares the record set
+// against the last check. A dns watch is healthy while there's at
+// least one record.
+func (watch *Watch) checkDNS(published *bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsTimeout)
+	defer cancel()
+	instances, err := watch.resolve(ctx)
+	if err != nil {
+		log.Warnf("%s: failed to resolve %s: %v", watch.Name, watch.record, err)
+		return
+	}
+	watch.resolvedLock.Lock()
+	watch.resolved = instances
+	watch.resolvedLock.Unlock()
+	watch.onCheck(false, len(instances) > 0, published)
+}
+
+// resolve looks up the watched record and returns each record as an
+// instance, sorted by ID. A name that doesn't exist has no records.
+func (watch *Watch) resolve(ctx context.Context) ([]*discovery.ServiceInstance, error) {
+	resolver := &net.Resolver{PreferGo: true}
+	if watch.resolver != "" {
+		resolver.Dial = func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, watch.resolver)
+		}
+	}
+	instances := []*discovery.ServiceInstance{}
+	switch watch.recordType {
+	case recordTypeSRV:
+		_, records, err := resolver.LookupSRV(ctx, "", "", watch.record)
+		if err != nil {
+			if isNotFound(err) {
+				return instances, nil
+			}
+			return nil, err
+		}
+		for _, record := range records {
+			target := strings.TrimSuffix(record.Target, ".")
+			instances = append(instances, &discovery.ServiceInstance{
+				ID:      fmt.Sprintf("%s:%d", target, record.Port),
+				Name:    watch.serviceName,
+				Address: target,
+				Port:    int(record.Port),
+			})
+		}
+	default:
+		addrs, err := resolver.LookupIPAddr(ctx, watch.record)
+		if err != nil {
+			if isNotFound(err) {
+				return instances, nil
+			}
+			return nil, err
+		}
+		for _, addr := range addrs {
+			instances = append(instances, &discovery.ServiceInstance{
+				ID:      addr.IP.String(),
+				Name:    watch.serviceName,
+				Address: addr.IP.String(),
+				Port:    watch.port,
+			})
+		}
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].ID < instances[j].ID
+	})
+	return instances, nil
+}
+
+// isNotFound returns true if the error is because the name doesn't
+// exist, rather than a failure to reach the resolver
+func isNotFound(err error) bool {
+	dnsErr, ok := err.(*net.DNSError)
+	return ok && !dnsErr.Timeout() && strings.Contains(dnsErr.Err, "no such host")
+}
@@ -0,0 +1,199 @@
+package watches
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/joyent/containerpilot/events"
+)
+
+// testDNSServer is a minimal DNS server that answers A and SRV queries
+// over UDP from its records, and NXDOMAIN for names it doesn't have
+type testDNSServer struct {
+	conn    net.PacketConn
+	lock    sync.Mutex
+	records map[string][]interface{} // name to net.IP or *net.SRV
+}
+
+func newTestDNSServer(t *testing.T) *testDNSServer {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &testDNSServer{conn: conn, records: map[string][]interface{}{}}
+	go srv.serve()
+	return srv
+}
+
+func (srv *testDNSServer) setRecords(name string, records ...interface{}) {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	srv.records[name] = records
+}
+
+func (srv *testDNSServer) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := srv.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if resp := srv.answer(buf[:n]); resp != nil {
+			srv.conn.WriteTo(resp, addr)
+		}
+	}
+}
+
+func (srv *testDNSServer) answer(query []byte) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+	// read the name and type of the first question
+	labels := []string{}
+	i := 12
+	for i < len(query) && query[i] != 0 {
+		end := i + 1 + int(query[i])
+		if end > len(query) {
+			return nil
+		}
+		labels = append(labels, string(query[i+1:end]))
+		i = end
+	}
+	i++ // end of name
+	if i+4 > len(query) {
+		return nil
+	}
+	qtype := binary.BigEndian.Uint16(query[i:])
+	question := query[12 : i+4]
+	name := strings.ToLower(strings.Join(labels, "."))
+
+	srv.lock.Lock()
+	records, ok := srv.records[name]
+	srv.lock.Unlock()
+	var answers [][]byte
+	for _, record := range records {
+		var rtype uint16
+		var rdata []byte
+		switch r := record.(type) {
+		case net.IP:
+			if qtype != 1 || r.To4() == nil {
+				continue
+			}
+			rtype, rdata = 1, r.To4()
+		case *net.SRV:
+			if qtype != 33 {
+				continue
+			}
+			rtype = 33
+			rdata = make([]byte, 6)
+			binary.BigEndian.PutUint16(rdata[0:], r.Priority)
+			binary.BigEndian.PutUint16(rdata[2:], r.Weight)
+			binary.BigEndian.PutUint16(rdata[4:], r.Port)
+			for _, label := range strings.Split(strings.TrimSuffix(r.Target, "."), ".") {
+				rdata = append(rdata, byte(len(label)))
+				rdata = append(rdata, label...)
+			}
+			rdata = append(rdata, 0)
+		}
+		// the name is a pointer to the name in the question
+		answer := []byte{0xc0, 12, 0, 0, 0, 1, 0, 0, 0, 60, 0, 0}
+		binary.BigEndian.PutUint16(answer[2:], rtype)
+		binary.BigEndian.PutUint16(answer[10:], uint16(len(rdata)))
+		answers = append(answers, append(answer, rdata...))
+	}
+
+	// the header has the ID of the query and the flags for a
+	// response with recursion desired and available
+	resp := make([]byte, 12)
+	copy(resp, query[:2])
+	flags := uint16(0x8180)
+	if !ok {
+		flags |= 3 // NXDOMAIN
+	}
+	binary.BigEndian.PutUint16(resp[2:], flags)
+	binary.BigEndian.PutUint16(resp[4:], 1)
+	binary.BigEndian.PutUint16(resp[6:], uint16(len(answers)))
+	resp = append(resp, question...)
+	for _, answer := range answers {
+		resp = append(resp, answer...)
+	}
+	return resp
+}
+
+func TestWatchDNS(t *testing.T) {
+	srv := newTestDNSServer(t)
+	defer srv.conn.Close()
+	defer os.Unsetenv("CONTAINERPILOT_DB_ADDRS")
+	srv.setRecords("db.example.test", net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.1"))
+
+	cfg := &Config{Name: "db", Type: "dns", Record: "db.example.test",
+		Port: 5432, Resolver: srv.conn.LocalAddr().String(), Poll: 1}
+	assert.Nil(t, cfg.Validate(nil))
+	watch := NewWatch(cfg)
+	bus := events.NewEventBus()
+	watch.Register(bus)
+	defer watch.Unregister()
+	published := false
+	check := func() map[events.Event]int {
+		watch.check(&published)
+		got := map[events.Event]int{}
+		for _, event := range bus.DebugEvents() {
+			got[event]++
+		}
+		return got
+	}
+	changed := events.Event{events.StatusChanged, "watch.db"}
+	healthy := events.Event{events.StatusHealthy, "watch.db"}
+	unhealthy := events.Event{events.StatusUnhealthy, "watch.db"}
+
+	got := check()
+	if got[changed] != 1 || got[healthy] != 1 {
+		t.Fatalf("expected changed and healthy events but got %v", got)
+	}
+	assert.Equal(t, "10.0.0.1:5432,10.0.0.2:5432", os.Getenv("CONTAINERPILOT_DB_ADDRS"))
+
+	got = check()
+	assert.Equal(t, 0, got[changed], "expected no change for same records")
+
+	srv.setRecords("db.example.test", net.ParseIP("10.0.0.1"))
+	got = check()
+	if got[changed] != 1 || got[healthy] != 1 {
+		t.Fatalf("expected changed event for removed record but got %v", got)
+	}
+	assert.Equal(t, 1, len(watch.Changes().Removed))
+
+	srv.lock.Lock()
+	delete(srv.records, "db.example.test")
+	srv.lock.Unlock()
+	got = check()
+	if got[changed] != 1 || got[unhealthy] != 1 {
+		t.Fatalf("expected unhealthy event for missing name but got %v", got)
+	}
+}
+
+func TestWatchDNSSRV(t *testing.T) {
+	srv := newTestDNSServer(t)
+	defer srv.conn.Close()
+	defer os.Unsetenv("CONTAINERPILOT_DB_ADDRS")
+	srv.setRecords("_db._tcp.example.test",
+		&net.SRV{Target: "db-1.example.test.", Port: 5432, Priority: 1, Weight: 1},
+		&net.SRV{Target: "db-2.example.test.", Port: 5433, Priority: 1, Weight: 1})
+
+	cfg := &Config{Name: "db", Type: "dns", Record: "_db._tcp.example.test",
+		RecordType: "SRV", Resolver: srv.conn.LocalAddr().String(), Poll: 1}
+	assert.Nil(t, cfg.Validate(nil))
+	watch := NewWatch(cfg)
+	bus := events.NewEventBus()
+	watch.Register(bus)
+	defer watch.Unregister()
+	published := false
+	watch.check(&published)
+	assert.Equal(t, "db-1.example.test:5432,db-2.example.test:5433",
+		os.Getenv("CONTAINERPILOT_DB_ADDRS"))
+}
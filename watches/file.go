@@ -0,0 +1,154 @@
+package watches
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+// checkFile compares the contents of the watched path against the last
+// check. A file watch is healthy while the path exists.
+func (watch *Watch) checkFile(published *bool) {
+	fingerprint, err := fileFingerprint(watch.path)
+	if err != nil {
+		log.Warnf("%s: failed to read %s: %v", watch.Name, watch.path, err)
+		return
+	}
+	didChange := !fingerprintsEqual(watch.fingerprint, fingerprint)
+	watch.fingerprint = fingerprint
+	watch.onCheck(didChange, fingerprint != nil, published)
+}
+
+// runFileWatcher sends on notify whenever there's a filesystem event that
+// might have changed the watched path, until the context is canceled. The
+// watch checks the path itself, so notifications are dropped if there's
+// already one waiting. It also sends once when it starts watching, so that
+// the first check finds the path as it was when we started watching it,
+// even if there's no poll interval.
+//
+// We watch the directory that holds the path rather than the path itself
+// so that we see the path being created, deleted, or replaced by a rename.
+// This also covers the symlink swaps Kubernetes uses to update secret and
+// configmap volumes, where the files are symlinks through a '..data'
+// symlink that's atomically replaced by a rename in the same directory.
+func (watch *Watch) runFileWatcher(ctx context.Context, notify chan<- struct{}) {
+	sendNotify := func() {
+		select {
+		case notify <- struct{}{}:
+		default:
+		}
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Errorf("%s: could not watch %s for changes: %v",
+			watch.Name, watch.path, err)
+		sendNotify() // we can still check the path once
+		return
+	}
+	defer watcher.Close()
+	if err := watcher.Add(filepath.Dir(watch.path)); err != nil {
+		log.Errorf("%s: could not watch %s for changes: %v",
+			watch.Name, watch.path, err)
+		sendNotify() // we can still check the path once
+		return
+	}
+	// if the path is a directory we also need to see changes to the
+	// files in it. Adding a directory that's already watched is a no-op,
+	// so we try again after each event in case it's been (re)created.
+	watcher.Add(watch.path)
+	sendNotify()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			log.Debugf("%s: %v", watch.Name, event)
+			watcher.Add(watch.path)
+			sendNotify()
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Warnf("%s: error watching %s: %v", watch.Name, watch.path, err)
+		}
+	}
+}
+
+// fileFingerprint returns the SHA-256 of the contents of each file at the
+// path, following symlinks: just the path if it's a file, or each file
+// directly in the path if it's a directory. Entries whose names start with
+// '..' are skipped because Kubernetes uses them for the internals of its
+// volumes. Returns nil if the path doesn't exist.
+func fileFingerprint(path string) (map[string]string, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		sum, err := fileSum(path)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]string{filepath.Base(path): sum}, nil
+	}
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint := map[string]string{}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), "..") {
+			continue
+		}
+		entryPath := filepath.Join(path, entry.Name())
+		// ReadDir doesn't follow symlinks
+		info, err := os.Stat(entryPath)
+		if err != nil || info.IsDir() {
+			// a dangling symlink or an entry deleted since we read
+			// the directory will be caught by the next check
+			continue
+		}
+		sum, err := fileSum(entryPath)
+		if err != nil {
+			continue
+		}
+		fingerprint[entry.Name()] = sum
+	}
+	return fingerprint, nil
+}
+
+func fileSum(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// fingerprintsEqual returns true if both fingerprints have the same files
+// with the same contents, or if the path didn't exist for either of them
+func fingerprintsEqual(a, b map[string]string) bool {
+	if (a == nil) != (b == nil) || len(a) != len(b) {
+		return false
+	}
+	for name, sum := range a {
+		if b[name] != sum {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,136 @@
+package watches
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/joyent/containerpilot/events"
+)
+
+// writeKubernetesVolume lays out a directory the way Kubernetes updates
+// secret volumes: the files are symlinks through the '..data' symlink,
+// which is atomically swapped to a new directory by a rename
+func writeKubernetesVolume(t *testing.T, dir, version, content string) {
+	versionDir := filepath.Join(dir, "..v"+version)
+	if err := os.Mkdir(versionDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(versionDir, "tls.crt"), []byte(content), 0644)
+	tmpLink := filepath.Join(dir, "..data_tmp")
+	if err := os.Symlink("..v"+version, tmpLink); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmpLink, filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	os.Symlink("..data/tls.crt", filepath.Join(dir, "tls.crt"))
+}
+
+func TestFileFingerprint(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "watches-test")
+	defer os.RemoveAll(dir)
+
+	fp, err := fileFingerprint(filepath.Join(dir, "tls.crt"))
+	assert.Nil(t, err)
+	assert.Nil(t, fp, "expected nil fingerprint for missing path")
+
+	writeKubernetesVolume(t, dir, "1", "cert1")
+	fileFP1, _ := fileFingerprint(filepath.Join(dir, "tls.crt"))
+	dirFP1, _ := fileFingerprint(dir)
+	assert.Equal(t, 1, len(dirFP1), "expected '..' entries to be skipped")
+	assert.True(t, fingerprintsEqual(fileFP1, dirFP1))
+
+	writeKubernetesVolume(t, dir, "2", "cert2")
+	fileFP2, _ := fileFingerprint(filepath.Join(dir, "tls.crt"))
+	dirFP2, _ := fileFingerprint(dir)
+	assert.False(t, fingerprintsEqual(fileFP1, fileFP2), "expected swap to change file")
+	assert.False(t, fingerprintsEqual(dirFP1, dirFP2), "expected swap to change dir")
+
+	empty, _ := ioutil.TempDir("", "watches-test")
+	defer os.RemoveAll(empty)
+	emptyFP, _ := fileFingerprint(empty)
+	assert.False(t, fingerprintsEqual(nil, emptyFP),
+		"expected empty directory to differ from missing path")
+}
+
+func TestWatchFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "watches-test")
+	defer os.RemoveAll(dir)
+	// files are written by rename so that the watch never sees
+	// a partly written file, which would be an extra change
+	staging := filepath.Join(dir, "staging")
+	os.Mkdir(staging, 0755)
+	writeFile := func(path, content string) {
+		tmp := filepath.Join(staging, filepath.Base(path))
+		ioutil.WriteFile(tmp, []byte(content), 0644)
+		os.Rename(tmp, path)
+	}
+	cfg := &Config{Name: "certs", Type: "file", Path: filepath.Join(dir, "tls.crt")}
+	assert.Nil(t, cfg.Validate(nil))
+	watch := NewWatch(cfg)
+	bus := events.NewEventBus()
+	watch.Run(context.Background(), bus)
+
+	// wait for the file watcher to start before making changes
+	time.Sleep(100 * time.Millisecond)
+	writeFile(cfg.Path, "cert1")
+	time.Sleep(100 * time.Millisecond)
+	writeFile(cfg.Path, "cert2")
+	time.Sleep(100 * time.Millisecond)
+	os.Remove(cfg.Path)
+	time.Sleep(100 * time.Millisecond)
+	watch.Receive(events.QuitByTest)
+	bus.Wait()
+
+	got := map[events.Event]int{}
+	for _, event := range bus.DebugEvents() {
+		got[event]++
+	}
+	changed := events.Event{events.StatusChanged, "watch.certs"}
+	healthy := events.Event{events.StatusHealthy, "watch.certs"}
+	unhealthy := events.Event{events.StatusUnhealthy, "watch.certs"}
+	if got[changed] != 3 || got[healthy] != 2 || got[unhealthy] != 1 {
+		t.Fatalf("expected events for create, modify, and delete but got %v", got)
+	}
+}
+
+func TestWatchFileExisting(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "watches-test")
+	defer os.RemoveAll(dir)
+	cfg := &Config{Name: "certs", Type: "file", Path: filepath.Join(dir, "tls.crt")}
+	assert.Nil(t, cfg.Validate(nil))
+	ioutil.WriteFile(cfg.Path, []byte("cert1"), 0644)
+
+	// there's no poll interval, so the watch has to find the file by
+	// itself, and changes to other files in the directory aren't changes
+	// to the watched file
+	for _, touchSibling := range []bool{false, true} {
+		watch := NewWatch(cfg)
+		bus := events.NewEventBus()
+		watch.Run(context.Background(), bus)
+		time.Sleep(100 * time.Millisecond)
+		if touchSibling {
+			ioutil.WriteFile(filepath.Join(dir, "tls.key"), []byte("key1"), 0644)
+			time.Sleep(100 * time.Millisecond)
+		}
+		watch.Receive(events.QuitByTest)
+		bus.Wait()
+
+		got := map[events.Event]int{}
+		for _, event := range bus.DebugEvents() {
+			got[event]++
+		}
+		changed := events.Event{events.StatusChanged, "watch.certs"}
+		healthy := events.Event{events.StatusHealthy, "watch.certs"}
+		if got[changed] != 1 || got[healthy] != 1 {
+			t.Fatalf("expected events for the existing file only "+
+				"(touched sibling: %v) but got %v", touchSibling, got)
+		}
+	}
+}
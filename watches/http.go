@@ -0,0 +1,112 @@
+package watches
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxHTTPBody is the most of a response body an http watch will read
+const maxHTTPBody = 1 << 20
+
+// checkHTTP requests the watched URL and compares the response body, or
+// the value at the watch's JSON path in the body, against the last check.
+// An http watch is healthy while the URL responds with a 2xx status, and
+// a change of health is also a change.
+func (watch *Watch) checkHTTP(published *bool) {
+	status, value, err := watch.fetch()
+	if err != nil {
+		log.Warnf("%s: failed to check %s: %v", watch.Name, watch.url, err)
+	}
+	isHealthy := err == nil && status >= 200 && status < 300
+	sum := sha256.Sum256([]byte(value))
+	fingerprint := hex.EncodeToString(sum[:])
+	if err != nil {
+		// keep the last value so that we see a change if the
+		// URL comes back with something different
+		fingerprint = watch.httpFingerprint
+		value = watch.httpValue
+	}
+	didChange := fingerprint != watch.httpFingerprint || (*published && isHealthy != watch.isHealthy)
+	watch.httpFingerprint = fingerprint
+	watch.httpStatus = status
+	watch.httpValue = value
+	watch.onCheck(didChange, isHealthy, published)
+}
+
+// fetch requests the watched URL and returns the status code and either
+// the body or, if the watch has a JSON path, the value at that path
+func (watch *Watch) fetch() (int, string, error) {
+	resp, err := watch.httpClient.Get(watch.url)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxHTTPBody))
+	if err != nil {
+		return resp.StatusCode, "", err
+	}
+	if watch.jsonPath == "" {
+		return resp.StatusCode, string(body), nil
+	}
+	var data interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return resp.StatusCode, "", fmt.Errorf("could not parse response as JSON: %v", err)
+	}
+	value, ok := lookupJSONPath(data, watch.jsonPath)
+	if !ok {
+		return resp.StatusCode, "", nil
+	}
+	if str, ok := value.(string); ok {
+		return resp.StatusCode, str, nil
+	}
+	valueJSON, err := json.Marshal(value)
+	if err != nil {
+		return resp.StatusCode, "", err
+	}
+	return resp.StatusCode, string(valueJSON), nil
+}
+
+// lookupJSONPath returns the value at a path of object keys and array
+// indexes separated by dots, ex. "services.0.status"
+func lookupJSONPath(data interface{}, path string) (interface{}, bool) {
+	for _, part := range strings.Split(path, ".") {
+		switch v := data.(type) {
+		case map[string]interface{}:
+			value, ok := v[part]
+			if !ok {
+				return nil, false
+			}
+			data = value
+		case []interface{}:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			data = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return data, true
+}
+
+// publishHTTP makes the response of an http watch available to jobs by
+// setting the CONTAINERPILOT_<WATCH>_STATUS environment variable to the
+// status code, and CONTAINERPILOT_<WATCH>_VALUE to the value at the JSON
+// path if the watch has one
+func (watch *Watch) publishHTTP() error {
+	prefix := getEnvVarPrefix(watch.serviceName)
+	env := [][2]string{{prefix + "_STATUS", strconv.Itoa(watch.httpStatus)}}
+	if watch.jsonPath != "" {
+		env = append(env, [2]string{prefix + "_VALUE", watch.httpValue})
+	}
+	return watch.publishEnv(env, nil)
+}
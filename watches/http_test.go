@@ -0,0 +1,92 @@
+package watches
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/joyent/containerpilot/events"
+)
+
+func TestWatchHTTP(t *testing.T) {
+	defer os.Unsetenv("CONTAINERPILOT_FLAGS_STATUS")
+	defer os.Unsetenv("CONTAINERPILOT_FLAGS_VALUE")
+	var lock sync.Mutex
+	status, enabled, version := 200, true, 1
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			defer lock.Unlock()
+			w.WriteHeader(status)
+			fmt.Fprintf(w, `{"version": %d, "flags": [{"enabled": %v}]}`,
+				version, enabled)
+		}))
+	defer server.Close()
+	set := func(s int, e bool, v int) {
+		lock.Lock()
+		defer lock.Unlock()
+		status, enabled, version = s, e, v
+	}
+
+	cfg := &Config{Name: "flags", Type: "http", URL: server.URL,
+		JSONPath: "flags.0.enabled", Poll: 1}
+	assert.Nil(t, cfg.Validate(nil))
+	watch := NewWatch(cfg)
+	bus := events.NewEventBus()
+	watch.Register(bus)
+	defer watch.Unregister()
+	published := false
+	check := func() map[events.Event]int {
+		watch.check(&published)
+		got := map[events.Event]int{}
+		for _, event := range bus.DebugEvents() {
+			got[event]++
+		}
+		return got
+	}
+	changed := events.Event{events.StatusChanged, "watch.flags"}
+	healthy := events.Event{events.StatusHealthy, "watch.flags"}
+	unhealthy := events.Event{events.StatusUnhealthy, "watch.flags"}
+
+	got := check()
+	if got[changed] != 1 || got[healthy] != 1 {
+		t.Fatalf("expected changed and healthy events but got %v", got)
+	}
+	assert.Equal(t, "200", os.Getenv("CONTAINERPILOT_FLAGS_STATUS"))
+	assert.Equal(t, "true", os.Getenv("CONTAINERPILOT_FLAGS_VALUE"))
+
+	// changes outside the JSON path aren't changes
+	set(200, true, 2)
+	got = check()
+	assert.Equal(t, 0, got[changed], "expected no change outside the JSON path")
+
+	set(200, false, 2)
+	got = check()
+	assert.Equal(t, 1, got[changed], "expected change of value at the JSON path")
+	assert.Equal(t, "false", os.Getenv("CONTAINERPILOT_FLAGS_VALUE"))
+
+	set(503, false, 2)
+	got = check()
+	if got[changed] != 1 || got[unhealthy] != 1 {
+		t.Fatalf("expected unhealthy event for error status but got %v", got)
+	}
+	assert.Equal(t, "503", os.Getenv("CONTAINERPILOT_FLAGS_STATUS"))
+}
+
+func TestLookupJSONPath(t *testing.T) {
+	var data interface{} = map[string]interface{}{
+		"a": []interface{}{"x", map[string]interface{}{"b": 1.0}},
+	}
+	value, ok := lookupJSONPath(data, "a.1.b")
+	assert.True(t, ok)
+	assert.Equal(t, 1.0, value)
+	_, ok = lookupJSONPath(data, "a.2")
+	assert.False(t, ok, "expected index out of range to be missing")
+	_, ok = lookupJSONPath(data, "a.0.b")
+	assert.False(t, ok, "expected key of a string to be missing")
+}
@@ -0,0 +1,135 @@
+package watches
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/joyent/containerpilot/config/files"
+	"github.com/joyent/containerpilot/discovery"
+)
+
+// formats of the file a watch writes with its instances
+const (
+	fileFormatJSON = "json"
+	fileFormatEnv  = "env"
+)
+
+// Instances returns the healthy instances of the watched service found
+// by the last poll, or the records found by a dns watch. Other types of
+// watch have no instances.
+func (watch *Watch) Instances() []*discovery.ServiceInstance {
+	switch watch.watchType {
+	case watchTypeService:
+		return watch.discoveryService.ServiceInstances(watch.serviceName)
+	case watchTypeDNS:
+		watch.resolvedLock.RLock()
+		defer watch.resolvedLock.RUnlock()
+		return watch.resolved
+	default:
+		return []*discovery.ServiceInstance{}
+	}
+}
+
+// publishInstances makes the addresses of the instances of the watched
+// service that were last published available to jobs by setting the
+// CONTAINERPILOT_<WATCH>_ADDRS environment variable, and writes the
+// watch's file if it has one. The full instances and the changes since
+// the publish before them are only written to the file, because our
+// environment is passed to every process we start and a large service
+// would make it too big to exec.
+func (watch *Watch) publishInstances() error {
+	env, err := instancesEnv(watch.serviceName, watch.publishedInstances, watch.Changes())
+	if err != nil {
+		return err
+	}
+	setEnv(env[:1])
+	return watch.writeFile(env, watch.publishedInstances)
+}
+
+// publishEnv sets the environment variables, which are inherited by any
+// process started afterwards, and writes the watch's file if it has one
+func (watch *Watch) publishEnv(env [][2]string, fileData interface{}) error {
+	setEnv(env)
+	return watch.writeFile(env, fileData)
+}
+
+// ClearEnv unsets the environment variables the watch publishes, so that
+// a watch that's removed by a reload doesn't leave them behind
+func (watch *Watch) ClearEnv() {
+	prefix := getEnvVarPrefix(watch.serviceName)
+	for _, suffix := range []string{"_ADDRS", "_STATUS", "_VALUE", "_VALUES"} {
+		os.Unsetenv(prefix + suffix)
+	}
+}
+
+func setEnv(env [][2]string) {
+	for _, kv := range env {
+		os.Setenv(kv[0], kv[1])
+	}
+}
+
+// writeFile writes the watch's file if it has one: either the environment
+// variables or fileData as JSON, depending on the watch's file format
+func (watch *Watch) writeFile(env [][2]string, fileData interface{}) error {
+	if watch.file == "" {
+		return nil
+	}
+	var data []byte
+	switch watch.fileFormat {
+	case fileFormatEnv:
+		var buf bytes.Buffer
+		for _, kv := range env {
+			fmt.Fprintf(&buf, "%s=%s\n", kv[0], shellQuote(kv[1]))
+		}
+		data = buf.Bytes()
+	default:
+		var err error
+		data, err = json.MarshalIndent(fileData, "", "  ")
+		if err != nil {
+			return err
+		}
+		data = append(data, '\n')
+	}
+	return files.WriteAtomic(watch.file, data, 0644, -1, -1)
+}
+
+// instancesEnv returns the environment variable names and values for the
+// instances of a service: a comma-separated list of address:port, the
+// full list of instances as JSON, and the changes as JSON
+func instancesEnv(service string, instances []*discovery.ServiceInstance,
+	changes *Changes) ([][2]string, error) {
+	addrs := make([]string, len(instances))
+	for i, instance := range instances {
+		addrs[i] = fmt.Sprintf("%s:%d", instance.Address, instance.Port)
+	}
+	instancesJSON, err := json.Marshal(instances)
+	if err != nil {
+		return nil, err
+	}
+	changesJSON, err := json.Marshal(changes)
+	if err != nil {
+		return nil, err
+	}
+	prefix := getEnvVarPrefix(service)
+	return [][2]string{
+		{prefix + "_ADDRS", strings.Join(addrs, ",")},
+		{prefix + "_INSTANCES", string(instancesJSON)},
+		{prefix + "_CHANGES", string(changesJSON)},
+	}, nil
+}
+
+// getEnvVarPrefix normalizes the validated service name for use in an
+// environment variable, ex. "my-app" becomes "CONTAINERPILOT_MY_APP"
+func getEnvVarPrefix(service string) string {
+	envKey := strings.ToUpper(service)
+	envKey = strings.Replace(envKey, "-", "_", -1)
+	return "CONTAINERPILOT_" + envKey
+}
+
+// shellQuote quotes a value so that the env file can be sourced by a shell
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,52 @@
+package watches
+
+import (
+	"encoding/json"
+
+	"github.com/joyent/containerpilot/discovery"
+	log "github.com/sirupsen/logrus"
+)
+
+// checkKV checks the watched key or prefix in the discovery backend's
+// key/value store for changes. A kv watch is healthy while there's at
+// least one key.
+func (watch *Watch) checkKV(published *bool) {
+	didChange, err := watch.discoveryService.CheckForKVChanges(
+		watch.key, watch.recurse, watch.dc)
+	if err != nil {
+		log.Warnf("%s: failed to query key %s: %v", watch.Name, watch.key, err)
+		return
+	}
+	watch.onCheck(didChange, len(watch.KVPairs()) > 0, published)
+}
+
+// KVPairs returns the keys found by the last check of a kv watch
+func (watch *Watch) KVPairs() []*discovery.KVPair {
+	if watch.watchType != watchTypeKV {
+		return nil
+	}
+	return watch.discoveryService.KVPairs(watch.key)
+}
+
+// publishKV makes the values of the watched keys available to jobs by
+// setting the CONTAINERPILOT_<WATCH>_VALUES environment variable, and
+// CONTAINERPILOT_<WATCH>_VALUE if the watch is for a single key, and by
+// writing the watch's file if it has one.
+func (watch *Watch) publishKV() error {
+	pairs := watch.KVPairs()
+	values := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		values[pair.Key] = pair.Value
+	}
+	valuesJSON, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	prefix := getEnvVarPrefix(watch.serviceName)
+	env := [][2]string{}
+	if !watch.recurse {
+		env = append(env, [2]string{prefix + "_VALUE", values[watch.key]})
+	}
+	env = append(env, [2]string{prefix + "_VALUES", string(valuesJSON)})
+	return watch.publishEnv(env, values)
+}
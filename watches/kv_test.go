@@ -0,0 +1,69 @@
+package watches
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/joyent/containerpilot/discovery"
+	"github.com/joyent/containerpilot/events"
+	"github.com/joyent/containerpilot/tests/mocks"
+)
+
+func TestWatchKV(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "watches-test")
+	defer os.RemoveAll(dir)
+	defer os.Unsetenv("CONTAINERPILOT_FLAGS_VALUES")
+	disc := &mocks.NoopDiscoveryBackend{KV: map[string][]*discovery.KVPair{
+		"app/flags/": {{Key: "app/flags/a", Value: "on", ModifyIndex: 1}},
+	}}
+	newConfig := func() *Config {
+		return &Config{
+			Name:    "flags",
+			Type:    "kv",
+			Key:     "app/flags/",
+			Recurse: true,
+			Poll:    1,
+			File:    filepath.Join(dir, "flags.json"),
+		}
+	}
+	cfg := newConfig()
+	got := runWatchTest(cfg, 5, disc)
+	changed := events.Event{events.StatusChanged, "watch.flags"}
+	healthy := events.Event{events.StatusHealthy, "watch.flags"}
+	if got[changed] != 1 || got[healthy] != 1 {
+		t.Fatalf("expected changed and healthy events for new key but got %v", got)
+	}
+	assert.Equal(t, `{"app/flags/a":"on"}`, os.Getenv("CONTAINERPILOT_FLAGS_VALUES"))
+	data, _ := ioutil.ReadFile(cfg.File)
+	assert.Equal(t, "{\n  \"app/flags/a\": \"on\"\n}\n", string(data))
+
+	// the same value with a new ModifyIndex is still a change
+	disc.KV["app/flags/"] = []*discovery.KVPair{
+		{Key: "app/flags/a", Value: "on", ModifyIndex: 2}}
+	got = runWatchTest(newConfig(), 5, disc)
+	if got[changed] != 1 {
+		t.Fatalf("expected changed event for modified key but got %v", got)
+	}
+}
+
+func TestWatchKVSingleKey(t *testing.T) {
+	defer os.Unsetenv("CONTAINERPILOT_MODE_VALUE")
+	defer os.Unsetenv("CONTAINERPILOT_MODE_VALUES")
+	disc := &mocks.NoopDiscoveryBackend{KV: map[string][]*discovery.KVPair{
+		"app/mode": {{Key: "app/mode", Value: "blue", ModifyIndex: 1}},
+	}}
+	runWatchTest(&Config{Name: "mode", Type: "kv", Key: "app/mode", Poll: 1}, 5, disc)
+	assert.Equal(t, "blue", os.Getenv("CONTAINERPILOT_MODE_VALUE"))
+
+	delete(disc.KV, "app/mode")
+	got := runWatchTest(&Config{Name: "mode", Type: "kv", Key: "app/mode", Poll: 1}, 5, disc)
+	unhealthy := events.Event{events.StatusUnhealthy, "watch.mode"}
+	if got[unhealthy] != 1 {
+		t.Fatalf("expected unhealthy event for deleted key but got %v", got)
+	}
+	assert.Equal(t, "", os.Getenv("CONTAINERPILOT_MODE_VALUE"))
+}
@@ -0,0 +1,38 @@
+package watches
+
+import (
+	"github.com/joyent/containerpilot/events"
+)
+
+// publishThresholds publishes events for each of the watch's thresholds
+// that the count of published instances has crossed since the last time
+// they were published. The first time, the watch doesn't know which side
+// of a threshold it was on, so it publishes an event for each threshold.
+func (watch *Watch) publishThresholds() {
+	if watch.thresholds == nil {
+		return
+	}
+	count := len(watch.publishedInstances)
+	last := watch.lastCount
+	watch.lastCount = count
+	if min := watch.thresholds.Min; min > 0 {
+		isBelow := count < min
+		if last < 0 || isBelow != (last < min) {
+			if isBelow {
+				watch.Publish(events.Event{events.BelowMin, watch.Name})
+			} else {
+				watch.Publish(events.Event{events.AboveMin, watch.Name})
+			}
+		}
+	}
+	if max := watch.thresholds.Max; max > 0 {
+		isAbove := count > max
+		if last < 0 || isAbove != (last > max) {
+			if isAbove {
+				watch.Publish(events.Event{events.AboveMax, watch.Name})
+			} else {
+				watch.Publish(events.Event{events.BelowMax, watch.Name})
+			}
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package watches
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/joyent/containerpilot/discovery"
+	"github.com/joyent/containerpilot/events"
+	"github.com/joyent/containerpilot/tests/mocks"
+)
+
+func TestWatchThresholds(t *testing.T) {
+	disc := &mocks.NoopDiscoveryBackend{Val: true}
+	cfg := &Config{Name: "etcd", Poll: 1,
+		Thresholds: &ThresholdsConfig{Min: 2, Max: 3}}
+	assert.Nil(t, cfg.Validate(disc))
+	watch := NewWatch(cfg)
+	bus := events.NewEventBus()
+	watch.Register(bus)
+	defer watch.Unregister()
+
+	published := false
+	check := func(count int) []events.EventCode {
+		disc.Instances = []*discovery.ServiceInstance{}
+		for i := 0; i < count; i++ {
+			disc.Instances = append(disc.Instances,
+				&discovery.ServiceInstance{ID: fmt.Sprintf("etcd-%d", i)})
+		}
+		watch.onCheck(false, count > 0, &published)
+		got := []events.EventCode{}
+		for _, event := range bus.DebugEvents() {
+			switch event.Code {
+			case events.BelowMin, events.AboveMin, events.BelowMax, events.AboveMax:
+				assert.Equal(t, "watch.etcd", event.Source)
+				got = append(got, event.Code)
+			}
+		}
+		return got
+	}
+	assert.Equal(t, []events.EventCode{events.BelowMin, events.BelowMax}, check(1),
+		"expected events for both thresholds on first check")
+	assert.Equal(t, []events.EventCode{events.AboveMin}, check(2))
+	assert.Equal(t, []events.EventCode{}, check(3))
+	assert.Equal(t, []events.EventCode{events.AboveMax}, check(4))
+	assert.Equal(t, []events.EventCode{events.BelowMin, events.BelowMax}, check(1))
+}
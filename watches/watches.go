@@ -4,22 +4,64 @@ package watches
 
 import (
 	"context"
+	"net/http"
+	"sync"
 	"time"
 
 	"github.com/joyent/containerpilot/discovery"
 	"github.com/joyent/containerpilot/events"
+	log "github.com/sirupsen/logrus"
 )
 
 // Watch represents an event to signal when something changes
 type Watch struct {
 	Name             string
 	serviceName      string
+	watchType        string
+	key              string
+	recurse          bool
+	path             string
+	record           string
+	recordType       string
+	resolver         string
+	port             int
+	url              string
+	jsonPath         string
+	httpClient       *http.Client
 	tag              string
 	dc               string
 	poll             int
+	file             string
+	fileFormat       string
+	blocking         bool
+	compare          []string
+	debouncer        *debouncer
+	thresholds       *ThresholdsConfig
 	discoveryService discovery.Backend
 	rx               chan events.Event
 
+	// the instances found by the last check, whether the service was
+	// healthy at the last check, and the instances we last published,
+	// which are only used by the event loop
+	instances          []*discovery.ServiceInstance
+	isHealthy          bool
+	publishedInstances []*discovery.ServiceInstance
+	lastCount          int               // count of published instances, -1 before the first
+	fingerprint        map[string]string // contents of a file watch's path
+
+	// the records found by the last check of a dns watch
+	resolved     []*discovery.ServiceInstance
+	resolvedLock sync.RWMutex
+
+	// the response found by the last check of an http watch
+	httpFingerprint string
+	httpStatus      int
+	httpValue       string
+
+	// the changes between the last two publishes of the instances
+	changes     *Changes
+	changesLock sync.RWMutex
+
 	events.Publisher
 }
 
@@ -30,9 +72,27 @@ func NewWatch(cfg *Config) *Watch {
 	watch := &Watch{
 		Name:             cfg.Name,
 		serviceName:      cfg.serviceName,
+		watchType:        cfg.Type,
+		key:              cfg.Key,
+		recurse:          cfg.Recurse,
+		path:             cfg.Path,
+		record:           cfg.Record,
+		recordType:       cfg.RecordType,
+		resolver:         cfg.Resolver,
+		port:             cfg.Port,
+		url:              cfg.URL,
+		jsonPath:         cfg.JSONPath,
+		httpClient:       &http.Client{Timeout: cfg.timeout},
 		tag:              cfg.Tag,
 		dc:               cfg.DC,
 		poll:             cfg.Poll,
+		file:             cfg.File,
+		fileFormat:       cfg.FileFormat,
+		blocking:         cfg.Blocking,
+		compare:          cfg.Compare,
+		debouncer:        newDebouncer(cfg.debounce, cfg.maxWait),
+		thresholds:       cfg.Thresholds,
+		lastCount:        -1,
 		discoveryService: cfg.discoveryService,
 	}
 	// watch.InitRx()
@@ -68,14 +128,32 @@ func (watch *Watch) Run(pctx context.Context, bus *events.EventBus) {
 	timerSource := watch.Name + ".poll"
 
 	// TODO(justinwr@): this could be replaced by a simple Ticker
-	events.NewEventTimer(ctx, watch.rx, watch.Tick(), timerSource)
+	if watch.poll > 0 {
+		events.NewEventTimer(ctx, watch.rx, watch.Tick(), timerSource)
+	}
+
+	// with blocking queries the poll timer is only a safety re-sync, and
+	// the results of the queries are handled in the event loop below so
+	// that the state of the watch is only updated in one goroutine
+	var results chan checkResult
+	if watch.blocking {
+		results = make(chan checkResult)
+		go watch.runBlockingQueries(ctx, results)
+	}
+	var fileChanges chan struct{}
+	if watch.watchType == watchTypeFile {
+		fileChanges = make(chan struct{}, 1)
+		go watch.runFileWatcher(ctx, fileChanges)
+	}
 
 	go func() {
 		defer func() {
 			cancel()
+			watch.debouncer.stop()
 			watch.Unregister()
 			watch.Wait()
 		}()
+		published := false
 		for {
 			select {
 			case event, ok := <-watch.rx:
@@ -83,18 +161,15 @@ func (watch *Watch) Run(pctx context.Context, bus *events.EventBus) {
 					return
 				}
 				if event == (events.Event{events.TimerExpired, timerSource}) {
-					didChange, isHealthy := watch.CheckForUpstreamChanges()
-					if didChange {
-						watch.Publish(events.Event{events.StatusChanged, watch.Name})
-						// we only send the StatusHealthy and StatusUnhealthy
-						// events if there was a change
-						if isHealthy {
-							watch.Publish(events.Event{events.StatusHealthy, watch.Name})
-						} else {
-							watch.Publish(events.Event{events.StatusUnhealthy, watch.Name})
-						}
-					}
+					watch.check(&published)
 				}
+			case result := <-results:
+				watch.onCheck(result.didChange, result.isHealthy, &published)
+			case <-fileChanges:
+				watch.check(&published)
+			case <-watch.debouncer.C():
+				watch.debouncer.fired()
+				watch.publishChange(true)
 			case <-ctx.Done():
 				return
 			}
@@ -102,6 +177,102 @@ func (watch *Watch) Run(pctx context.Context, bus *events.EventBus) {
 	}()
 }
 
+// check polls the discovery backend, the filesystem, DNS, or a URL for
+// the watch's type
+func (watch *Watch) check(published *bool) {
+	switch watch.watchType {
+	case watchTypeKV:
+		watch.checkKV(published)
+	case watchTypeFile:
+		watch.checkFile(published)
+	case watchTypeDNS:
+		watch.checkDNS(published)
+	case watchTypeHTTP:
+		watch.checkHTTP(published)
+	default:
+		didChange, isHealthy := watch.CheckForUpstreamChanges()
+		watch.onCheck(didChange, isHealthy, published)
+	}
+}
+
+// onCheck publishes the values and events for the result of a check for
+// upstream changes. The discovery backend only reports changes to the
+// address and port of instances, so for service watches we also compare
+// the instances against the last check on the watch's configured fields.
+// If the watch has a debounce, changes are held until the watched service
+// or keys have been quiet for a while.
+func (watch *Watch) onCheck(didChange, isHealthy bool, published *bool) {
+	if watch.watchType == watchTypeService || watch.watchType == watchTypeDNS {
+		instances := watch.Instances()
+		if !diffInstances(watch.instances, instances, watch.compare).IsEmpty() {
+			didChange = true
+		}
+		watch.instances = instances
+	}
+	watch.isHealthy = isHealthy
+	switch {
+	case !*published:
+		// the first check isn't debounced so that jobs waiting
+		// on the watch at startup don't have to wait any longer
+		watch.publishChange(didChange)
+		*published = true
+	case didChange && watch.debouncer != nil:
+		watch.debouncer.change(time.Now())
+	case didChange:
+		watch.publishChange(true)
+	}
+}
+
+// publishChange publishes the values found by the last check, such as
+// the instances of a service and the changes since they were last
+// published, and then the events for the change if there was one and for
+// any thresholds that were crossed
+func (watch *Watch) publishChange(didChange bool) {
+	// the values are published before the events so
+	// that jobs started by the events will see them
+	switch watch.watchType {
+	case watchTypeKV:
+		if err := watch.publishKV(); err != nil {
+			log.Errorf("%s: failed to publish values: %v", watch.Name, err)
+		}
+	case watchTypeHTTP:
+		if err := watch.publishHTTP(); err != nil {
+			log.Errorf("%s: failed to publish response: %v", watch.Name, err)
+		}
+	case watchTypeService, watchTypeDNS:
+		changes := diffInstances(watch.publishedInstances, watch.instances, watch.compare)
+		watch.publishedInstances = watch.instances
+		watch.changesLock.Lock()
+		watch.changes = changes
+		watch.changesLock.Unlock()
+		if err := watch.publishInstances(); err != nil {
+			log.Errorf("%s: failed to publish instances: %v", watch.Name, err)
+		}
+	}
+	if didChange {
+		watch.Publish(events.Event{events.StatusChanged, watch.Name})
+		// we only send the StatusHealthy and StatusUnhealthy
+		// events if there was a change
+		if watch.isHealthy {
+			watch.Publish(events.Event{events.StatusHealthy, watch.Name})
+		} else {
+			watch.Publish(events.Event{events.StatusUnhealthy, watch.Name})
+		}
+	}
+	watch.publishThresholds()
+}
+
+// Changes returns the changes to the instances of the watched service
+// that were most recently published
+func (watch *Watch) Changes() *Changes {
+	watch.changesLock.RLock()
+	defer watch.changesLock.RUnlock()
+	if watch.changes == nil {
+		return diffInstances(nil, nil, nil)
+	}
+	return watch.changes
+}
+
 // Receive receives an event into the internal control channel.
 func (watch *Watch) Receive(event events.Event) {
 	watch.rx <- event
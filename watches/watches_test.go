@@ -2,8 +2,17 @@ package watches
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
 
 	"github.com/joyent/containerpilot/discovery"
 	"github.com/joyent/containerpilot/events"
@@ -38,6 +47,189 @@ func TestWatchPollFail(t *testing.T) {
 	}
 }
 
+func TestWatchPublishInstances(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "watches-test")
+	defer os.RemoveAll(dir)
+	defer os.Unsetenv("CONTAINERPILOT_MY_APP_ADDRS")
+	disc := &mocks.NoopDiscoveryBackend{
+		Val: true,
+		Instances: []*discovery.ServiceInstance{
+			{ID: "app-1", Name: "my-app", Address: "10.0.0.1", Port: 80,
+				Tags: []string{"prod"}, Node: "node1"},
+			{ID: "app-2", Name: "my-app", Address: "10.0.0.2", Port: 80,
+				Node: "node2"},
+		},
+	}
+	instancesJSON, _ := json.Marshal(disc.Instances)
+	changesJSON, _ := json.Marshal(&Changes{
+		Added:   disc.Instances,
+		Removed: []*discovery.ServiceInstance{},
+		Updated: []*discovery.ServiceInstance{},
+	})
+
+	cfg := &Config{
+		Name:       "my-app",
+		Poll:       1,
+		File:       filepath.Join(dir, "my-app.env"),
+		FileFormat: "env",
+	}
+	runWatchTest(cfg, 5, disc)
+	assert.Equal(t, "10.0.0.1:80,10.0.0.2:80", os.Getenv("CONTAINERPILOT_MY_APP_ADDRS"))
+	_, ok := os.LookupEnv("CONTAINERPILOT_MY_APP_INSTANCES")
+	assert.False(t, ok, "expected instances only in the file")
+	_, ok = os.LookupEnv("CONTAINERPILOT_MY_APP_CHANGES")
+	assert.False(t, ok, "expected changes only in the file")
+	data, _ := ioutil.ReadFile(cfg.File)
+	assert.Equal(t, "CONTAINERPILOT_MY_APP_ADDRS='10.0.0.1:80,10.0.0.2:80'\n"+
+		"CONTAINERPILOT_MY_APP_INSTANCES='"+string(instancesJSON)+"'\n"+
+		"CONTAINERPILOT_MY_APP_CHANGES='"+string(changesJSON)+"'\n", string(data))
+
+	cfg = &Config{
+		Name: "my-app",
+		Poll: 1,
+		File: filepath.Join(dir, "my-app.json"),
+	}
+	runWatchTest(cfg, 5, disc)
+	data, _ = ioutil.ReadFile(cfg.File)
+	assert.Contains(t, string(data), `"Address": "10.0.0.2"`)
+}
+
+// a service with more instances than fit in the environment can still be
+// published, and processes can still be started afterwards
+func TestWatchPublishManyInstances(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "watches-test")
+	defer os.RemoveAll(dir)
+	defer os.Unsetenv("CONTAINERPILOT_BIG_APP_ADDRS")
+	disc := &mocks.NoopDiscoveryBackend{Val: true}
+	for i := 0; i < 2000; i++ {
+		disc.Instances = append(disc.Instances, &discovery.ServiceInstance{
+			ID:      fmt.Sprintf("big-app-%d", i),
+			Name:    "big-app",
+			Address: fmt.Sprintf("10.0.%d.%d", i/256, i%256),
+			Port:    80,
+			Meta:    map[string]string{"version": strings.Repeat("x", 100)},
+		})
+	}
+	cfg := &Config{
+		Name: "big-app",
+		Poll: 1,
+		File: filepath.Join(dir, "big-app.json"),
+	}
+	runWatchTest(cfg, 5, disc)
+	addrs := strings.Split(os.Getenv("CONTAINERPILOT_BIG_APP_ADDRS"), ",")
+	assert.Equal(t, 2000, len(addrs))
+	if err := exec.Command("true").Run(); err != nil {
+		t.Fatalf("expected to start a process after publishing: %v", err)
+	}
+	data, _ := ioutil.ReadFile(cfg.File)
+	var instances []*discovery.ServiceInstance
+	if err := json.Unmarshal(data, &instances); err != nil {
+		t.Fatalf("unexpected error reading watch file: %v", err)
+	}
+	assert.Equal(t, 2000, len(instances))
+}
+
+func TestWatchBlocking(t *testing.T) {
+	cfg := &Config{
+		Name:     "mywatchBlocking",
+		Poll:     100, // long enough that only blocking queries run
+		Blocking: true,
+	}
+	disc := &mocks.NoopDiscoveryBackend{Val: true}
+	cfg.Validate(disc)
+	watch := NewWatch(cfg)
+	bus := events.NewEventBus()
+	watch.Run(context.Background(), bus)
+	// the first query isn't rate limited; give it a moment to return
+	// before we stop the watch
+	time.Sleep(100 * time.Millisecond)
+	watch.Receive(events.QuitByTest)
+	bus.Wait()
+
+	got := map[events.Event]int{}
+	for _, result := range bus.DebugEvents() {
+		got[result]++
+	}
+	changed := events.Event{events.StatusChanged, "watch.mywatchBlocking"}
+	healthy := events.Event{events.StatusHealthy, "watch.mywatchBlocking"}
+	if got[changed] != 1 || got[healthy] != 1 {
+		t.Fatalf("expected changed and healthy events from blocking query but got %v", got)
+	}
+}
+
+func TestNextBackoff(t *testing.T) {
+	backoff := time.Duration(0)
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}
+	for _, exp := range expected {
+		backoff = nextBackoff(backoff)
+		assert.Equal(t, exp, backoff)
+	}
+	assert.Equal(t, blockingMaxBackoff, nextBackoff(blockingMaxBackoff))
+}
+
+func TestWatchDebounce(t *testing.T) {
+	disc := &mocks.NoopDiscoveryBackend{Val: true,
+		Instances: []*discovery.ServiceInstance{
+			{ID: "app-1", Address: "10.0.0.1", Port: 80},
+		}}
+	cfg := &Config{Name: "app", Poll: 1, Debounce: "200ms"}
+	assert.Nil(t, cfg.Validate(disc))
+	watch := NewWatch(cfg)
+	bus := events.NewEventBus()
+	watch.Register(bus)
+	defer watch.Unregister()
+	// DebugEvents drains the bus, so we keep a running count
+	count := 0
+	changed := func() int {
+		for _, event := range bus.DebugEvents() {
+			if event == (events.Event{Code: events.StatusChanged, Source: "watch.app"}) {
+				count++
+			}
+		}
+		return count
+	}
+
+	published := false
+	watch.onCheck(true, true, &published)
+	assert.Equal(t, 1, changed(), "expected first check to publish right away")
+
+	// a rolling deploy replaces the instance one step at a time
+	for _, addr := range []string{"10.0.0.2", "10.0.0.3", "10.0.0.4"} {
+		disc.Instances = []*discovery.ServiceInstance{
+			{ID: "app-1", Address: addr, Port: 80},
+		}
+		watch.onCheck(false, true, &published)
+	}
+	assert.Equal(t, 1, changed(), "expected changes to be held until quiet")
+	assert.Equal(t, "10.0.0.1", watch.Changes().Added[0].Address)
+
+	select {
+	case <-watch.debouncer.C():
+		watch.debouncer.fired()
+		watch.publishChange(true)
+	case <-time.After(time.Second):
+		t.Fatal("expected debounced change to be published")
+	}
+	assert.Equal(t, 2, changed(), "expected a single change for the burst")
+	assert.Equal(t, "10.0.0.4", watch.Changes().Updated[0].Address)
+	assert.Nil(t, watch.debouncer.C())
+}
+
+func TestDebouncerMaxWait(t *testing.T) {
+	assert.Nil(t, newDebouncer(0, 0).C(), "expected no debouncer without debounce")
+
+	d := newDebouncer(time.Second, 2*time.Second)
+	defer d.stop()
+	// the burst started long enough ago that we've hit the maxWait
+	d.change(time.Now().Add(-2 * time.Second))
+	d.change(time.Now())
+	select {
+	case <-d.C():
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("expected change to be published after maxWait")
+	}
+}
+
 func runWatchTest(cfg *Config, count int, disc discovery.Backend) map[events.Event]int {
 	bus := events.NewEventBus()
 	cfg.Validate(disc)